@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dlclark/regexp2"
+)
+
+// our file that finds every match of the given regex and options in the arg,
+// calling the engine again from the end of each match, and outputs them in order
+
+func main() {
+	re := regexp2.MustCompile(__PATTERN__, __OPTIONS__)
+
+	m, err := re.FindStringMatch(os.Args[1])
+	for ; m != nil && err == nil; m, err = re.FindNextMatch(m) {
+		fmt.Printf("%v: %s\n", m.Index, m.String())
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+	}
+}
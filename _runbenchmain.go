@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+// our file that times matching the given regex and options against the first arg,
+// the second arg is the number of times to match, the total nanoseconds are written out
+
+func main() {
+	re := regexp2.MustCompile(__PATTERN__, __OPTIONS__)
+
+	input := os.Args[1]
+	n, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		panic(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if _, err := re.MatchString(input); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Println(time.Since(start).Nanoseconds())
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+)
+
+// our file that prints what the generated engine for the pattern
+// exposes about itself, for tests that check the generated methods
+
+func main() {
+	e := MyPattern_Engine{}
+	fmt.Printf("Pattern: %q\n", e.Pattern())
+	fmt.Printf("Options: %d\n", e.Options())
+	fmt.Printf("CapNames: %v\n", e.CapNames())
+	fmt.Printf("Caps: %v\n", e.Caps())
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// our file that outputs what the method a code generation option adds to the engine
+// (FindStruct, FindStringSubmatchIndex, Match or ExplainMatch) returns for the arg
+
+func main() {
+	e := reflect.ValueOf(MyPattern_Engine{})
+	for _, name := range []string{"FindStruct", "FindStringSubmatchIndex", "Match", "ExplainMatch"} {
+		m := e.MethodByName(name)
+		if !m.IsValid() {
+			continue
+		}
+		for i, res := range m.Call([]reflect.Value{reflect.ValueOf(os.Args[1])}) {
+			if i > 0 {
+				fmt.Print(" ")
+			}
+			fmt.Printf("%+v", res)
+		}
+		fmt.Println()
+		return
+	}
+	fmt.Println("no method to call")
+}
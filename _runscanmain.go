@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// our file that finds every match in the arg with the generated Scan method, starting
+// each search where the previous match ended, and outputs them in order
+
+func main() {
+	input := []rune(os.Args[1])
+	for start := -1; start <= len(input); {
+		m, err := MyPattern_Engine{}.Scan(input, start)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return
+		}
+		if m == nil {
+			return
+		}
+		fmt.Printf("%v: %s\n", m.Index, m.String())
+		start = m.Index + m.Length
+		if m.Length == 0 {
+			start++
+		}
+	}
+}
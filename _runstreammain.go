@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// our file that feeds the runes of the arg to the generated MatchRunes
+// one at a time and outputs the result and how many runes it pulled,
+// then does the same through MatchReader
+
+func main() {
+	runes := []rune(os.Args[1])
+	read := 0
+	next := func() (rune, bool) {
+		if read == len(runes) {
+			return 0, false
+		}
+		read++
+		return runes[read-1], true
+	}
+
+	matched := MyPattern_Engine{}.MatchRunes(next)
+	fmt.Printf("Match: %v, Read: %d\n", matched, read)
+
+	r := &countingReader{r: strings.NewReader(os.Args[1])}
+	matched, err := MyPattern_Engine{}.MatchReader(r)
+	fmt.Printf("Reader: %v, Read: %d, Err: %v\n", matched, r.read, err)
+
+	// a reader that fails instead of ending only makes a difference if the match reads past the input
+	r = &countingReader{r: strings.NewReader(os.Args[1]), endErr: errors.New("read failed")}
+	matched, err = MyPattern_Engine{}.MatchReader(r)
+	fmt.Printf("Failing reader: %v, Err: %v\n", matched, err)
+}
+
+type countingReader struct {
+	r      io.RuneReader
+	read   int
+	endErr error
+}
+
+func (c *countingReader) ReadRune() (rune, int, error) {
+	ch, size, err := c.r.ReadRune()
+	if err == io.EOF && c.endErr != nil {
+		err = c.endErr
+	}
+	if err == nil {
+		c.read++
+	}
+	return ch, size, err
+}
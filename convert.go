@@ -4,19 +4,158 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
 	"strconv"
 
 	"fmt"
 	"io"
 	"reflect"
+	"runtime/debug"
 	"slices"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/dlclark/regexp2/syntax"
 	"github.com/pkg/errors"
 )
 
+// Options controls how the Go code is generated. These are independent of the
+// regexp2 options used to parse each pattern.
+//
+// The JSON names of the fields are the command line flags that set them, see ParseOptions.
+type Options struct {
+	// Try the branches of a top-level alternation of literals longest first. This
+	// approximates POSIX leftmost-longest semantics for patterns like (a|ab).
+	LongestFirstAlternation bool `json:"longest"`
+
+	// Check that a literal required in the middle of the pattern, e.g. the "-id-" in [a-z]+\d+-id-\d+,
+	// occurs in the input before searching for a starting position.
+	InteriorLiteralSearch bool `json:"interiorliteral"`
+
+	// Experimental: also emit a MatchRunes method that matches runes pulled one at a time from a
+	// callback, for sources where the whole input isn't available. Only emitted for patterns that
+	// never backtrack and need at most one rune of lookahead, see canStreamMatch.
+	StreamMatch bool `json:"stream"`
+
+	// For patterns with named groups, also emit a struct with a field per group and a
+	// FindStruct method that returns the groups of the first match in it.
+	NamedGroupStruct bool `json:"struct"`
+
+	// Also emit a FindStringSubmatchIndex method that returns the groups of the first match as byte
+	// offsets into the input, like regexp.Regexp's, for byte-oriented callers. Positions in regexp2
+	// are rune indexes, Execute still captures those, they're translated after the match.
+	ByteOffsets bool `json:"byteoffsets"`
+
+	// Also emit a Match method that returns the groups of the first match in a map, by name for
+	// named groups and by number for the others, e.g. "0" for the whole match.
+	GroupMap bool `json:"groupmap"`
+
+	// With GroupMap, include the groups that didn't match in the map as empty strings
+	// instead of leaving them out.
+	GroupMapEmpty bool `json:"groupmapempty"`
+
+	// Also emit an ExplainMatch method that returns a trace of matching a given input: the
+	// positions tried, alternation branches taken, backtracking and the resulting groups.
+	ExplainMatch bool `json:"explain"`
+
+	// Also emit a Scan method that finds the first match from a starting position with the engine,
+	// so it can be used without compiling the pattern with regexp2 first.
+	ScanMethod bool `json:"scan"`
+
+	// When searching for a leading ASCII set, use a helper that takes the set's lookup table
+	// instead of inlining the set's chars or range into the search.
+	LeadingSetTable bool `json:"leadingsettable"`
+
+	// Add each node's depth and path in the tree dump to the "// Node:" comments in Execute,
+	// to find the code for a node when debugging the generated code.
+	AnnotateNodes bool `json:"annotate"`
+
+	// Add comments to Execute on why a node's code takes the shape it does, e.g. a loop emitted
+	// atomically because nothing after it can backtrack into it, or a search with IndexOf.
+	DecisionComments bool `json:"decisions"`
+
+	// Search for long ASCII literal prefixes a block of positions at a time, see emitIndexOfChunkedHelper.
+	ChunkedPrefixScan bool `json:"chunkedprefix"`
+
+	// Search for long ASCII literal prefixes with Boyer-Moore-Horspool, skipping ahead by a table of
+	// the literal's chars, see emitIndexOfHorspoolHelper. ChunkedPrefixScan is used if both are set.
+	HorspoolPrefixScan bool `json:"horspool"`
+
+	// Check sets made of many ranges, that aren't only ASCII, with a binary search over
+	// a table of the range boundaries instead of the general set lookup.
+	BinarySearchSets bool `json:"binarysearchsets"`
+
+	// Check the sets that need more than a simple expression, e.g. an ASCII lookup table with a
+	// fallback, by calling a closure in a table per pattern, e.g. setFns_MyPattern[0](ch), instead
+	// of inlining each check. Keeps Execute shorter for patterns with several such sets, though
+	// inlining is faster for a pattern that spends its time in one.
+	SetFuncTable bool `json:"setfunctable"`
+
+	// Reject patterns that can match a non-ASCII char: literals of 0x80 and up, negated chars and
+	// sets like . and [^a], and sets with such ranges or Unicode categories, e.g. \p{L} or \w without
+	// RE2 or ECMAScript. A pattern that passes only matches ASCII, so sets that don't reduce to a
+	// simple expression are checked inline against their 128-bit lookup table, and literals not 4
+	// or 8 chars long are compared a byte at a time against string constants. Note that IgnoreCase
+	// adds non-ASCII case equivalents for some letters, e.g. the Kelvin sign for k.
+	AsciiOnly bool `json:"asciionly"`
+
+	// Check the match timeout once at the top of Execute, so a match that only scans forward
+	// over a very large input still gives up at the deadline. Off by default since the check
+	// costs a call per match attempt even when no timeout is configured.
+	EntryTimeoutCheck bool `json:"entrytimeout"`
+
+	// Recover a panic in FindFirstChar or Execute, e.g. an index out of range from a bug in
+	// the generated code, and return it as an error from the match instead, so a bad engine
+	// can't crash the program using it. FindFirstChar can't return an error, so it reports
+	// a candidate and leaves the panic in a map by runner for Execute to return. A panic the
+	// timeout check between them strands is dropped by the runner's next FindFirstChar call.
+	RecoverPanics bool `json:"recover"`
+
+	// Once Execute backtracks to more than a handful of labels, jump to them through one switch at
+	// the bottom of Execute on a local set to the label's number, instead of with a goto each.
+	BacktrackDispatch bool `json:"backtrackswitch"`
+
+	// The most chars of a fixed-length repeater, e.g. [a-f]{8}, checked one by one in a row instead
+	// of in a loop. Larger unrolls generate more code for fewer branches. Defaults to 16 when 0.
+	MaxUnrollSize int `json:"unroll"`
+
+	// Write the generated code as emitted instead of running it through gofmt, for debugging
+	// the emitter.
+	SkipFormat bool `json:"noformat"`
+
+	// Panic if the converter's own bookkeeping goes wrong while emitting, e.g. sliceStaticPos
+	// isn't where a branch boundary expects it, for debugging the emitter. The tests turn it on.
+	DebugAssertions bool `json:"debugassertions"`
+
+	// Push a cookie under the state each construct saves on the backtracking stack and check it when
+	// the state is popped, so Execute returns an error if the pushes and pops get out of step, for
+	// debugging the emitter.
+	StackCookies bool `json:"stackcookies"`
+
+	// The import path of the helpers package the generated code calls, for a vendored or renamed
+	// copy of github.com/dlclark/regexp2/helpers, which is the default.
+	HelpersPackage string `json:"helpers"`
+
+	// The name the generated code refers to the helpers package by, e.g. myhelpers for
+	// myhelpers.StartsWith. Defaults to the last element of HelpersPackage.
+	HelpersName string `json:"helpersname"`
+
+	// Start the names of the locals that track the match in the engines' FindFirstChar and Execute
+	// methods with this, e.g. _rx_pos for pos: pos, matchStart, Execute's slice and the locals and
+	// labels named per node, e.g. _rx_iteration1, so they can't collide with a name the runner adds.
+	LocalPrefix string `json:"localprefix"`
+
+	// Don't import the helpers package, copy the helpers the generated code uses into it as unexported
+	// functions instead, e.g. helperStartsWith, so the file only depends on regexp2 itself.
+	InlineHelpers bool `json:"inlinehelpers"`
+}
+
+// the helpers package the generated code imports when Options.HelpersPackage isn't set
+const defaultHelpersPackage = "github.com/dlclark/regexp2/helpers"
+
 type converter struct {
 	// buffer for our output
 	buf *bytes.Buffer
@@ -29,16 +168,138 @@ type converter struct {
 
 	convertedNames map[string]int
 
+	// how many pushes onto the backtracking stack have been emitted, see emitExecuteAtomic
+	stackPushes int
+
+	// how many stack cookies have been handed out, see createStackCookie
+	stackCookies int
+
+	// Options.MaxUnrollSize, or the default if it isn't set
+	maxUnrollSize int
+
+	// the name of the function emitted for each set, by the set's String(), see emitSetMatchFunc
+	setMatchFuncs map[string]string
+
+	// the check of ch against each set in a pattern's table, by the set's String(), see emitSetFuncTableEntry
+	setFuncTableExprs map[string]string
+
+	// the import path of the helpers package, and the qualifier for calls into it, e.g. "helpers."
+	helpersPackage string
+	helpers        string
+
+	// the helpers the generated code calls, by name without the qualifier, see helper
+	usedHelpers map[string]bool
+
+	// package level Regexps to declare and compile in init once the engines are registered, see addRegexpVar
+	regexpVars []regexpVar
+
+	opts Options
+
+	packageName string
+
 	err error
 }
 
-func newConverter(out io.Writer, packageName string) (*converter, error) {
+// Generate writes a Go file in the given package to w with the engine for a single pattern, named
+// MyPattern_Engine, the same as running regexp2cg with -expr. The engine is registered with regexp2
+// when the package is initialized, so regexp2.MustCompile(pattern, regexOpts) uses it.
+func Generate(w io.Writer, packageName string, pattern string, regexOpts syntax.RegexOptions, opts Options) error {
+	_, err := generate(w, packageName, pattern, regexOpts, opts)
+	return err
+}
+
+// Spec is one pattern for GenerateAll
+type Spec struct {
+	// the engine is named <Name>_Engine, numbered if another spec has the same name
+	Name    string
+	Pattern string
+	Options syntax.RegexOptions
+}
+
+// GenerateAll writes a Go file in the given package to w with the engines for all the specs,
+// registered with regexp2 the same as Generate. The file has one import block and one copy of the
+// helpers the engines share, e.g. set lookups. Specs with the same pattern and options share an
+// engine. Nothing is written if any pattern can't be converted.
+func GenerateAll(w io.Writer, packageName string, specs []Spec, opts Options) error {
+	if len(specs) == 0 {
+		return errors.New("no patterns to generate")
+	}
+	c, err := newConverter(w, packageName, opts)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if !token.IsIdentifier(spec.Name) {
+			return fmt.Errorf("spec name %q isn't a Go identifier", spec.Name)
+		}
+		if err := c.addRegexp(spec.Name, spec.Name, spec.Pattern, spec.Options); err != nil {
+			return errors.Wrapf(err, "%s", spec.Name)
+		}
+	}
+	return c.addFooter()
+}
+
+// the converter Generate used, for anything written after the engine like the differential test
+func generate(w io.Writer, packageName string, pattern string, regexOpts syntax.RegexOptions, opts Options) (*converter, error) {
+	c, err := newConverter(w, packageName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.addRegexp("command line", "MyPattern", pattern, regexOpts); err != nil {
+		return nil, err
+	}
+	if err := c.addFooter(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConverter(out io.Writer, packageName string, opts Options) (*converter, error) {
 	c := &converter{
-		buf:             &bytes.Buffer{},
-		out:             out,
-		requiredHelpers: make(map[string]string),
-		convertedNames:  make(map[string]int),
+		buf:               &bytes.Buffer{},
+		out:               out,
+		opts:              opts,
+		packageName:       packageName,
+		requiredHelpers:   make(map[string]string),
+		convertedNames:    make(map[string]int),
+		setMatchFuncs:     make(map[string]string),
+		setFuncTableExprs: make(map[string]string),
+		usedHelpers:       make(map[string]bool),
+		helpersPackage:    defaultHelpersPackage,
+		maxUnrollSize:     defaultMaxUnrollSize,
 	}
+	if opts.InlineHelpers {
+		if opts.HelpersName != "" || (opts.HelpersPackage != "" && opts.HelpersPackage != defaultHelpersPackage) {
+			return nil, errors.New("the helpers can't be both inlined and imported from another package")
+		}
+		c.helpersPackage = ""
+		c.helpers = inlineHelpersPrefix
+	} else {
+		if opts.HelpersPackage != "" {
+			c.helpersPackage = opts.HelpersPackage
+		}
+		helpersName := opts.HelpersName
+		if helpersName == "" {
+			helpersName = lastPathElement(c.helpersPackage)
+		}
+		if !token.IsIdentifier(helpersName) {
+			return nil, fmt.Errorf("helpers package name %q isn't a Go identifier", helpersName)
+		}
+		c.helpers = helpersName + "."
+	}
+
+	if opts.MaxUnrollSize < 0 {
+		return nil, fmt.Errorf("max unroll size %v is negative", opts.MaxUnrollSize)
+	} else if opts.MaxUnrollSize > 0 {
+		c.maxUnrollSize = opts.MaxUnrollSize
+	}
+
+	if opts.LocalPrefix != "" {
+		if !token.IsIdentifier(opts.LocalPrefix) {
+			return nil, fmt.Errorf("local prefix %q isn't a Go identifier", opts.LocalPrefix)
+		}
+	}
+
 	if err := c.addHeader(packageName); err != nil {
 		return nil, err
 	}
@@ -46,6 +307,11 @@ func newConverter(out io.Writer, packageName string) (*converter, error) {
 	return c, nil
 }
 
+// the default name of the package at an import path
+func lastPathElement(importPath string) string {
+	return importPath[strings.LastIndex(importPath, "/")+1:]
+}
+
 func (c *converter) addHeader(packageName string) error {
 	// TODO: this
 	// add package and imports
@@ -59,9 +325,30 @@ func (c *converter) addHeader(packageName string) error {
 	c.writeLineFmt("package %s", packageName)
 	c.writeLine("import (")
 	c.writeLine("  \"github.com/dlclark/regexp2\"")
-	c.writeLine("  \"github.com/dlclark/regexp2/helpers\"")
+	if c.opts.InlineHelpers {
+		// the helpers are copied into the file in addFooter
+	} else if name := strings.TrimSuffix(c.helpers, "."); name != lastPathElement(c.helpersPackage) {
+		c.writeLineFmt("  %s %q", name, c.helpersPackage)
+	} else {
+		c.writeLineFmt("  %q", c.helpersPackage)
+	}
 	c.writeLine("  \"github.com/dlclark/regexp2/syntax\"")
 	c.writeLine("  \"unicode\"")
+	if c.opts.StreamMatch {
+		c.writeLine("  \"io\"")
+	}
+	if c.opts.ExplainMatch || c.opts.RecoverPanics || c.opts.StackCookies {
+		c.writeLine("  \"fmt\"")
+	}
+	if c.opts.ExplainMatch {
+		c.writeLine("  \"strings\"")
+	}
+	if c.opts.ExplainMatch || c.opts.RecoverPanics {
+		c.writeLine("  \"sync\"")
+	}
+	if c.opts.RecoverPanics {
+		c.writeLine("  \"sync/atomic\"")
+	}
 	//c.writeLine("  \"fmt\"")
 	c.writeLine(")")
 
@@ -75,26 +362,69 @@ func (c *converter) addFooter() error {
 		   }
 	*/
 
-	// emit helpers
-	for _, val := range c.requiredHelpers {
-		c.writeLine(val)
+	// emit helpers, sorted so the same patterns always generate the same file
+	names := make([]string, 0, len(c.requiredHelpers))
+	for name := range c.requiredHelpers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		c.writeLine(c.requiredHelpers[name])
+	}
+	if c.opts.InlineHelpers {
+		helpers, err := usedInlineHelpers(c.usedHelpers)
+		if err != nil {
+			return err
+		}
+		for _, helper := range helpers {
+			c.writeLine(helper)
+		}
+	}
+
+	if len(c.regexpVars) > 0 {
+		c.writeLine("// compiled in init, after the engines are registered, so they use them\nvar (")
+		for _, v := range c.regexpVars {
+			c.writeLineFmt("%s *regexp2.Regexp", v.name)
+		}
+		c.writeLine(")")
 	}
 
 	// emit init func
 	c.writeLine("func init() {")
 	for _, rm := range c.data {
 		c.writeLineFmt("regexp2.RegisterEngine(%v, %v, &%s_Engine{})", getGoLiteral(rm.Pattern), getOptString(rm.Options), rm.GeneratedName)
+		if c.needsEngineRegexp(rm) {
+			c.writeLineFmt("%s_regexp = regexp2.MustCompile(%v, %v)", rm.GeneratedName, getGoLiteral(rm.Pattern), getOptString(rm.Options))
+		}
+		if c.opts.ExplainMatch {
+			c.writeLineFmt("regexp2.RegisterEngine(%v, %v, %s_explain)", getGoLiteral(explainPattern(rm)), getOptString(rm.Options), rm.GeneratedName)
+		}
+	}
+	for _, v := range c.regexpVars {
+		c.writeLineFmt("%s = regexp2.MustCompile(%v, %v)", v.name, getGoLiteral(v.pattern), getOptString(v.opts))
 	}
 	// emit basic usage of imports so we don't have to deal with import re-writing
-	c.writeLine("var _ = helpers.Min")
+	if !c.opts.InlineHelpers {
+		c.writeLineFmt("var _ = %sMin", c.helpers)
+	}
 	c.writeLine("var _ = syntax.NewCharSetRuntime")
 	c.writeLine("var _ = unicode.IsDigit")
+	if c.opts.StreamMatch {
+		c.writeLine("var _ = io.EOF")
+	}
+	if c.opts.StackCookies {
+		c.writeLine("var _ = fmt.Errorf")
+	}
 	c.writeLine("}")
 
-	//format the code
-	origCode := c.buf.Bytes()
-	fmtOut, err := format.Source(origCode)
+	origCode := append(c.fileHeader(), c.buf.Bytes()...)
+	if c.opts.SkipFormat {
+		c.out.Write(origCode)
+		return c.err
+	}
 
+	//format the code
+	fmtOut, err := formatSource(origCode)
 	if err != nil {
 		c.out.Write(origCode)
 		return err
@@ -104,6 +434,117 @@ func (c *converter) addFooter() error {
 	return c.err
 }
 
+// the comment at the top of the file, with the standard generated code marker and
+// what the file was generated from
+func (c *converter) fileHeader() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by regexp2cg; DO NOT EDIT.\n//\n")
+	fmt.Fprintf(buf, "// regexp2 version: %s\n", regexp2Version())
+	if len(c.data) > 0 {
+		buf.WriteString("//\n// Patterns:\n")
+	}
+	for _, rm := range c.data {
+		fmt.Fprintf(buf, "//   %s: %s, %s\n", rm.SourceLocation, getGoLiteral(rm.Pattern), getOptString(rm.Options))
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// the version of regexp2 we're built with, the generated code needs the same one
+func regexp2Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/dlclark/regexp2" {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Version == "" {
+			// replaced with a local directory
+			return dep.Path
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
+
+// runs gofmt on the generated code, if it doesn't parse the error includes
+// the lines around the first problem
+func formatSource(code []byte) ([]byte, error) {
+	out, err := format.Source(code)
+	if err == nil {
+		return out, nil
+	}
+
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return nil, errors.Wrap(err, "error formatting generated code")
+	}
+	return nil, errors.Wrapf(err, "generated code doesn't parse:\n%s", codeSnippet(code, list[0].Pos.Line))
+}
+
+// parses the code generated for a single pattern so emitter bugs show up here, pointing
+// at the node being emitted, rather than when the output is compiled
+func verify(rm *regexpData, code string) error {
+	const header = "package p\n"
+	_, err := parser.ParseFile(token.NewFileSet(), "", header+code, parser.SkipObjectResolution)
+	if err == nil {
+		return nil
+	}
+
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return errors.Wrapf(err, "generated code for %v doesn't parse", getGoLiteral(rm.Pattern))
+	}
+	// line numbers without our header
+	line := list[0].Pos.Line - 1
+	return errors.Errorf("generated code for %v doesn't parse%s: %v:%v: %s\n%s",
+		getGoLiteral(rm.Pattern), nodeAtLine(code, line), line, list[0].Pos.Column, list[0].Msg, codeSnippet([]byte(code), line))
+}
+
+// finds the last "// Node: " comment at or before line, so errors can say which node
+// was being emitted
+func nodeAtLine(code string, line int) string {
+	lines := strings.Split(code, "\n")
+	for i := min(line, len(lines)) - 1; i >= 0; i-- {
+		if node, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "// Node: "); ok {
+			return " in " + node
+		}
+	}
+	return ""
+}
+
+// returns the lines of code around line (1-based), numbered, with line marked
+func codeSnippet(code []byte, line int) string {
+	const context = 3
+	lines := strings.Split(string(code), "\n")
+	buf := &bytes.Buffer{}
+	for i := max(line-context, 1); i <= min(line+context, len(lines)); i++ {
+		marker := "  "
+		if i == line {
+			marker = "> "
+		}
+		fmt.Fprintf(buf, "%s%4d| %s\n", marker, i, lines[i-1])
+	}
+	return buf.String()
+}
+
+type regexpVar struct {
+	name    string
+	pattern string
+	opts    syntax.RegexOptions
+}
+
+// Adds a package level *regexp2.Regexp with the given name to the generated file, compiled from
+// a pattern that's been added with addRegexp. Several names can share a pattern.
+func (c *converter) addRegexpVar(name, pattern string, opt syntax.RegexOptions) {
+	c.regexpVars = append(c.regexpVars, regexpVar{name: name, pattern: pattern, opts: opt})
+}
+
 type regexpData struct {
 	SourceLocation string
 	GeneratedName  string
@@ -121,6 +562,17 @@ type regexpData struct {
 	// another writer so we can merge at the end
 	additionalDeclarations []string
 
+	// emitting the tracing Execute for ExplainMatch
+	explain bool
+
+	// the top-level alternation whose branches are tried longest first, see Options.LongestFirstAlternation
+	longestFirst *syntax.RegexNode
+
+	// Options.LocalPrefix, which starts the names from reserveName, sliceSpan, pos and matchStart
+	localPrefix string
+	// the names of the pos and matchStart locals
+	pos, matchStart string
+
 	// state during emitExecute
 	usedNames             map[string]int
 	sliceSpan             string
@@ -129,10 +581,37 @@ type regexpData struct {
 	expressionHasCaptures bool
 	doneLabel             string
 
+	// set once the code emitted so far may have captured, see emitExecuteGoto
+	mayHaveCaptured bool
+
+	// set once the code emitted since slice was last loaded may have moved pos without reloading
+	// it, e.g. right-to-left code, or can be jumped to, see transferSliceStaticPosToPos
+	sliceDirty bool
+
 	// track our labels since Go doesn't like unused labels, we need to find them and
 	// remove them as a post-process step
 	emittedLabels []string
 	usedLabels    []string
+
+	// the first node emitExecuteNode had no code for, addRegexp returns it instead of the code
+	err error
+
+	// the String() of each set in the pattern's table of set checks, by index, see emitSetFuncTableEntry
+	setFuncTable []string
+
+	// how many cases of a switch on the alternation's branches the code being emitted is in, and
+	// the labels marked in one, which only code in the same case can jump to, see backtrackDispatchLabels
+	switchCaseDepth  int
+	switchCaseLabels []string
+
+	// the labels jumped to when backtracking, see reserveBacktrackLabel
+	backtrackLabels []string
+
+	// the backtracking labels Execute jumps to through a switch on dispatchLocal at dispatchLabel
+	// instead of directly, see backtrackDispatchLabels
+	dispatchLabels []string
+	dispatchLocal  string
+	dispatchLabel  string
 	//TODO: timeout?
 	//TODO: string vs rune vs byte?
 }
@@ -170,9 +649,34 @@ func (c *converter) addRegexp(sourceLocation, name string, txt string, opt synta
 	if err != nil {
 		return errors.Wrap(err, "error parsing regexp")
 	}
+	// computing the Compiled find optimizations can merge sets into the tree's own set nodes
+	// (e.g. abc|abd|xyz|xyw ends up with [cdwz] after ab), so take the nodes from a plain parse
+	// and keep only the optimizations from the Compiled one
+	plain, err := syntax.Parse(txt, opt)
+	if err != nil {
+		return errors.Wrap(err, "error parsing regexp")
+	}
+	if opts := tree.FindOptimizations; opts != nil && opts.LiteralAfterLoop != nil {
+		opts.LiteralAfterLoop.LoopNode = findMatchingNode(tree.Root, plain.Root, opts.LiteralAfterLoop.LoopNode)
+		if opts.LiteralAfterLoop.LoopNode == nil {
+			return errors.New("error parsing regexp: the leading loop isn't in the same place in both parses")
+		}
+	}
+	tree.Root = plain.Root
+	var longestFirst *syntax.RegexNode
+	if c.opts.LongestFirstAlternation && opt&syntax.IgnorePatternWhitespace == 0 {
+		// the parser reduces alternations as it goes (e.g. a|ab becomes just a when nothing
+		// follows), so put the pattern's own branches back for emitExecuteAlternation to order
+		longestFirst = restoreLiteralAlternation(tree, txt, opt)
+	}
 	if err := supportsCodeGen(tree); err != nil {
 		return errors.Wrap(err, "code generation not supported")
 	}
+	if c.opts.AsciiOnly {
+		if err := checkAsciiOnly(tree.Root); err != nil {
+			return errors.Wrap(err, "pattern isn't ASCII only")
+		}
+	}
 
 	// generate unique class name
 	newName := name
@@ -200,6 +704,10 @@ func (c *converter) addRegexp(sourceLocation, name string, txt string, opt synta
 		Options:        opt,
 		Tree:           tree,
 		Analysis:       analyze(tree),
+		longestFirst:   longestFirst,
+		localPrefix:    c.opts.LocalPrefix,
+		pos:            c.opts.LocalPrefix + "pos",
+		matchStart:     c.opts.LocalPrefix + "matchStart",
 	}
 	c.data = append(c.data, rm)
 
@@ -209,20 +717,141 @@ func (c *converter) addRegexp(sourceLocation, name string, txt string, opt synta
 	// the C# version has a "scan" function above these that I've omitted here
 	c.emitFindFirstChar(rm)
 	c.emitExecute(rm)
+	if c.opts.StreamMatch && !rm.Analysis.MayBacktrack(rm.Tree.Root) && canStreamMatch(rm.Tree.Root) {
+		c.emitMatchRunes(rm)
+		c.emitMatchReader(rm)
+	}
+	if c.needsEngineRegexp(rm) {
+		c.emitEngineRegexp(rm)
+	}
+	if c.opts.ScanMethod {
+		c.emitScan(rm)
+	}
+	if c.needsFindStruct(rm) {
+		c.emitFindStruct(rm)
+	}
+	if c.opts.ByteOffsets {
+		c.emitFindStringSubmatchIndex(rm)
+	}
+	if c.opts.GroupMap {
+		c.emitMatchGroupMap(rm)
+	}
 
 	// get our string for final manipulation
 	output := c.buf.String()
 	c.buf = oldOut
+	if rm.err != nil {
+		// nothing's written for the pattern, so it mustn't be registered either
+		c.data = c.data[:len(c.data)-1]
+		return errors.Wrap(rm.err, "code generation failed")
+	}
 
 	// finalize our code
 	removeUnusedLabels(&output, rm)
+	if err := verify(rm, output); err != nil {
+		return err
+	}
 
 	// write our temp out buffer into our saved buffer
 	c.buf.Write([]byte(output))
 
+	if c.opts.ExplainMatch {
+		if err := c.emitExplainMatch(rm); err != nil {
+			return err
+		}
+	}
+
 	return c.err
 }
 
+// walks two trees with the same shape together and returns the node in other
+// at the same place as target is in root, or nil if it isn't found or the shapes
+// differ on the way to it
+func findMatchingNode(root, other, target *syntax.RegexNode) *syntax.RegexNode {
+	if root.T != other.T || len(root.Children) != len(other.Children) {
+		return nil
+	}
+	if root == target {
+		return other
+	}
+	for i, child := range root.Children {
+		if n := findMatchingNode(child, other.Children[i], target); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// Replaces the tree's parse of a top-level alternation of literals with one node holding each of the
+// pattern's branches as written, and returns it, or nil if the pattern isn't such an alternation.
+// The alternation can be the whole pattern or the body of a single group around the whole pattern.
+func restoreLiteralAlternation(tree *syntax.RegexTree, pattern string, opt syntax.RegexOptions) *syntax.RegexNode {
+	group, branches := splitLiteralAlternation(pattern)
+	if branches == nil {
+		return nil
+	}
+
+	parent := tree.Root
+	if group == "(" {
+		parent = tree.Root.Children[0]
+		if parent.T != syntax.NtCapture {
+			return nil
+		}
+	}
+
+	alternation := &syntax.RegexNode{T: syntax.NtAlternate, Options: opt, Parent: parent}
+	for _, branch := range branches {
+		t, err := syntax.Parse(branch, opt)
+		if err != nil {
+			return nil
+		}
+		child := t.Root.Children[0]
+		child.Parent = alternation
+		alternation.Children = append(alternation.Children, child)
+	}
+	parent.Children = []*syntax.RegexNode{alternation}
+	return alternation
+}
+
+// Splits a top-level alternation into its branches, e.g. (?:x|xyz|xy) into "(?:" and x, xyz and xy.
+// Only alternations where every branch is a plain literal are split, which keeps capture
+// numbering stable whatever order the branches are tried in; for anything else branches is nil.
+func splitLiteralAlternation(pattern string) (group string, branches []string) {
+	body := pattern
+	if strings.HasPrefix(pattern, "(") && strings.HasSuffix(pattern, ")") {
+		group, body = "(", pattern[1:len(pattern)-1]
+		if strings.HasPrefix(body, "?:") {
+			group, body = "(?:", body[2:]
+		}
+	}
+
+	start := 0
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		switch ch := runes[i]; {
+		case ch == '\\':
+			// escaped punctuation is a literal, anything else (\d, \w, \1...) isn't
+			if i+1 == len(runes) || unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1]) {
+				return "", nil
+			}
+			i++
+		case ch == '|':
+			branches = append(branches, string(runes[start:i]))
+			start = i + 1
+		case strings.ContainsRune("()[]{}*+?.^$", ch):
+			return "", nil
+		}
+	}
+	branches = append(branches, string(runes[start:]))
+
+	if len(branches) < 2 {
+		return "", nil
+	}
+	return group, branches
+}
+
+// Go won't compile a label that's never jumped to, so remove the labels from emitMarkLabel that
+// no goto from emitExecuteGoto used, e.g. the backtracking labels of a pattern that never backtracks
 func removeUnusedLabels(output *string, rm *regexpData) {
 	unusedLabels := rm.unusedLabels()
 
@@ -247,6 +876,8 @@ func (c *converter) emitRegexStart(rm *regexpData) {
 		func (MyPattern0_Engine) CapNames() map[string]int { return map[string]int{} }
 		func (MyPattern0_Engine) CapsList() []string       { return []string{} }
 		func (MyPattern0_Engine) CapSize() int             { return 1 }
+		func (MyPattern0_Engine) Options() regexp2.RegexOptions { return regexp2.ECMAScript }
+		func (MyPattern0_Engine) Pattern() string               { return "[ABCD]+" }
 	*/
 	caps, capsize := getCaps(rm.Tree)
 	rm.Tree.Caps = caps
@@ -260,6 +891,8 @@ func (c *converter) emitRegexStart(rm *regexpData) {
 	c.writeLineFmt("func (%s_Engine) CapNames() map[string]int { return %s }", rm.GeneratedName, getGoLiteral(rm.Tree.Capnames))
 	c.writeLineFmt("func (%s_Engine) CapsList() []string { return %s }", rm.GeneratedName, getGoLiteral(rm.Tree.Caplist))
 	c.writeLineFmt("func (%s_Engine) CapSize() int { return %v }", rm.GeneratedName, capsize)
+	c.writeLineFmt("func (%s_Engine) Options() regexp2.RegexOptions { return %s }", rm.GeneratedName, getOptString(rm.Options))
+	c.writeLineFmt("func (%s_Engine) Pattern() string { return %s }", rm.GeneratedName, getGoLiteral(rm.Pattern))
 	c.writeLine("")
 }
 
@@ -408,7 +1041,7 @@ func isAscii(chars []rune) bool {
 	return true
 }
 
-func (c *converter) emitIndexOfChars(chars []rune, negate bool, spanName string) string {
+func (c *converter) emitIndexOfChars(chars []rune, negate bool, useLast bool, spanName string) string {
 	// We have a chars array, so we can use IndexOf{Any}{Except} to search for it. Choose the best overload.
 	// 1, 2, 3 have dedicated optimized IndexOfAny overloads
 	// 4, 5 have dedicated optimized IndexOfAny overloads accessible via the ReadOnlySpan<char> overload,
@@ -418,24 +1051,110 @@ func (c *converter) emitIndexOfChars(chars []rune, negate bool, spanName string)
 	if negate {
 		indexOfAnyName = "IndexOfAnyExcept"
 	}
+	if useLast {
+		indexOfAnyName = "Last" + indexOfAnyName
+	}
 
 	switch len(chars) {
 	case 1:
-		return fmt.Sprintf("helpers.%s1(%s, %q)", indexOfAnyName, spanName, chars[0])
+		return fmt.Sprintf("%s(%s, %q)", c.helper(indexOfAnyName+"1"), spanName, chars[0])
 	case 2:
-		return fmt.Sprintf("helpers.%s2(%s, %q, %q)", indexOfAnyName, spanName, chars[0], chars[1])
+		if useLast {
+			return fmt.Sprintf("%s(%s, %q, %q)", c.emitLastIndexOfAnyHelper(negate, 2), spanName, chars[0], chars[1])
+		}
+		return fmt.Sprintf("%s(%s, %q, %q)", c.helper(indexOfAnyName+"2"), spanName, chars[0], chars[1])
 	case 3:
-		return fmt.Sprintf("helpers.%s3(%s, %q, %q, %q)", indexOfAnyName, spanName, chars[0], chars[1], chars[2])
+		if useLast {
+			return fmt.Sprintf("%s(%s, %q, %q, %q)", c.emitLastIndexOfAnyHelper(negate, 3), spanName, chars[0], chars[1], chars[2])
+		}
+		return fmt.Sprintf("%s(%s, %q, %q, %q)", c.helper(indexOfAnyName+"3"), spanName, chars[0], chars[1], chars[2])
 	case 4, 5:
-		if shouldUseSearchValues(chars) {
-			return fmt.Sprintf("%s.%s(%s)", c.emitSearchValues(chars, ""), indexOfAnyName, spanName)
-		} else {
-			return fmt.Sprintf("helpers.%s(%s, %s)", indexOfAnyName, spanName, getRuneSliceLiteral(chars))
+		// there's no LastIndexOfAny taking a rune slice, search values have one
+		if !useLast && !shouldUseSearchValues(chars) {
+			return fmt.Sprintf("%s(%s, %s)", c.helper(indexOfAnyName), spanName, getRuneSliceLiteral(chars))
 		}
 	}
 	return fmt.Sprintf("%s.%s(%s)", c.emitSearchValues(chars, ""), indexOfAnyName, spanName)
 }
 
+// Emits a helper for the LastIndexOfAny2/3 and LastIndexOfAnyExcept2/3 forms, which the helpers
+// package doesn't have, and returns its name.
+func (c *converter) emitLastIndexOfAnyHelper(negate bool, count int) string {
+	name := fmt.Sprintf("lastIndexOfAny%v", count)
+	param, op, join := "find", "==", " || "
+	if negate {
+		name = fmt.Sprintf("lastIndexOfAnyExcept%v", count)
+		param, op, join = "bad", "!=", " && "
+	}
+
+	if _, ok := c.requiredHelpers[name]; !ok {
+		params := make([]string, count)
+		clauses := make([]string, count)
+		for i := range params {
+			params[i] = fmt.Sprint(param, i+1)
+			clauses[i] = fmt.Sprintf("ch %s %s", op, params[i])
+		}
+		c.requiredHelpers[name] = fmt.Sprintf(`// Returns the index of the last rune where %s, or -1 if there isn't one
+			func %s(in []rune, %s rune) int {
+				for i := len(in) - 1; i >= 0; i-- {
+					if ch := in[i]; %s {
+						return i
+					}
+				}
+				return -1
+			}`, strings.Join(clauses, join), name, strings.Join(params, ", "), strings.Join(clauses, join))
+	}
+
+	return name
+}
+
+// Emits a helper for LastIndexOfAnyExceptInRange, which the helpers package doesn't have, and returns its name.
+func (c *converter) emitLastIndexOfAnyExceptInRangeHelper() string {
+	name := "lastIndexOfAnyExceptInRange"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = `// Returns the index of the last rune outside of first to last (inclusive), or -1 if there isn't one
+			func lastIndexOfAnyExceptInRange(in []rune, first, last rune) int {
+				for i := len(in) - 1; i >= 0; i-- {
+					if ch := in[i]; ch < first || ch > last {
+						return i
+					}
+				}
+				return -1
+			}`
+	}
+	return name
+}
+
+// Emits a helper that searches for the first (or last) rune in (or outside of) either of two ranges, which
+// the helpers package doesn't have, and returns its name.
+func (c *converter) emitIndexOfAnyInRangesHelper(negate bool, useLast bool) string {
+	name, desc := "indexOfAnyInRanges", "in first1 to last1 or first2 to last2"
+	// uint32(ch-first) <= uint32(last-first) is first <= ch && ch <= last with one compare
+	cond := "uint32(ch-first1) <= uint32(last1-first1) || uint32(ch-first2) <= uint32(last2-first2)"
+	if negate {
+		name, desc = "indexOfAnyExceptInRanges", "outside of both first1 to last1 and first2 to last2"
+		cond = "uint32(ch-first1) > uint32(last1-first1) && uint32(ch-first2) > uint32(last2-first2)"
+	}
+	loop, which := "for i, ch := range in {", "first"
+	if useLast {
+		name = "last" + strings.ToUpper(name[:1]) + name[1:]
+		loop, which = "for i := len(in) - 1; i >= 0; i-- {\n\t\t\t\tch := in[i]", "last"
+	}
+
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = fmt.Sprintf(`// Returns the index of the %s rune %s (inclusive), or -1 if there isn't one
+			func %s(in []rune, first1, last1, first2, last2 rune) int {
+				%s
+					if %s {
+						return i
+					}
+				}
+				return -1
+			}`, which, desc, name, loop, cond)
+	}
+	return name
+}
+
 var emitSearchValueConstNames = map[string]string{
 	"FFFFFFFF000000000000000000000080": "svAsciiControl",
 	"000000000000FF030000000000000000": "svAsciiDigits",
@@ -487,22 +1206,18 @@ func (c *converter) emitSearchValues(chars []rune, fieldName string) string {
 	if _, ok := c.requiredHelpers[fieldName]; !ok {
 		if asciiOnly {
 			c.requiredHelpers[fieldName] = fmt.Sprintf(`// Supports searching for the chars in or not in %#v
-			var %v = helpers.NewAsciiSearchValues(%#v)`,
-				string(chars), fieldName, string(chars))
+			var %v = %s(%#v)`,
+				string(chars), fieldName, c.helper("NewAsciiSearchValues"), string(chars))
 		} else {
 			c.requiredHelpers[fieldName] = fmt.Sprintf(`// Supports searching for the chars in or not in %#v
-			var %v = helpers.NewRuneSearchValues(%#v)`,
-				string(chars), fieldName, string(chars))
+			var %v = %s(%#v)`,
+				string(chars), fieldName, c.helper("NewRuneSearchValues"), string(chars))
 		}
 	}
 
 	return fieldName
 }
 
-func (c *converter) emitGoto(label string) {
-	c.writeLineFmt("goto %s", label)
-}
-
 func (c *converter) emitLabel(label string) {
 	c.writeLineFmt("%s:", label)
 }
@@ -534,6 +1249,82 @@ func (c *converter) writeLineFmt(format string, args ...any) {
 func supportsCodeGen(tree *syntax.RegexTree) error {
 	//TODO: filter out invalid trees
 	//https://github.com/dotnet/runtime/blob/main/src/libraries/System.Text.RegularExpressions/gen/RegexGenerator.cs#L296
+	return supportsCodeGenNode(tree.Root)
+}
+
+// the node types emitExecuteNode has an emitter for
+var codeGenNodeTypes = []syntax.NodeType{
+	syntax.NtOneloop, syntax.NtNotoneloop, syntax.NtSetloop,
+	syntax.NtOnelazy, syntax.NtNotonelazy, syntax.NtSetlazy,
+	syntax.NtOneloopatomic, syntax.NtNotoneloopatomic, syntax.NtSetloopatomic,
+	syntax.NtOne, syntax.NtNotone, syntax.NtSet, syntax.NtMulti, syntax.NtRef,
+	syntax.NtBol, syntax.NtEol, syntax.NtBoundary, syntax.NtNonboundary, syntax.NtECMABoundary, syntax.NtNonECMABoundary,
+	syntax.NtBeginning, syntax.NtStart, syntax.NtEndZ, syntax.NtEnd,
+	syntax.NtNothing, syntax.NtEmpty, syntax.NtAlternate, syntax.NtConcatenate, syntax.NtLoop, syntax.NtLazyloop,
+	syntax.NtCapture, syntax.NtPosLook, syntax.NtNegLook, syntax.NtAtomic, syntax.NtBackRefCond, syntax.NtExprCond,
+	syntax.NtUpdateBumpalong,
+}
+
+func supportsCodeGenNode(node *syntax.RegexNode) error {
+	// the parser rejects subroutine calls like (?1) and (?R), but a node type that's added to it
+	// without an emitter here, e.g. for those, fails the conversion instead of panicking in emitExecuteNode
+	if !slices.Contains(codeGenNodeTypes, node.T) {
+		return errors.Errorf("node type %d isn't supported", node.T)
+	}
+	// balancing groups need the runner to transfer the capture (including empty ones)
+	// and regexp2 doesn't export that yet, so leave them to the interpreter
+	if node.T == syntax.NtCapture && node.N != -1 {
+		return errors.New("balancing groups are not supported")
+	}
+	// Go has no literal for a surrogate code point, e.g. \ud83d, formatting one gives '\ufffd',
+	// and the set hash NewCharSetRuntime reads is UTF-8 too, so neither round trips
+	switch {
+	case node.T == syntax.NtMulti:
+		for _, ch := range node.Str {
+			if !utf8.ValidRune(ch) {
+				return errors.Errorf("the char %U in the string isn't supported", ch)
+			}
+		}
+	case node.IsOneFamily() || node.IsNotoneFamily():
+		if !utf8.ValidRune(node.Ch) {
+			return errors.Errorf("the char %U isn't supported", node.Ch)
+		}
+	case node.IsSetFamily():
+		if rt := syntax.NewCharSetRuntime(string(node.Set.Hash())); !rt.Equals(node.Set) {
+			return errors.Errorf("the set %v has surrogate code points, which aren't supported", node.Set.String())
+		}
+	}
+	for _, child := range node.Children {
+		if err := supportsCodeGenNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an error for the first node that can match a non-ASCII char, see Options.AsciiOnly
+func checkAsciiOnly(node *syntax.RegexNode) error {
+	switch {
+	case node.T == syntax.NtMulti:
+		if !isAscii(node.Str) {
+			return errors.Errorf("the string %+q has non-ASCII chars", string(node.Str))
+		}
+	case node.IsOneFamily():
+		if node.Ch > unicode.MaxASCII {
+			return errors.Errorf("the char %+q isn't ASCII", node.Ch)
+		}
+	case node.IsNotoneFamily():
+		return errors.Errorf("the negated char %+q matches non-ASCII chars", node.Ch)
+	case node.IsSetFamily():
+		if a := node.Set.Analyze(); node.Set.IsNegated() || !a.OnlyRanges || a.UpperBoundExclusiveIfOnlyRanges > unicode.MaxASCII+1 {
+			return errors.Errorf("the set %+q matches non-ASCII chars or Unicode categories", node.Set.String())
+		}
+	}
+	for _, child := range node.Children {
+		if err := checkAsciiOnly(child); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -542,7 +1333,21 @@ func (rm *regexpData) reserveName(prefix string) string {
 	num := rm.usedNames[prefix]
 	rm.usedNames[prefix] = num + 1
 	if num == 0 {
-		return prefix
+		return rm.localPrefix + prefix
 	}
-	return fmt.Sprint(prefix, num)
+	return fmt.Sprint(rm.localPrefix, prefix, num)
+}
+
+// Reserves a name for a label that's jumped to when backtracking into a node, which the explain
+// trace reports and Options.BacktrackDispatch can send the jumps to through a switch.
+func (rm *regexpData) reserveBacktrackLabel(prefix string) string {
+	label := rm.reserveName(prefix)
+	rm.backtrackLabels = append(rm.backtrackLabels, label)
+	return label
+}
+
+// Reports whether label is jumped to when backtracking, as opposed to the labels jumped to in
+// order to skip past the backtracking code.
+func (rm *regexpData) isBacktrackLabel(label string) bool {
+	return slices.Contains(rm.backtrackLabels, label)
 }
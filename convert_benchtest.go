@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// about how many runes of input each benchmark runs over when no input is given, made by
+// repeating the pattern's alphabet, see diffTestAlphabet
+const benchDefaultInputLen = 4096
+
+// writes a _test.go file for the converted patterns with a benchmark per pattern that runs
+// MatchString with both the generated engine and regexp2's interpreter over the same input,
+// to measure the speedup. As in the differential test MustCompile returns the registered
+// generated engine and Compile the interpreter. Every benchmark uses input if it isn't empty,
+// otherwise each pattern gets its alphabet repeated to about benchDefaultInputLen runes.
+func (c *converter) writeBenchmarks(out io.Writer, input string) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by regexp2cg; DO NOT EDIT.\n\n")
+	buf.WriteString("package " + c.packageName + "\n\n")
+	buf.WriteString("import (\n")
+	if input == "" {
+		// strings is only used to make the default inputs
+		buf.WriteString("\t\"strings\"\n")
+	}
+	buf.WriteString("\t\"testing\"\n\n\t\"github.com/dlclark/regexp2\"\n)\n")
+	if input != "" {
+		buf.WriteString("\nvar benchmarkInput = " + getGoLiteral(input) + "\n")
+	}
+
+	for _, rm := range c.data {
+		inputExpr := "benchmarkInput"
+		if input == "" {
+			alphabet := diffTestAlphabet(rm.Pattern)
+			inputExpr = "strings.Repeat(" + getGoLiteral(string(alphabet)) + ", " + strconv.Itoa(benchDefaultInputLen/len(alphabet)+1) + ")"
+		}
+		buf.WriteString("\nfunc Benchmark" + rm.GeneratedName + "_MatchString(b *testing.B) {\n")
+		buf.WriteString("\tbenchmarkAgainstInterpreter(b, " + getGoLiteral(rm.Pattern) + ", " + getOptString(rm.Options) + ", " + inputExpr + ")\n")
+		buf.WriteString("}\n")
+	}
+
+	buf.WriteString(`
+// runs MatchString over the input with the generated engine for the pattern and with
+// the interpreter, as sub-benchmarks so they're reported side by side
+func benchmarkAgainstInterpreter(b *testing.B, pattern string, opts regexp2.RegexOptions, input string) {
+	generated := regexp2.MustCompile(pattern, opts)
+	interpreted, err := regexp2.Compile(pattern, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name string
+		re   *regexp2.Regexp
+	}{{"generated", generated}, {"interpreter", interpreted}} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.re.MatchString(input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+`)
+
+	fmtOut, err := formatSource(buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "benchmarks")
+	}
+	if _, err := out.Write(fmtOut); err != nil {
+		return errors.Wrap(err, "benchmarks")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func TestBenchmarks(t *testing.T) {
+	for _, input := range []string{"", "some text with an id x12ab in it\n"} {
+		dir := t.TempDir()
+		genFile, _ := os.Create(filepath.Join(dir, "gen.go"))
+		benchFile, _ := os.Create(filepath.Join(dir, "gen_bench_test.go"))
+		defer genFile.Close()
+		defer benchFile.Close()
+
+		c, err := newConverter(genFile, "gen", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addRegexp("MyFile.go:120:10", "Words", `(?<first>\w+)\s(\w+?)\b`, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addRegexp("MyFile.go:121:10", "Digits", `(?i)x[0-9a-f]{2,4}$`, syntax.Multiline); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addFooter(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.writeBenchmarks(benchFile, input); err != nil {
+			t.Fatal(err)
+		}
+
+		code, _ := os.ReadFile(benchFile.Name())
+		wants := []string{"func BenchmarkWords_MatchString(", "func BenchmarkDigits_MatchString(", `"(?i)x[0-9a-f]{2,4}$", regexp2.Multiline`, "b.ReportAllocs()"}
+		if input == "" {
+			wants = append(wants, "strings.Repeat(")
+		} else {
+			wants = append(wants, `var benchmarkInput = "some text with an id x12ab in it\n"`)
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(code), want) {
+				t.Errorf("expected %q in:\n%s", want, code)
+			}
+		}
+
+		goPath, _ := exec.LookPath("go")
+		cmd := exec.Command(goPath, "test", "-count=1", "-run", "^$", "-bench", ".", "-benchtime", "10x", genFile.Name(), benchFile.Name())
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("generated benchmarks failed: %v\n%s", err, out)
+		}
+		for _, want := range []string{"BenchmarkDigits_MatchString/generated", "BenchmarkDigits_MatchString/interpreter", "allocs/op"} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("expected %q in the benchmark output:\n%s", want, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// Emits FindStringSubmatchIndex, which returns the groups of the first match as byte offsets into
+// the input, like regexp.Regexp's. Positions in regexp2, and the ones Execute captures, are rune
+// indexes into Runtext, so they're translated after the match with runeToByteOffsets.
+func (c *converter) emitFindStringSubmatchIndex(rm *regexpData) {
+	c.requiredHelpers["runeToByteOffsets"] = `// Replaces the rune indexes into s in idx with byte offsets, counting the bytes of s up to the
+		// last index once. Negative indexes are left as they are.
+		func runeToByteOffsets(s string, idx []int) {
+			last := -1
+			for _, v := range idx {
+				if v > last {
+					last = v
+				}
+			}
+			offsets := make([]int, 0, last+1)
+			for b := range s {
+				if len(offsets) > last {
+					break
+				}
+				offsets = append(offsets, b)
+			}
+			// an index can be the end of s
+			offsets = append(offsets, len(s))
+			for i, v := range idx {
+				if v >= 0 {
+					idx[i] = offsets[v]
+				}
+			}
+		}`
+
+	c.writeLineFmt(`// FindStringSubmatchIndex finds the first match in s and returns the byte offsets in s of the
+		// match and its groups, in pairs like regexp.Regexp's, with -1 for a group that didn't match.
+		// It returns nil if there's no match.
+		func (%[1]s_Engine) FindStringSubmatchIndex(s string) []int {
+			m, err := %[1]s_regexp.FindStringMatch(s)
+			if err != nil || m == nil {
+				return nil
+			}
+			groups := m.Groups()
+			idx := make([]int, 0, 2*len(groups))
+			for _, g := range groups {
+				if len(g.Captures) == 0 {
+					idx = append(idx, -1, -1)
+					continue
+				}
+				idx = append(idx, g.Index, g.Index+g.Length)
+			}
+			runeToByteOffsets(s, idx)
+			return idx
+		}
+		`, rm.GeneratedName)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func TestFindStringSubmatchIndex(t *testing.T) {
+	// the offsets are in bytes like the standard library's, é and 日 are more than one
+	pattern := `(\w+)@(\d+)?(x)`
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ByteOffsets: true})
+	for _, input := range []string{"ab@12x", "é日 abé@x", "日本@1x日", "no match"} {
+		want := regexp.MustCompile(`(\pL+)@(\d+)?(x)`).FindStringSubmatchIndex(input)
+		runMatch(t, pattern, exec, input, fmt.Sprint(want))
+	}
+
+	// a match at the end of the input, and right to left
+	pattern = `é$`
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ByteOffsets: true})
+	runMatch(t, pattern, exec, "日é", "[3 5]")
+	pattern = `(日)(.)`
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, syntax.RightToLeft, Options{ByteOffsets: true})
+	runMatch(t, pattern, exec, "日a日é", "[4 9 4 7 7 9]")
+}
+
+func TestFindStringSubmatchIndex_Off(t *testing.T) {
+	if code := generateCode(t, `(a)b`, 0); strings.Contains(code, "FindStringSubmatchIndex") || strings.Contains(code, "runeToByteOffsets") {
+		t.Errorf("unexpected FindStringSubmatchIndex without ByteOffsets")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// runes added to each pattern's own runes when making random inputs, so they
+// include chars that aren't in the pattern, a newline and a char outside the BMP
+const diffTestExtraRunes = "aZ0 _-.\n\té😀"
+
+// writes a _test.go file for the converted patterns that checks each generated engine finds
+// the same matches and groups as regexp2's interpreter. MustCompile returns the registered
+// generated engine while Compile always returns the interpreter, so the test compares the two
+// on a fixed set of inputs and random ones from testing/quick.
+func (c *converter) writeDifferentialTest(out io.Writer) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by regexp2cg; DO NOT EDIT.\n\n")
+	buf.WriteString("package " + c.packageName + "\n\n")
+	buf.WriteString(`import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/dlclark/regexp2"
+)
+`)
+
+	for _, rm := range c.data {
+		alphabet := diffTestAlphabet(rm.Pattern)
+		buf.WriteString("\nfunc Test" + rm.GeneratedName + "_Interpreter(t *testing.T) {\n")
+		buf.WriteString("\tseeds := []string{\"\", " + getGoLiteral(rm.Pattern) + ", " + getGoLiteral(string(alphabet)) + "}\n")
+		buf.WriteString("\tcheckAgainstInterpreter(t, " + getGoLiteral(rm.Pattern) + ", " + getOptString(rm.Options) + ", seeds, []rune(" + getGoLiteral(string(alphabet)) + "))\n")
+		buf.WriteString("}\n")
+	}
+
+	buf.WriteString(`
+// checks the generated engine for the pattern finds the same matches and groups as the
+// interpreter for the seeds, random strings and random strings of the alphabet's runes
+func checkAgainstInterpreter(t *testing.T, pattern string, opts regexp2.RegexOptions, seeds []string, alphabet []rune) {
+	t.Helper()
+	generated := regexp2.MustCompile(pattern, opts)
+	interpreted, err := regexp2.Compile(pattern, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(s string) bool {
+		got, want := describeMatches(generated, s), describeMatches(interpreted, s)
+		if got != want {
+			t.Errorf("%q on %q:\ngenerated:\n%sinterpreter:\n%s", pattern, s, got, want)
+			return false
+		}
+		return true
+	}
+	for _, s := range seeds {
+		check(s)
+	}
+
+	cfg := &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(check, cfg); err != nil {
+		t.Error(err)
+	}
+	fromAlphabet := func(indexes []uint8) bool {
+		rs := make([]rune, len(indexes))
+		for i, idx := range indexes {
+			rs[i] = alphabet[int(idx)%len(alphabet)]
+		}
+		return check(string(rs))
+	}
+	if err := quick.Check(fromAlphabet, cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+// every match in s with the captures of each group
+func describeMatches(re *regexp2.Regexp, s string) string {
+	sb := &strings.Builder{}
+	m, err := re.FindStringMatch(s)
+	for ; m != nil && err == nil; m, err = re.FindNextMatch(m) {
+		for _, g := range m.Groups() {
+			fmt.Fprintf(sb, "%s:", g.Name)
+			for _, c := range g.Captures {
+				fmt.Fprintf(sb, " [%d, %d)", c.Index, c.Index+c.Length)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(sb, "error: %v\n", err)
+	}
+	return sb.String()
+}
+`)
+
+	fmtOut, err := formatSource(buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "differential test")
+	}
+	if _, err := out.Write(fmtOut); err != nil {
+		return errors.Wrap(err, "differential test")
+	}
+	return nil
+}
+
+// the distinct runes of the pattern and diffTestExtraRunes, in order
+func diffTestAlphabet(pattern string) []rune {
+	alphabet := []rune(pattern + diffTestExtraRunes)
+	slices.Sort(alphabet)
+	return slices.Compact(alphabet)
+}
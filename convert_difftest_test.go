@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func TestDifferentialTest(t *testing.T) {
+	dir := t.TempDir()
+	genFile, _ := os.Create(filepath.Join(dir, "gen.go"))
+	testFile, _ := os.Create(filepath.Join(dir, "gen_test.go"))
+	defer genFile.Close()
+	defer testFile.Close()
+
+	c, err := newConverter(genFile, "gen", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addRegexp("MyFile.go:120:10", "Words", `(?<first>\w+)\s(\w+?)\b`, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addRegexp("MyFile.go:121:10", "Digits", `(?i)x[0-9a-f]{2,4}$`, syntax.Multiline); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addFooter(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.writeDifferentialTest(testFile); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := os.ReadFile(testFile.Name())
+	for _, want := range []string{"func TestWords_Interpreter(", "func TestDigits_Interpreter(", `"(?i)x[0-9a-f]{2,4}$", regexp2.Multiline`} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	goPath, _ := exec.LookPath("go")
+	cmd := exec.Command(goPath, "test", "-count=1", "-v", genFile.Name(), testFile.Name())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated test failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "--- PASS: TestDigits_Interpreter") {
+		t.Errorf("expected the generated tests to run, got:\n%s", out)
+	}
+}
+
+func TestDiffTestAlphabet(t *testing.T) {
+	got := string(diffTestAlphabet(`b|ab`))
+	if want := "\t\n -.0Z_ab|é😀"; got != want {
+		t.Errorf("diffTestAlphabet = %q, want %q", got, want)
+	}
+}
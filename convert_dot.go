@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dlclark/regexp2/syntax"
+	"github.com/pkg/errors"
+)
+
+// escapes a DOT label, newlines become line breaks in the label
+var dotLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// DumpTree writes the parse tree the converter generates code from for the pattern to w as a
+// Graphviz DOT digraph, for debugging. Each node is labeled with its type, options, bounds and
+// capture numbers, the comment Execute gets for it, and whether it may backtrack or is atomic
+// because of an ancestor, which decide much of what the generated code looks like.
+func DumpTree(w io.Writer, pattern string, opts syntax.RegexOptions) error {
+	tree, err := syntax.Parse(pattern, opts)
+	if err != nil {
+		return errors.Wrap(err, "error parsing regexp")
+	}
+	rm := &regexpData{
+		Pattern:  pattern,
+		Options:  opts,
+		Tree:     tree,
+		Analysis: analyze(tree),
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph regexp {\n\tlabel=\"%s\";\n\tnode [shape=box, fontname=monospace];\n", dotLabelReplacer.Replace(pattern))
+	id := 0
+	var writeNode func(node *syntax.RegexNode) int
+	writeNode = func(node *syntax.RegexNode) int {
+		nodeID := id
+		id++
+
+		lines := []string{node.Description(), describeNode(rm, node)}
+		attrs := ""
+		if rm.Analysis.MayBacktrack(node) {
+			lines = append(lines, "may backtrack")
+			attrs += ", color=red"
+		}
+		if rm.Analysis.IsAtomicByAncestor(node) {
+			lines = append(lines, "atomic by ancestor")
+			attrs += ", style=filled, fillcolor=lightgray"
+		}
+		fmt.Fprintf(bw, "\tn%v [label=\"%s\"%s];\n", nodeID, dotLabelReplacer.Replace(strings.Join(lines, "\n")), attrs)
+
+		for _, child := range node.Children {
+			fmt.Fprintf(bw, "\tn%v -> n%v;\n", nodeID, writeNode(child))
+		}
+		return nodeID
+	}
+	writeNode(tree.Root)
+	bw.WriteString("}\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpTree(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := DumpTree(out, `a(?<x>b+)?"c`, 0); err != nil {
+		t.Fatal(err)
+	}
+	dot := out.String()
+	for _, want := range []string{
+		"digraph regexp {\n\tlabel=\"a(?<x>b+)?\\\"c\";\n",
+		`n4 [label="Capture(index = 1, unindex = -1)\n\"x\" capture group\nmay backtrack", color=red];`,
+		`n5 [label="Oneloop(Ch = b)(Min = 1, Max = inf)\nMatch 'b' greedily at least once.\nmay backtrack", color=red];`,
+		`n6 [label="Multi(String = \"\\\"c\")\nMatch the string \"\\\"c\".\natomic by ancestor", style=filled, fillcolor=lightgray];`,
+		"\tn3 -> n4;\n",
+		"\tn0 -> n1;\n}\n",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("expected %s in:\n%s", want, dot)
+		}
+	}
+
+	if err := DumpTree(&bytes.Buffer{}, `a(b`, 0); err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
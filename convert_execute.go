@@ -9,15 +9,49 @@ import (
 	"strings"
 
 	"github.com/dlclark/regexp2/syntax"
+	"github.com/pkg/errors"
 )
 
 // Arbitrary limit for unrolling vs creating a loop.  We want to balance size in the generated
 // code with other costs, like the (small) overhead of slicing to create the temp span to iterate.
-const MaxUnrollSize = 16
+// Options.MaxUnrollSize overrides it.
+const defaultMaxUnrollSize = 16
+
+// The most chars a length check joined with || to the clauses that index them can cover. The Go
+// compiler only carries the check through the first couple of clauses, so a longer run gets its own
+// if statement for the indexing after it to be proven in bounds (see -gcflags=-d=ssa/check_bce).
+const maxJoinedLengthCheck = 2
 
 func (c *converter) emitExecute(rm *regexpData) {
-	c.writeLineFmt("func (%s_Engine) Execute(r *regexp2.Runner) error {", rm.GeneratedName)
+	rm.dispatchLabels = nil
+	if c.opts.BacktrackDispatch {
+		rm.dispatchLabels = c.backtrackDispatchLabels(rm)
+	}
+	c.emitExecuteMethod(rm)
+	rm.dispatchLabels = nil
+}
+
+// Emits Execute, with the jumps to rm.dispatchLabels going through the switch at the bottom, see
+// emitBacktrackDispatch.
+func (c *converter) emitExecuteMethod(rm *regexpData) {
+	result := "error"
+	if c.opts.RecoverPanics {
+		result = "(err error)"
+	}
+	if rm.explain {
+		c.writeLineFmt("func (e *%s_explainEngine) Execute(r *regexp2.Runner) %s {", rm.GeneratedName, result)
+	} else {
+		c.writeLineFmt("func (%s_Engine) Execute(r *regexp2.Runner) %s {", rm.GeneratedName, result)
+	}
 	//c.writeLine(`fmt.Println("Execute")`)
+	if c.opts.RecoverPanics {
+		c.emitRecoverPanic(fmt.Sprintf(`err = fmt.Errorf("%s: recovered panic in Execute at %%d: %%v", r.Runtextpos, p)`, rm.GeneratedName))
+		_, take := c.emitFindFirstCharPanics(rm)
+		c.writeLineFmt(`if p, ok := %s(r); ok {
+			return fmt.Errorf("%s: recovered panic in FindFirstChar at %%d: %%v", r.Runtextpos, p)
+		}`, take, rm.GeneratedName)
+	}
+	c.emitExplainTrace(rm, "try at %d", "r.Runtextpos")
 	defer func() {
 		c.writeLine("}\n")
 	}()
@@ -29,9 +63,10 @@ func (c *converter) emitExecute(rm *regexpData) {
 		// Emit a capture for the current position of length 0.  This is rare to see with a real-world pattern,
 		// but it's very common as part of exploring the source generator, because it's what you get when you
 		// start out with an empty pattern.
+		// r.Runtextpos is left where the match ended, as on the main success path below.
 		c.writeLine("// The pattern matches the empty string")
-		c.writeLine("var pos = r.Runtextpos")
-		c.writeLine("r.Capture(0, pos, pos)")
+		c.writeLineFmt("var %s = r.Runtextpos", rm.pos)
+		c.writeLineFmt("r.Capture(0, %[1]s, %[1]s)", rm.pos)
 		c.writeLine("return nil")
 		return
 	}
@@ -61,7 +96,13 @@ func (c *converter) emitExecute(rm *regexpData) {
 		c.writeLine("var start = r.Runtextpos")
 		c.writeLineFmt("var end = r.Runtextpos %s %v", op, jmp)
 		c.writeLine("r.Runtextpos = end")
-		c.writeLine("r.Capture(0, start, end)")
+		if rtl {
+			// the match ends where the search found it and starts jmp chars to the left,
+			// Capture would swap the bounds but they're given in order like everywhere else
+			c.writeLine("r.Capture(0, end, start)")
+		} else {
+			c.writeLine("r.Capture(0, start, end)")
+		}
 		c.writeLine("return nil")
 		return
 	}
@@ -91,6 +132,12 @@ func (c *converter) emitExecute(rm *regexpData) {
 	// Helper to define names.  Names start unadorned, but as soon as there's repetition,
 	// they begin to have a numbered suffix.
 	rm.usedNames = make(map[string]int)
+	rm.backtrackLabels = nil
+	if len(rm.dispatchLabels) > 0 {
+		rm.dispatchLocal = rm.reserveName("backtrack")
+		rm.dispatchLabel = rm.reserveName("BacktrackDispatch")
+		rm.addLocalDec(rm.dispatchLocal + " := 0")
+	}
 
 	// Every RegexTree is rooted in the implicit Capture for the whole expression.
 	// Skip the Capture node. We handle the implicit root capture specially.
@@ -105,7 +152,9 @@ func (c *converter) emitExecute(rm *regexpData) {
 	c.buf = buf
 	defer func() {
 		// lets clean this up at the end
+		c.emitBacktrackDispatch(rm)
 		c.buf = oldOut
+		code := buf.Bytes()
 
 		// write additionalDeclarations
 		for _, l := range rm.additionalDeclarations {
@@ -116,14 +165,49 @@ func (c *converter) emitExecute(rm *regexpData) {
 		rm.additionalDeclarations = []string{}
 
 		// then write our temp out buffer into our saved buffer
-		c.buf.Write(buf.Bytes())
+		c.buf.Write(code)
 	}()
 
 	// Declare some locals.
-	rm.sliceSpan = "slice"
-	c.writeLine(`pos := r.Runtextpos
-			matchStart := pos
-			`)
+	rm.sliceSpan = rm.localPrefix + "slice"
+	// r.Runtext doesn't change during a match, keep it in a local so the Go compiler
+	// doesn't reload it through r in every loop
+	c.writeLineFmt(`runtext := r.Runtext
+			%[1]s := r.Runtextpos
+			%[2]s := %[1]s
+			`, rm.pos, rm.matchStart)
+	if leadsWithBeginning(root) && !rtl && rm.Tree.FindOptimizations.FindMode != syntax.LeadingAnchor_LeftToRight_Beginning {
+		// findFirstChar usually handles this, failing at any pos but 0 and moving r.Runtextpos
+		// to the end so the scan stops. When it doesn't, stop the scan here.
+		c.writeLineFmt(`// The pattern leads with a beginning (\A) anchor, it can't match after the start.
+			if %s != 0 {
+				r.Runtextpos = len(runtext)
+				return nil
+			}`, rm.pos)
+	}
+	if c.opts.EntryTimeoutCheck {
+		// the per-backtrack checks don't help a pattern that scans a huge input without backtracking
+		c.emitTimeoutCheck()
+	}
+	if length, ok := anchoredFixedLength(root); ok && !rtl && !rm.explain {
+		c.emitExecuteAnchoredFixedLength(rm, root, length)
+		return
+	}
+	if minLength := root.ComputeMinLength(); minLength > 0 {
+		// findFirstChar usually rules these positions out already, this stops Execute from starting
+		// a match that can't fit in what's left of the input when it doesn't
+		if rtl {
+			c.writeLineFmt(`// Any possible match is at least %v characters.
+				if %[2]s < %[1]v {
+					return nil
+				}`, minLength, rm.pos)
+		} else {
+			c.writeLineFmt(`// Any possible match is at least %v characters.
+				if len(runtext)-%[2]s < %[1]v {
+					return nil
+				}`, minLength, rm.pos)
+		}
+	}
 
 	// The implementation tries to use const indexes into the span wherever possible, which we can do
 	// for all fixed-length constructs.  In such cases (e.g. single chars, repeaters, strings, etc.)
@@ -147,6 +231,7 @@ func (c *converter) emitExecute(rm *regexpData) {
 	// Check whether there are captures anywhere in the expression. If there isn't, we can skip all
 	// the boilerplate logic around uncapturing, as there won't be anything to uncapture.
 	rm.expressionHasCaptures = rm.Analysis.MayContainCapture(node)
+	rm.mayHaveCaptured = false
 
 	// Emit the code for all nodes in the tree.
 	c.emitExecuteNode(rm, node, nil, true)
@@ -155,28 +240,167 @@ func (c *converter) emitExecute(rm *regexpData) {
 	c.writeLine("\n// The input matched.")
 	if rm.sliceStaticPos > 0 {
 		// TransferSliceStaticPosToPos would also slice, which isn't needed here
-		c.emitAddStmt("pos", rm.sliceStaticPos)
-	}
-	c.writeLine(`r.Runtextpos = pos
-			r.Capture(0, matchStart, pos)
+		c.emitAddStmt(rm.pos, rm.sliceStaticPos)
+	}
+	// r.Runtextpos is where the match ended, even if it's empty, i.e. matchStart == pos. That's where
+	// FindNextMatch starts the next search, and it bumps past an empty match itself, so an
+	// UpdateBumpalong that moved r.Runtextpos further doesn't carry over.
+	c.emitExplainTrace(rm, "matched [%d, %d)", rm.matchStart, rm.pos)
+	c.writeLineFmt(`r.Runtextpos = %[1]s
+			r.Capture(0, %[2]s, %[1]s)
 			// just to prevent an unused var error in certain regex's
-			var _ = slice
-			return nil`)
+			_ = %[3]s
+			return nil`, rm.pos, rm.matchStart, rm.sliceSpan)
 
 	// We're done with the match.
 }
 
+// how many backtracking labels Execute has to jump to before Options.BacktrackDispatch
+// sends the jumps through a switch
+const backtrackDispatchThreshold = 8
+
+// With Options.BacktrackDispatch, returns the backtracking labels the code of Execute jumps to if there
+// are more than backtrackDispatchThreshold of them, nil otherwise. That's only known once Execute is
+// emitted, so it's emitted here once as is into a buffer that's thrown away, and the state the emitting
+// changes is put back for emitting it for real.
+func (c *converter) backtrackDispatchLabels(rm *regexpData) []string {
+	oldOut, emitted, used, switchCase := c.buf, len(rm.emittedLabels), len(rm.usedLabels), len(rm.switchCaseLabels)
+	stackPushes, stackCookies, err := c.stackPushes, c.stackCookies, rm.err
+	c.buf = &bytes.Buffer{}
+	c.emitExecuteMethod(rm)
+
+	var labels []string
+	for _, label := range rm.usedLabels[used:] {
+		// Go can't jump into a switch case from the switch at the bottom
+		if rm.isBacktrackLabel(label) && !slices.Contains(rm.switchCaseLabels[switchCase:], label) && !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+
+	c.buf = oldOut
+	rm.emittedLabels, rm.usedLabels, rm.switchCaseLabels = rm.emittedLabels[:emitted], rm.usedLabels[:used], rm.switchCaseLabels[:switchCase]
+	c.stackPushes, c.stackCookies, rm.err = stackPushes, stackCookies, err
+	if len(labels) <= backtrackDispatchThreshold {
+		return nil
+	}
+	return labels
+}
+
+// Emits the switch at the bottom of Execute that the jumps to rm.dispatchLabels go through instead,
+// see emitExecuteGoto. It jumps on to the label numbered by rm.dispatchLocal. That's one jump table
+// for backtracking, like the interpreter's, rather than gotos to labels scattered through Execute.
+func (c *converter) emitBacktrackDispatch(rm *regexpData) {
+	if len(rm.dispatchLabels) == 0 {
+		return
+	}
+
+	// the last label is the default so the switch ends Execute without a return after it
+	c.writeLineFmt("\n// Backtrack to the label numbered by %s.", rm.dispatchLocal)
+	c.emitMarkLabel(rm, rm.dispatchLabel, false)
+	c.writeLineFmt("switch %s {", rm.dispatchLocal)
+	for i, label := range rm.dispatchLabels {
+		if i < len(rm.dispatchLabels)-1 {
+			c.writeLineFmt("case %v:", i)
+		} else {
+			c.writeLine("default:")
+		}
+		rm.usedLabels = append(rm.usedLabels, label)
+		c.writeLineFmt("goto %s", label)
+	}
+	c.writeLine("}")
+}
+
+// Reports the length of a pattern anchored at the beginning (\A or ^) and the end (\z, \Z or $) with only
+// single chars, strings and repeaters of a single char between, e.g. ^\d{4}$.  Such a pattern can only match
+// the whole input, or for \Z and $ all of it but a final newline.
+func anchoredFixedLength(root *syntax.RegexNode) (int, bool) {
+	if root.T != syntax.NtConcatenate || len(root.Children) < 3 || root.Children[0].T != syntax.NtBeginning {
+		return 0, false
+	}
+	if end := root.Children[len(root.Children)-1]; end.T != syntax.NtEnd && end.T != syntax.NtEndZ {
+		return 0, false
+	}
+
+	length := 0
+	for _, child := range root.Children[1 : len(root.Children)-1] {
+		switch child.T {
+		case syntax.NtOne, syntax.NtNotone, syntax.NtSet:
+			length++
+		case syntax.NtMulti:
+			length += len(child.Str)
+		case syntax.NtOneloop, syntax.NtNotoneloop, syntax.NtSetloop,
+			syntax.NtOnelazy, syntax.NtNotonelazy, syntax.NtSetlazy,
+			syntax.NtOneloopatomic, syntax.NtNotoneloopatomic, syntax.NtSetloopatomic:
+			if child.M != child.N {
+				return 0, false
+			}
+			length += child.M
+		default:
+			return 0, false
+		}
+	}
+	return length, length > 0
+}
+
+// Emits the rest of Execute for a pattern anchored at both ends with a fixed length, see anchoredFixedLength.
+// One check that the match starts at 0 and the input is that long replaces the length checks of each node,
+// and as none of them can backtrack the chars are checked in a straight line.
+func (c *converter) emitExecuteAnchoredFixedLength(rm *regexpData, root *syntax.RegexNode, length int) {
+	c.writeLineFmt("// The pattern is anchored at both ends and matches %v characters, so only all of the input can match.", length)
+	if root.Children[len(root.Children)-1].T == syntax.NtEnd {
+		c.writeLineFmt("if %s != 0 || len(runtext) != %v {", rm.pos, length)
+	} else {
+		// \Z and $ also match before a final newline
+		c.writeLineFmt("if %s != 0 || (len(runtext) != %v && (len(runtext) != %v || runtext[%[2]v] != '\\n')) {", rm.pos, length, length+1)
+	}
+	// there's no other position to try either
+	c.writeLine(`r.Runtextpos = len(runtext)
+		return nil
+		}`)
+
+	rm.sliceStaticPos = 0
+	c.sliceInputSpan(rm, true)
+	c.writeLine("")
+	rm.doneLabel = rm.reserveName("NoMatch")
+	rm.topLevelDoneLabel = rm.doneLabel
+	rm.expressionHasCaptures = false
+	rm.mayHaveCaptured = false
+
+	for _, child := range root.Children[1 : len(root.Children)-1] {
+		c.emitExecuteNode(rm, child, nil, false)
+		c.writeLine("")
+	}
+
+	c.writeLineFmt(`// The input matched.
+		%[2]s += %[1]v
+		r.Runtextpos = %[2]s
+		r.Capture(0, %[3]s, %[2]s)
+		return nil`, length, rm.pos, rm.matchStart)
+}
+
+// Reports if the root node is, or is a concatenation starting with, a beginning (\A or ^) anchor.
+func leadsWithBeginning(root *syntax.RegexNode) bool {
+	if root.T == syntax.NtConcatenate && len(root.Children) > 0 {
+		root = root.Children[0]
+	}
+	return root.T == syntax.NtBeginning
+}
+
 // Emits the code for the node.
 // subsequent = nil, emitLengthChecksIfRequired = True
 func (c *converter) emitExecuteNode(rm *regexpData, node *syntax.RegexNode, subsequent *syntax.RegexNode, emitLengthChecksIfRequired bool) {
+	// whatever the node emits may move pos without reloading slice, e.g. right-to-left code,
+	// or leave a block that slice was reloaded in on only some paths
+	defer func() { rm.sliceDirty = true }()
+
 	// Before we handle general-purpose matching logic for nodes, handle any special-casing.
 	if rm.Tree.FindOptimizations.FindMode == syntax.LiteralAfterLoop_LeftToRight &&
 		rm.Tree.FindOptimizations.LiteralAfterLoop.LoopNode == node {
 		// This is the set loop that's part of the literal-after-loop optimization: the end of the loop
 		// is stored in runtrackpos, so we just need to transfer that to pos. The optimization is only
 		// selected if the shape of the tree is amenable.
-		c.writeLine(`// Skip loop already matched in TryFindNextPossibleStartingPosition.
-		pos = r.Runtrackpos`)
+		c.writeLineFmt(`// Skip loop already matched in TryFindNextPossibleStartingPosition.
+		%s = r.Runtrackpos`, rm.pos)
 		c.sliceInputSpan(rm, false)
 		return
 	}
@@ -190,8 +414,20 @@ func (c *converter) emitExecuteNode(rm *regexpData, node *syntax.RegexNode, subs
 		c.transferSliceStaticPosToPos(rm, false)
 	}
 
-	//TODO: debug
-	c.writeLineFmt("// Node: %s", node.Description())
+	if c.opts.AnnotateNodes {
+		if depth, path, ok := nodePath(rm, node); ok {
+			c.writeLineFmt("// Node: %s [depth %v, path %s]", node.Description(), depth, path)
+		} else {
+			c.writeLineFmt("// Node: %s [not in the tree, made while emitting]", node.Description())
+		}
+	} else {
+		c.writeLineFmt("// Node: %s", node.Description())
+	}
+
+	// a concatenation only captures in its children, which are checked as they're emitted
+	if node.T != syntax.NtConcatenate && rm.Analysis.MayContainCapture(node) {
+		rm.mayHaveCaptured = true
+	}
 
 	// Separate out several node types that, for conciseness, don't need a header nor scope written into the source.
 	// Effectively these either evaporate, are completely self-explanatory, or only exist for their children to be rendered.
@@ -208,6 +444,7 @@ func (c *converter) emitExecuteNode(rm *regexpData, node *syntax.RegexNode, subs
 	// Skip atomic nodes that wrap non-backtracking children; in such a case there's nothing to be made atomic.
 	case syntax.NtAtomic:
 		if !rm.Analysis.MayBacktrack(node.Children[0]) {
+			c.emitDecision("no atomic group, the child never backtracks")
 			c.emitExecuteNode(rm, node.Children[0], nil, true)
 			return
 		}
@@ -311,7 +548,15 @@ func (c *converter) emitExecuteNode(rm *regexpData, node *syntax.RegexNode, subs
 	}
 	//}
 
-	panic(fmt.Sprintf("unhandled node: %v", node))
+	// leave the rest of the code unfinished rather than panic, addRegexp returns the error instead
+	if rm.err == nil {
+		desc := fmt.Sprint("node type ", node.T)
+		if node.T >= 0 && node.T <= syntax.NtUpdateBumpalong {
+			// Description looks the name up in a table of the parser's node types
+			desc = node.Description()
+		}
+		rm.err = errors.Errorf("unhandled node %s (type %d) in %q", desc, node.T, rm.Pattern)
+	}
 }
 
 // Emits the node for an atomic.
@@ -323,11 +568,26 @@ func (c *converter) emitExecuteAtomic(rm *regexpData, node *syntax.RegexNode, su
 	// so that the state on the stack remains consistent.
 	originalDoneLabel := rm.doneLabel
 	startingStackpos := rm.reserveName("atomic_stackpos")
-	rm.addLocalDec(fmt.Sprint(startingStackpos, " := 0"))
-	c.writeLineFmt("%s = r.Runstackpos\n", startingStackpos)
 
-	// Emit the child.
+	// Emit the child into its own buffer first. The stack position only needs saving and resetting
+	// if the child pushes onto the backtracking stack, a child that backtracks only through its
+	// locals, e.g. a single char loop or an alternation that isn't in a loop, leaves it as it was.
+	stackPushes := c.stackPushes
+	oldOut := c.buf
+	childOut := &bytes.Buffer{}
+	c.buf = childOut
 	c.emitExecuteNode(rm, node.Children[0], subsequent, true)
+	c.buf = oldOut
+
+	if c.stackPushes == stackPushes {
+		c.buf.Write(childOut.Bytes())
+		rm.doneLabel = originalDoneLabel
+		return
+	}
+
+	rm.addLocalDec(fmt.Sprint(startingStackpos, " := 0"))
+	c.writeLineFmt("%s = r.Runstackpos\n", startingStackpos)
+	c.buf.Write(childOut.Bytes())
 
 	// Reset the stack position and done label.
 	c.writeLineFmt("\nr.Runstackpos = %s", startingStackpos)
@@ -339,9 +599,9 @@ func (c *converter) emitExecuteAtomic(rm *regexpData, node *syntax.RegexNode, su
 // it should bump from this location rather than from the original location.
 func (c *converter) emitExecuteUpdateBumpalong(rm *regexpData, node *syntax.RegexNode) {
 	c.transferSliceStaticPosToPos(rm, false)
-	c.writeLine(`if r.Runtextpos < pos {
-		r.Runtextpos = pos
-	}`)
+	c.writeLineFmt(`if r.Runtextpos < %[1]s {
+		r.Runtextpos = %[1]s
+	}`, rm.pos)
 }
 
 // Emits code for a concatenation
@@ -355,6 +615,26 @@ func (c *converter) emitExecuteConcatenation(rm *regexpData, node *syntax.RegexN
 		// can be checked efficiently with methods like StartsWith. We also want to minimize the repetition of if blocks,
 		// and so we try to emit a series of clauses all part of the same if block rather than one if block per child.
 		var requiredLength, exclusiveEnd int
+		if node.Options&syntax.RightToLeft != 0 &&
+			emitLengthChecksIfRequired &&
+			node.TryGetJoinableLengthCheckChildRange(i, &requiredLength, &exclusiveEnd) {
+			// Matching right-to-left consumes the chars before pos, so one check that there
+			// are enough of them covers the whole sequence.
+			c.writeLineFmt("if %s < %v {", rm.pos, requiredLength)
+			c.emitExecuteGoto(rm, rm.doneLabel)
+			c.writeLine("}\n")
+
+			for ; i < exclusiveEnd; i++ {
+				c.emitExecuteNode(rm, node.Children[i], getSubsequentOrDefault(i, node, subsequent), false)
+				if i < len(node.Children)-1 {
+					c.writeLine("")
+				}
+			}
+
+			i--
+			continue
+		}
+
 		if node.Options&syntax.RightToLeft == 0 &&
 			emitLengthChecksIfRequired &&
 			node.TryGetJoinableLengthCheckChildRange(i, &requiredLength, &exclusiveEnd) {
@@ -372,7 +652,13 @@ func (c *converter) emitExecuteConcatenation(rm *regexpData, node *syntax.RegexN
 				}
 			}
 
-			c.write(fmt.Sprintf("if %s", spanLengthCheck(rm, requiredLength, nil)))
+			if rm.sliceStaticPos+requiredLength > maxJoinedLengthCheck {
+				c.emitSpanLengthCheck(rm, requiredLength, nil)
+				c.writeLine("")
+				wroteClauses = false
+			} else {
+				c.write(fmt.Sprintf("if %s", spanLengthCheck(rm, requiredLength, nil)))
+			}
 
 			for i < exclusiveEnd {
 				for ; i < exclusiveEnd; i++ {
@@ -383,7 +669,7 @@ func (c *converter) emitExecuteConcatenation(rm *regexpData, node *syntax.RegexN
 						if rm.sliceStaticPos > 0 {
 							sourceSpan = fmt.Sprintf("%s[%v:]", rm.sliceSpan, rm.sliceStaticPos)
 						}
-						c.write(fmt.Sprintf("!helpers.StartsWithIgnoreCase(%s, %s)", sourceSpan, getRuneSliceLiteral(strings.ToLower(caseInsensitiveString))))
+						c.write(fmt.Sprintf("!%s(%s, %s)", c.emitStartsWithOrdinalIgnoreCaseHelper(), sourceSpan, getRuneSliceLiteral(strings.ToLower(caseInsensitiveString))))
 						desc := fmt.Sprintf("Match the string %#v (case-insensitive)", caseInsensitiveString)
 						prevDescription = &desc
 						wroteClauses = true
@@ -398,7 +684,7 @@ func (c *converter) emitExecuteConcatenation(rm *regexpData, node *syntax.RegexN
 						wroteClauses = true
 					} else if (child.IsOneFamily() || child.IsNotoneFamily() || child.IsSetFamily()) &&
 						child.M == child.N &&
-						child.M <= MaxUnrollSize {
+						child.M <= c.maxUnrollSize {
 
 						repeatCount := child.M
 						if child.T == syntax.NtOne || child.T == syntax.NtNotone || child.T == syntax.NtSet {
@@ -473,15 +759,10 @@ func getSubsequentOrDefault(index int, node *syntax.RegexNode, defaultNode *synt
 func (c *converter) emitExecuteMultiCharString(rm *regexpData, str []rune, emitLengthCheck bool, clauseOnly bool, rightToLeft bool) {
 
 	if rightToLeft {
-		c.writeLineFmt("if lastIdx := pos - %v; lastIdx < 0 || lastIdx >= len(r.Runtext) {", len(str))
-		c.emitExecuteGoto(rm, rm.doneLabel)
-		c.writeLine("}\n")
-
-		c.writeLineFmt(`for i:=0; i < %v; i++ {
-						pos--
-						if r.Runtext[pos] != %s[%v - i] {`, len(str), getRuneSliceLiteral(str), len(str)-1)
+		// the helper checks there are enough chars before pos, with or without emitLengthCheck
+		c.writeLineFmt("if !%s(runtext[:%s], %s) {", c.emitEndsWithHelper(), rm.pos, getRuneSliceLiteral(str))
 		c.emitExecuteGoto(rm, rm.doneLabel)
-		c.writeLine("}\n}")
+		c.writeLineFmt("}\n%s -= %v", rm.pos, len(str))
 
 		return
 	}
@@ -490,7 +771,16 @@ func (c *converter) emitExecuteMultiCharString(rm *regexpData, str []rune, emitL
 	if rm.sliceStaticPos > 0 {
 		sourceSpan = fmt.Sprintf("%s[%v:]", rm.sliceSpan, rm.sliceStaticPos)
 	}
-	clause := fmt.Sprintf("!helpers.StartsWith(%s, %s)", sourceSpan, getRuneSliceLiteral(str))
+	var clause string
+	if len(str) == 4 || len(str) == 8 {
+		clause = fmt.Sprintf("!%s(%s, %s)", c.emitStartsWithWordsHelper(len(str)), sourceSpan, getRuneArrayLiteral(str))
+	} else if c.opts.AsciiOnly {
+		// with AsciiOnly the literal is ASCII, so it can be compared a byte at a time
+		// from a string constant, with no []rune to convert it to first
+		clause = fmt.Sprintf("!%s(%s, %#v)", c.emitStartsWithAsciiHelper(), sourceSpan, string(str))
+	} else {
+		clause = fmt.Sprintf("!%s(%s, %s)", c.helper("StartsWith"), sourceSpan, getRuneSliceLiteral(str))
+	}
 	if clauseOnly {
 		c.write(clause)
 	} else {
@@ -502,12 +792,93 @@ func (c *converter) emitExecuteMultiCharString(rm *regexpData, str []rune, emitL
 	rm.sliceStaticPos += len(str)
 }
 
+// Emits a helper like helpers.StartsWith for the end of s, to match a literal right to left before pos.
+func (c *converter) emitEndsWithHelper() string {
+	const name = "endsWith"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = fmt.Sprintf(`// Returns true if s ends with lit.
+		func endsWith(s []rune, lit []rune) bool {
+			return len(s) >= len(lit) && %s(s[len(s)-len(lit):], lit)
+		}`, c.helper("StartsWith"))
+	}
+	return name
+}
+
+// Emits a helper like helpers.StartsWith for literals of exactly n (4 or 8) runes that compares
+// 4 runes at a time as arrays, which the compiler turns into a couple of word-sized loads and compares.
+func (c *converter) emitStartsWithWordsHelper(n int) string {
+	name := fmt.Sprintf("startsWith%v", n)
+	if _, ok := c.requiredHelpers[name]; !ok {
+		cmp := "[4]rune(s[:4]) == [4]rune(lit[:4])"
+		if n == 8 {
+			// comparing [8]rune at once calls memequal, two halves stay inline
+			cmp += " && [4]rune(s[4:8]) == [4]rune(lit[4:])"
+		}
+		c.requiredHelpers[name] = fmt.Sprintf(`// Returns true if s starts with the %[1]v runes of lit.
+		func %[2]s(s []rune, lit [%[1]v]rune) bool {
+			return len(s) >= %[1]v && %[3]s
+		}`, n, name, cmp)
+	}
+	return name
+}
+
+// Emits a helper like helpers.StartsWith that takes an ASCII literal as a string, for Options.AsciiOnly.
+func (c *converter) emitStartsWithAsciiHelper() string {
+	const name = "startsWithAscii"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = `// Returns true if s starts with lit, which only has ASCII chars.
+		func startsWithAscii(s []rune, lit string) bool {
+			if len(s) < len(lit) {
+				return false
+			}
+			for i := 0; i < len(lit); i++ {
+				if s[i] != rune(lit[i]) {
+					return false
+				}
+			}
+			return true
+		}`
+	}
+	return name
+}
+
+// Emits a helper for the strings from TryGetOrdinalCaseInsensitiveString, which are ASCII with
+// the letters from [Aa] style sets lowercased. helpers.StartsWithIgnoreCase compares with unicode.ToLower,
+// which also folds chars regexp2's case table doesn't, e.g. 'İ' to 'i', so only ASCII letters fold here.
+func (c *converter) emitStartsWithOrdinalIgnoreCaseHelper() string {
+	const name = "startsWithOrdinalIgnoreCase"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = `// Returns true if s starts with lower, matching its ASCII letters in either case.
+		func startsWithOrdinalIgnoreCase(s []rune, lower []rune) bool {
+			if len(s) < len(lower) {
+				return false
+			}
+			for i, ch := range lower {
+				if s[i] != ch && (s[i]|0x20 != ch || ch < 'a' || ch > 'z') {
+					return false
+				}
+			}
+			return true
+		}`
+	}
+	return name
+}
+
+// Returns a Go array literal of the runes, e.g. [4]rune{'a', 'b', 'c', 'd'}.
+func getRuneArrayLiteral(in []rune) string {
+	quoted := make([]string, len(in))
+	for i, ch := range in {
+		quoted[i] = fmt.Sprintf("%q", ch)
+	}
+	return fmt.Sprintf("[%v]rune{%s}", len(in), strings.Join(quoted, ", "))
+}
+
 // Emits the code to handle a single-character match.
 // emitLengthCheck = true, offset = nil, clauseOnly = false
 func (c *converter) emitExecuteSingleChar(rm *regexpData, node *syntax.RegexNode, emitLengthCheck bool, offset *string, clauseOnly bool) {
 	rtl := node.Options&syntax.RightToLeft != 0
 
-	expr := "r.Runtext[pos-1]"
+	expr := fmt.Sprintf("runtext[%s-1]", rm.pos)
 	if !rtl {
 		expr = fmt.Sprintf("%s[%s]", rm.sliceSpan, sum(rm.sliceStaticPos, offset))
 	}
@@ -529,7 +900,7 @@ func (c *converter) emitExecuteSingleChar(rm *regexpData, node *syntax.RegexNode
 		} else if !rtl {
 			clause = fmt.Sprintf("if %s || %s {", spanLengthCheck(rm, 1, offset), expr)
 		} else {
-			clause = fmt.Sprintf("if newIdx := pos - 1; newIdx < 0 || newIdx >= len(r.Runtext) || %s {", expr)
+			clause = fmt.Sprintf("if newIdx := %s - 1; newIdx < 0 || newIdx >= len(runtext) || %s {", rm.pos, expr)
 		}
 
 		c.writeLine(clause)
@@ -540,7 +911,7 @@ func (c *converter) emitExecuteSingleChar(rm *regexpData, node *syntax.RegexNode
 	if !rtl {
 		rm.sliceStaticPos++
 	} else {
-		c.writeLine("pos--")
+		c.writeLineFmt("%s--", rm.pos)
 	}
 }
 
@@ -548,12 +919,14 @@ func (c *converter) emitExecuteSingleChar(rm *regexpData, node *syntax.RegexNode
 func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.RegexNode, subsequent *syntax.RegexNode, emitLengthChecksIfRequired bool) {
 	// If this is actually atomic based on its parent, emit it as atomic instead; no backtracking necessary.
 	if rm.Analysis.IsAtomicByAncestor(node) {
+		c.emitDecision("atomic, nothing after it can backtrack into it")
 		c.emitExecuteSingleCharAtomicLoop(rm, node, true)
 		return
 	}
 
 	// If this is actually a repeater, emit that instead; no backtracking necessary.
 	if node.M == node.N {
+		c.emitDecision("a fixed number of chars, there's nothing to backtrack")
 		c.emitExecuteSingleCharRepeater(rm, node, emitLengthChecksIfRequired)
 		return
 	}
@@ -562,7 +935,7 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 	// as an afterthought, since we know exactly how many characters are accepted by each iteration
 	// of the wrapped loop (1) and that there's nothing captured by the loop.
 
-	backtrackingLabel := rm.reserveName("CharLoopBacktrack")
+	backtrackingLabel := rm.reserveBacktrackLabel("CharLoopBacktrack")
 	endLoop := rm.reserveName("CharLoopEnd")
 	startingPos := rm.reserveName("charloop_starting_pos")
 	endingPos := rm.reserveName("charloop_ending_pos")
@@ -570,6 +943,11 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 	rtl := node.Options&syntax.RightToLeft != 0
 	isInLoop := rm.Analysis.IsInLoop(node)
 
+	c.emitDecision("matched atomically, then backtracking gives back one char at a time")
+	if isInLoop {
+		c.emitDecision("inside another loop, the positions go on the backtracking stack")
+	}
+
 	// We're about to enter a loop, so ensure our text position is 0.
 	c.transferSliceStaticPosToPos(rm, false)
 
@@ -578,12 +956,12 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 	// knowledge of backtracking, we can layer it on top by just walking back
 	// through the individual characters (a benefit of the loop matching exactly
 	// one character per iteration, no possible captures within the loop, etc.)
-	c.writeLineFmt("%s = pos\n", startingPos)
+	c.writeLineFmt("%s = %s\n", startingPos, rm.pos)
 	c.emitExecuteSingleCharAtomicLoop(rm, node, true)
 	c.writeLine("")
 
 	c.transferSliceStaticPosToPos(rm, false)
-	c.writeLineFmt("%s = pos", endingPos)
+	c.writeLineFmt("%s = %s", endingPos, rm.pos)
 	if !rtl {
 		c.emitAddStmt(startingPos, node.M)
 	} else {
@@ -607,7 +985,7 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 		if rm.expressionHasCaptures {
 			c.emitUncaptureUntil("r.StackPop()")
 		}
-		c.emitStackPop(stackCookie, endingPos, startingPos)
+		c.emitStackPop(rm, stackCookie, endingPos, startingPos)
 	} else if rm.expressionHasCaptures {
 		// Since we're not in a loop, we're using a local to track the crawl position.
 		// Unwind back to the position we were at prior to running the code after this loop.
@@ -622,24 +1000,48 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 
 	var literalNode *syntax.RegexNode
 	if subsequent != nil {
-		literalNode = subsequent.FindStartingLiteralNode(true)
+		if !rtl {
+			literalNode = subsequent.FindStartingLiteralNode(true)
+		} else {
+			literalNode = findStartingLiteralNodeRightToLeft(subsequent)
+		}
 	}
 	var literalLength int
 	var indexOfExpr string
 
-	if !rtl &&
+	if rtl &&
+		node.N > 1 &&
+		literalNode != nil &&
+		c.tryEmitExecuteIndexOf(rm, literalNode, fmt.Sprintf("runtext[%%s:%s]", startingPos), false, false, &literalLength, &indexOfExpr) {
+		// Giving back chars moves pos up towards startingPos, so the next place the literal
+		// can end is found searching forward from just after endingPos.
+		c.writeLineFmt(`if %s <= %s {`, startingPos, endingPos)
+		c.emitExecuteGoto(rm, rm.doneLabel)
+		c.writeLine("}")
+
+		searchStart := endingPos
+		if literalLength > 1 {
+			searchStart = fmt.Sprintf("%s(0, %s-%v)", c.helper("Max"), endingPos, literalLength-1)
+		}
+		c.writeLineFmt("if i := %s; i < 0 { // miss", fmt.Sprintf(indexOfExpr, searchStart))
+		c.emitExecuteGoto(rm, rm.doneLabel)
+		c.writeLine("} else {")
+		c.writeLineFmt(`%s = %s + i + %v
+			}
+			%[4]s = %[1]s`, endingPos, searchStart, literalLength, rm.pos)
+	} else if !rtl &&
 		node.N > 1 && // no point in using IndexOf for small loops, in particular optionals
 		literalNode != nil &&
-		c.tryEmitExecuteIndexOf(rm, literalNode, fmt.Sprintf("r.Runtext[%s:%%s]", startingPos), true, false, &literalLength, &indexOfExpr) {
+		c.tryEmitExecuteIndexOf(rm, literalNode, fmt.Sprintf("runtext[%s:%%s]", startingPos), true, false, &literalLength, &indexOfExpr) {
 		//hack -- if the indexOfExpr comes back it'll be a format string (notice the double %)
 		//for the final index into the slice so we can populate it here
-		//e.g. r.Runtext[startingPos:%s]
+		//e.g. runtext[startingPos:%s]
 		c.writeLineFmt(`if %s >= %s {`, startingPos, endingPos)
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}")
 
 		if literalLength > 1 {
-			indexOfExpr = fmt.Sprintf(indexOfExpr, fmt.Sprintf("helpers.Min(len(r.Runtext), %s+%v)", endingPos, literalLength-1))
+			indexOfExpr = fmt.Sprintf(indexOfExpr, fmt.Sprintf("%s(len(runtext), %s+%v)", c.helper("Min"), endingPos, literalLength-1))
 		} else {
 			indexOfExpr = fmt.Sprintf(indexOfExpr, endingPos)
 		}
@@ -648,7 +1050,7 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}")
 		c.writeLineFmt(`%s += %s
-			pos = %[1]s`, endingPos, startingPos)
+			%[3]s = %[1]s`, endingPos, startingPos, rm.pos)
 	} else {
 		op := ">="
 		if rtl {
@@ -659,10 +1061,10 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 		c.writeLine("}")
 		if !rtl {
 			c.writeLineFmt(`%s--
-			pos = %[1]s`, endingPos)
+			%[2]s = %[1]s`, endingPos, rm.pos)
 		} else {
 			c.writeLineFmt(`%s++
-			pos = %[1]s`, endingPos)
+			%[2]s = %[1]s`, endingPos, rm.pos)
 		}
 	}
 
@@ -693,11 +1095,44 @@ func (c *converter) emitExecuteSingleCharLoop(rm *regexpData, node *syntax.Regex
 
 func (c *converter) emitMarkLabel(rm *regexpData, label string, emitSemiColon bool) {
 	rm.emittedLabels = append(rm.emittedLabels, label)
+	// the code after a label can be jumped to with pos anywhere
+	rm.sliceDirty = true
+	// the code after a label can be jumped back to after something later captured
+	rm.mayHaveCaptured = true
+	if rm.switchCaseDepth > 0 {
+		rm.switchCaseLabels = append(rm.switchCaseLabels, label)
+	}
 	if emitSemiColon {
 		c.writeLineFmt("%s: ;", label)
 	} else {
 		c.writeLineFmt("%s:", label)
 	}
+	if rm.isBacktrackLabel(label) {
+		// the trace names the label the same with or without Options.LocalPrefix
+		c.emitExplainTrace(rm, "backtrack to "+strings.TrimPrefix(label, rm.localPrefix)+" at %d", rm.pos)
+	}
+}
+
+// Emits a helper that finds the first lit in s that starts before the first stop char, for a lazy
+// loop of anything but stop followed by lit. lit can start with stop.
+func (c *converter) emitIndexOfBeforeHelper() string {
+	const name = "indexOfBefore"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = fmt.Sprintf(`// Returns the index of the first lit in s that starts before the first stop, or -1.
+		func indexOfBefore(s []rune, lit []rune, stop rune) int {
+			first := lit[0]
+			for i, ch := range s {
+				if ch == first && %s(s[i:], lit) {
+					return i
+				}
+				if ch == stop {
+					return -1
+				}
+			}
+			return -1
+		}`, c.helper("StartsWith"))
+	}
+	return name
 }
 
 // emitLengthChecksIfRequired=true
@@ -705,13 +1140,28 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	// Emit the min iterations as a repeater.  Any failures here don't necessitate backtracking,
 	// as the lazy itself failed to match, and there's no backtracking possible by the individual
 	// characters/iterations themselves.
+	// Like a fixed repeater, e.g. the two digits of \d{2,}?x are checked with one length check, and a
+	// minimum longer than Options.MaxUnrollSize is searched with IndexOf for a char the loop doesn't match.
 	if node.M > 0 {
+		if node.M != node.N {
+			c.emitDecision("the minimum is matched up front as a repeater, only the iterations after it are lazy")
+		}
 		c.emitExecuteSingleCharRepeater(rm, node, emitLengthChecksIfRequired)
 	}
 
 	// If the whole thing was actually that repeater, we're done. Similarly, if this is actually an atomic
 	// lazy loop, nothing will ever backtrack into this node, so we never need to iterate more than the minimum.
 	if node.M == node.N || rm.Analysis.IsAtomicByAncestor(node) {
+		if node.M != node.N {
+			c.emitDecision("atomic, so only the minimum is matched, nothing after it can backtrack in for more")
+		}
+		return
+	}
+
+	// If this is actually a lazy optional single char, emit that instead.
+	if node.M == 0 && node.N == 1 {
+		c.emitDecision("a lazy optional char, only the starting pos is kept for backtracking")
+		c.emitExecuteSingleCharLazyZeroOrOne(rm, node)
 		return
 	}
 
@@ -746,7 +1196,7 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	// is also incremented each time we match another character in the loop.
 	startingPos := rm.reserveName("lazyloop_pos")
 	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
-	c.writeLineFmt("%s = pos", startingPos)
+	c.writeLineFmt("%s = %s", startingPos, rm.pos)
 
 	// Skip the backtracking section for the initial subsequent matching.  We've already matched the
 	// minimum number of iterations, which means we can successfully match with zero additional iterations.
@@ -755,7 +1205,7 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	c.writeLine("")
 
 	// Backtracking section. Subsequent failures will jump to here.
-	backtrackingLabel := rm.reserveName("LazyLoopBacktrack")
+	backtrackingLabel := rm.reserveBacktrackLabel("LazyLoopBacktrack")
 	c.emitMarkLabel(rm, backtrackingLabel, false)
 
 	// Uncapture any captures if the expression has any.  It's possible the captures it has
@@ -778,7 +1228,7 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	// Now match the next item in the lazy loop.  We need to reset the pos to the position
 	// just after the last character in this loop was matched, and we need to store the resulting position
 	// for the next time we backtrack.
-	c.writeLineFmt("pos = %s", startingPos)
+	c.writeLineFmt("%s = %s", rm.pos, startingPos)
 	c.sliceInputSpan(rm, false)
 	c.emitExecuteSingleChar(rm, node, true, nil, false)
 	c.transferSliceStaticPosToPos(rm, false)
@@ -788,13 +1238,44 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	if (node.Options & syntax.RightToLeft) == 0 {
 		var literal *syntax.StartingLiteral
 		var literalNode *syntax.RegexNode
-		var indexOfExpr string
+		var indexOfExpr, stopExpr string
 
 		if subsequent != nil {
 			literal = subsequent.FindStartingLiteral()
 			literalNode = subsequent.FindStartingLiteralNode(true)
 		}
 		if len(iterationCount) == 0 && node.T == syntax.NtNotonelazy &&
+			literalNode != nil && literalNode.T == syntax.NtMulti {
+			// e.g. "<div>.*?</div>"
+			// Rather than stopping at each occurrence of the literal's first char, search for the whole
+			// literal, giving up at the char the loop can't match.
+			c.emitDecision("searching with IndexOf for the literal after the loop")
+			c.writeLineFmt("%s = %s(%s, %s, %q)", startingPos, c.emitIndexOfBeforeHelper(), rm.sliceSpan, getRuneSliceLiteral(literalNode.Str), node.Ch)
+			c.writeLineFmt("if %s < 0 {", startingPos)
+			c.emitExecuteGoto(rm, rm.doneLabel)
+			c.writeLineFmt(`}
+						%s += %s`, rm.pos, startingPos)
+			c.sliceInputSpan(rm, false)
+		} else if len(iterationCount) == 0 &&
+			node.T == syntax.NtSetlazy && !node.Set.IsAnything() &&
+			literalNode != nil && literalNode.T == syntax.NtMulti &&
+			c.tryEmitExecuteIndexOf(rm, node, rm.sliceSpan, false, true, new(int), &stopExpr) {
+			// e.g. "[a-z]*?abc"
+			// Search for the whole literal. The loop can't go past the first char it doesn't match, so the
+			// literal has to start at or before that; only search up to there so a miss doesn't scan the
+			// rest of the input.
+			c.emitDecision("searching with IndexOf for the literal after the loop, up to the first char the loop doesn't match")
+			c.writeLineFmt("if %s = %s; %[1]s < 0 {", startingPos, stopExpr)
+			c.writeLineFmt("%s = len(%s)", startingPos, rm.sliceSpan)
+			c.writeLine("}")
+			c.tryEmitExecuteIndexOf(rm, literalNode, fmt.Sprintf("%[1]s[:%[2]s(len(%[1]s), %[3]s+%[4]v)]", rm.sliceSpan, c.helper("Min"), startingPos, len(literalNode.Str)), false, false, new(int), &indexOfExpr)
+			c.writeLineFmt("%s = %s", startingPos, indexOfExpr)
+			c.writeLineFmt("if %s < 0 {", startingPos)
+			c.emitExecuteGoto(rm, rm.doneLabel)
+			c.writeLineFmt(`}
+						%s += %s`, rm.pos, startingPos)
+			c.sliceInputSpan(rm, false)
+		} else if len(iterationCount) == 0 && node.T == syntax.NtNotonelazy &&
 			literal != nil &&
 			!literal.Negated && // not negated; can't search for both the node.Ch and a negated subsequent char with an IndexOf* method
 			(len(literal.String) > 0 ||
@@ -811,13 +1292,14 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 
 			// This lazy loop will consume all characters other than node.Ch until the subsequent literal.
 			// We can implement it to search for either that char or the literal, whichever comes first.
+			c.emitDecision("searching with IndexOf for the start of what follows the loop, or the char it stops at")
 			if len(literal.String) > 0 {
 				// string literal
 				overlap = (literal.String[0] == node.Ch)
 				if overlap {
-					c.writeLineFmt("%s = helpers.IndexOfAny1(%s, %q)", startingPos, rm.sliceSpan, node.Ch)
+					c.writeLineFmt("%s = %s(%s, %q)", startingPos, c.helper("IndexOfAny1"), rm.sliceSpan, node.Ch)
 				} else {
-					c.writeLineFmt("%s = helpers.IndexOfAny2(%s, %q, %q)", startingPos, rm.sliceSpan, node.Ch, literal.String[0])
+					c.writeLineFmt("%s = %s(%s, %q, %q)", startingPos, c.helper("IndexOfAny2"), rm.sliceSpan, node.Ch, literal.String[0])
 				}
 			} else if len(literal.SetChars) > 0 {
 				// set literal
@@ -827,19 +1309,19 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 					chars = []rune{node.Ch}
 				}
 				chars = append(chars, literal.SetChars...)
-				c.writeLineFmt("%s = %s", startingPos, c.emitIndexOfChars(chars, false, rm.sliceSpan))
+				c.writeLineFmt("%s = %s", startingPos, c.emitIndexOfChars(chars, false, false, rm.sliceSpan))
 			} else if literal.Range.First == literal.Range.Last {
 				// single char from a RegexNode.One
 				overlap = (literal.Range.First == node.Ch)
 				if overlap {
-					c.writeLineFmt("%s = helpers.IndexOfAny1(%s, %q)", startingPos, rm.sliceSpan, node.Ch)
+					c.writeLineFmt("%s = %s(%s, %q)", startingPos, c.helper("IndexOfAny1"), rm.sliceSpan, node.Ch)
 				} else {
-					c.writeLineFmt("%s = helpers.IndexOfAny2(%s, %q, %q)", startingPos, rm.sliceSpan, node.Ch, literal.Range.First)
+					c.writeLineFmt("%s = %s(%s, %q, %q)", startingPos, c.helper("IndexOfAny2"), rm.sliceSpan, node.Ch, literal.Range.First)
 				}
 			} else {
 				// char range
 				overlap = true
-				c.writeLineFmt("%s = helpers.IndexOfAnyInRange(%s, %q, %q)", startingPos, rm.sliceSpan, literal.Range.First, literal.Range.Last)
+				c.writeLineFmt("%s = %s(%s, %q, %q)", startingPos, c.helper("IndexOfAnyInRange"), rm.sliceSpan, literal.Range.First, literal.Range.Last)
 			}
 
 			// If the search didn't find anything, fail the match.  If it did find something, then we need to consider whether
@@ -856,7 +1338,7 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 			}
 			c.emitExecuteGoto(rm, rm.doneLabel)
 			c.writeLineFmt(`}
-						pos += %s`, startingPos)
+						%s += %s`, rm.pos, startingPos)
 			c.sliceInputSpan(rm, false)
 		} else if len(iterationCount) == 0 &&
 			node.T == syntax.NtSetlazy &&
@@ -866,17 +1348,18 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 			// e.g. ".*?string" with RegexOptions.Singleline
 			// This lazy loop will consume all characters until the subsequent literal. If the subsequent literal
 			// isn't found, the loop fails. We can implement it to just search for that literal.
+			c.emitDecision("searching with IndexOf for the literal after the loop")
 			c.writeLineFmt("%s = %s", startingPos, indexOfExpr)
 			c.writeLineFmt("if %s < 0 {", startingPos)
 			c.emitExecuteGoto(rm, rm.doneLabel)
 			c.writeLineFmt(`}
-						pos += %s`, startingPos)
+						%s += %s`, rm.pos, startingPos)
 			c.sliceInputSpan(rm, false)
 		}
 	}
 
 	// Store the position we've left off at in case we need to iterate again.
-	c.writeLineFmt("%s = pos", startingPos)
+	c.writeLineFmt("%s = %s", startingPos, rm.pos)
 
 	// Update the done label for everything that comes after this node.  This is done after we emit the single char
 	// matching, as that failing indicates the loop itself has failed to match.
@@ -897,10 +1380,6 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 	// to hold it, and we need a dedicated backtracking section to handle restoring
 	// that state before jumping back into the loop itself.
 	if isInLoop {
-		c.writeLine("")
-		stackCookie := c.createStackCookie()
-
-		// Store the loop's state.
 		args := []string{startingPos}
 		if len(capturePos) > 0 {
 			args = append(args, capturePos)
@@ -908,28 +1387,101 @@ func (c *converter) emitExecuteSingleCharLazy(rm *regexpData, node *syntax.Regex
 		if len(iterationCount) > 0 {
 			args = append(args, iterationCount)
 		}
-		c.emitStackPush(stackCookie, args...)
+		c.emitSingleCharLazyStateInLoop(rm, args)
+	}
+}
 
-		// Skip past the backtracking section.
-		end := rm.reserveName("LazyLoopSkipBacktrack")
-		c.emitExecuteGoto(rm, end)
-		c.writeLine("")
+// Emits a lazy optional single char, e.g. a??.  The continuation is tried with zero chars first,
+// and only if it backtracks in is the char matched, once; backtracking in again fails.  That only
+// needs the starting pos, set to -1 once the char's been matched, where the general lazy loop
+// also counts iterations and searches ahead for the next literal.
+func (c *converter) emitExecuteSingleCharLazyZeroOrOne(rm *regexpData, node *syntax.RegexNode) {
+	// The char is matched from the backtracking section, where sliceStaticPos can't be relied on.
+	c.transferSliceStaticPosToPos(rm, false)
 
-		// Emit a backtracking section that restores the loop's state and then jumps to the previous done label.
-		backtrack := rm.reserveName("CharLazyBacktrack")
-		c.emitMarkLabel(rm, backtrack, false)
+	// Track the current crawl position.  Upon backtracking, we'll unwind any captures beyond this point.
+	var capturePos string
+	if rm.expressionHasCaptures {
+		capturePos = rm.reserveName("lazyoptional_capturepos")
+		rm.addLocalDec(fmt.Sprint(capturePos, " := 0"))
+	}
 
-		// Restore the loop's state.
-		// pop in reverse order
-		slices.Reverse(args)
-		c.emitStackPop(stackCookie, args...)
-		c.emitExecuteGoto(rm, rm.doneLabel)
-		c.writeLine("")
-		rm.doneLabel = backtrack
-		c.emitMarkLabel(rm, end, false)
+	startingPos := rm.reserveName("lazyoptional_pos")
+	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
+	c.writeLineFmt("%s = %s", startingPos, rm.pos)
+
+	// Try the continuation without the char first.
+	endLabel := rm.reserveName("LazyOptionalEnd")
+	c.emitExecuteGoto(rm, endLabel)
+	c.writeLine("")
+
+	// Backtracking section. Subsequent failures will jump to here.
+	backtrackingLabel := rm.reserveBacktrackLabel("LazyOptionalBacktrack")
+	c.emitMarkLabel(rm, backtrackingLabel, false)
+	if len(capturePos) > 0 {
+		c.emitUncaptureUntil(capturePos)
+	}
+
+	// The char's already been matched, there's nothing left to try.
+	c.writeLineFmt("if %s < 0 {", startingPos)
+	c.emitExecuteGoto(rm, rm.doneLabel)
+	c.writeLine("}")
+
+	// Match the char and continue with it. At most one char is matched per time the node's
+	// reached, so unlike the lazy loop there's no timeout check here.
+	c.writeLineFmt("%s = %s", rm.pos, startingPos)
+	c.sliceInputSpan(rm, false)
+	c.emitExecuteSingleChar(rm, node, true, nil, false)
+	c.transferSliceStaticPosToPos(rm, false)
+	c.writeLineFmt("%s = -1", startingPos)
+
+	rm.doneLabel = backtrackingLabel
+	c.writeLine("")
+
+	isInLoop := rm.Analysis.IsInLoop(node)
+	c.emitMarkLabel(rm, endLabel, !(len(capturePos) > 0 || isInLoop))
+	if len(capturePos) != 0 {
+		c.writeLineFmt("%s = r.Crawlpos()", capturePos)
+	}
+
+	if isInLoop {
+		args := []string{startingPos}
+		if len(capturePos) > 0 {
+			args = append(args, capturePos)
+		}
+		c.emitSingleCharLazyStateInLoop(rm, args)
 	}
 }
 
+// For a single char lazy loop inside another loop, pushes the loop's state locals so each
+// iteration of the outer loop gets its own, and emits a backtracking section that restores
+// them before backtracking into the loop, which becomes the doneLabel.
+func (c *converter) emitSingleCharLazyStateInLoop(rm *regexpData, args []string) {
+	c.writeLine("")
+	stackCookie := c.createStackCookie()
+
+	// Store the loop's state.
+	c.emitStackPush(stackCookie, args...)
+
+	// Skip past the backtracking section.
+	end := rm.reserveName("LazyLoopSkipBacktrack")
+	c.emitExecuteGoto(rm, end)
+	c.writeLine("")
+
+	// Emit a backtracking section that restores the loop's state and then jumps to the previous done label.
+	backtrack := rm.reserveBacktrackLabel("CharLazyBacktrack")
+	c.emitMarkLabel(rm, backtrack, false)
+
+	// Restore the loop's state.
+	// pop in reverse order
+	slices.Reverse(args)
+	c.emitStackPop(rm, stackCookie, args...)
+	c.emitExecuteGoto(rm, rm.doneLabel)
+	c.writeLine("")
+	rm.doneLabel = backtrack
+	c.emitMarkLabel(rm, end, false)
+}
+
 // Emits the code to handle a non-backtracking, variable-length loop around a single character comparison.
 // emitLengthChecksIfRequired=true
 func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax.RegexNode, emitLengthChecksIfRequired bool) {
@@ -960,11 +1512,11 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 		if node.IsSetFamily() && maxIterations == math.MaxInt32 && node.Set.IsAnything() {
 			// If this loop will consume the remainder of the input, just set the iteration variable
 			// to pos directly rather than looping to get there.
-			c.writeLineFmt("%s = pos", iterationLocal)
+			c.writeLineFmt("%s = %s", iterationLocal, rm.pos)
 		} else {
 			c.writeLineFmt("%s = 0", iterationLocal)
 
-			expr := fmt.Sprintf("r.Runtext[pos - %s - 1]", iterationLocal)
+			expr := fmt.Sprintf("runtext[%s - %s - 1]", rm.pos, iterationLocal)
 			if node.IsSetFamily() {
 				expr = c.emitMatchCharacterClass(rm, node.Set, false, expr)
 			} else {
@@ -979,17 +1531,17 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 			if maxIterations != math.MaxInt32 {
 				maxClause = fmt.Sprintf("%s && ", countIsLessThan(iterationLocal, maxIterations))
 			}
-			c.writeLineFmt(`for %spos > %s && %s {
-					%[2]s++
+			c.writeLineFmt(`for %s%s > %s && %s {
+					%[3]s++
 				}
-				`, maxClause, iterationLocal, expr)
+				`, maxClause, rm.pos, iterationLocal, expr)
 		}
 	} else if node.IsSetFamily() && maxIterations == math.MaxInt32 && node.Set.IsAnything() {
 		// .* was used with RegexOptions.Singleline, which means it'll consume everything.  Just jump to the end.
 		// The unbounded constraint is the same as in the Notone case above, done purely for simplicity.
 
 		c.transferSliceStaticPosToPos(rm, false)
-		c.writeLineFmt("%s = len(r.Runtext) - pos", iterationLocal)
+		c.writeLineFmt("%s = len(runtext) - %s", iterationLocal, rm.pos)
 	} else if c.tryEmitExecuteIndexOf(rm, node, "%s", false, true, new(int), &indexOfExpr) {
 		// We can use an IndexOf method to perform the search. If the number of iterations is unbounded, we can just search the whole span.
 		// If, however, it's bounded, we need to slice the span to the min(remainingSpan.Length, maxIterations) so that we don't
@@ -999,10 +1551,11 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 		// have been handled as an optional loop above, and if it's 1 and min is 1, it should have been transformed
 		// into a single char match. So, we should only be here if maxIterations is greater than 1. And that's relevant,
 		// because we wouldn't want to invest in an IndexOf call if we're only going to iterate once.
+		c.emitDecision("searching with IndexOf for the first char the loop doesn't match")
 		c.transferSliceStaticPosToPos(rm, false)
 
 		if maxIterations != math.MaxInt32 {
-			indexOfExpr = fmt.Sprintf(indexOfExpr, fmt.Sprintf("%s[:helpers.Min(len(%[1]s), %v)]", rm.sliceSpan, maxIterations))
+			indexOfExpr = fmt.Sprintf(indexOfExpr, fmt.Sprintf("%[1]s[:%[2]s(len(%[1]s), %[3]v)]", rm.sliceSpan, c.helper("Min"), maxIterations))
 		} else {
 			indexOfExpr = fmt.Sprintf(indexOfExpr, rm.sliceSpan)
 		}
@@ -1010,21 +1563,21 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 
 		rhs := fmt.Sprintf("len(%s)", rm.sliceSpan)
 		if maxIterations != math.MaxInt32 {
-			rhs = fmt.Sprintf("helpers.Min(len(%s), %v)", rm.sliceSpan, maxIterations)
+			rhs = fmt.Sprintf("%s(len(%s), %v)", c.helper("Min"), rm.sliceSpan, maxIterations)
 		}
 		c.writeLineFmt(`if %s < 0 {
 				%[1]s = %s
 			}
 			`, iterationLocal, rhs)
 	} else {
-		// For everything else, do a normal loop.
+		// For everything else, do a normal loop, stopping at the first char that doesn't match.
 		expr := fmt.Sprintf("%s[%v]", rm.sliceSpan, iterationLocal)
 		if node.IsSetFamily() {
-			expr = c.emitMatchCharacterClass(rm, node.Set, false, expr)
+			expr = c.emitMatchCharacterClass(rm, node.Set, true, expr)
 		} else {
-			op := "!="
+			op := "=="
 			if node.IsOneFamily() {
-				op = "=="
+				op = "!="
 			}
 			expr = fmt.Sprintf("%s %s %q", expr, op, node.Ch)
 		}
@@ -1039,14 +1592,19 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 		c.writeLineFmt("%s = %v", iterationLocal, rm.sliceStaticPos)
 		rm.sliceStaticPos = 0
 
+		// the char is checked in the body, as the compiler can't prove the index is in bounds
+		// when it's part of the loop condition and the count is used after the loop
 		maxClause := ""
 		if maxIterations != math.MaxInt32 {
-			maxClause = fmt.Sprintf("%s && ", countIsLessThan(iterationLocal, maxIterations))
+			maxClause = fmt.Sprintf(" && %s", countIsLessThan(iterationLocal, maxIterations))
 		}
-		c.writeLineFmt(`for %s%s < len(%s) && %s {
-			   %[2]s++
-		   }
-		   `, maxClause, iterationLocal, rm.sliceSpan, expr)
+		c.writeLineFmt(`for %s < len(%s)%s {
+				if %s {
+					break
+				}
+				%[1]s++
+			}
+			`, iterationLocal, rm.sliceSpan, maxClause, expr)
 	}
 
 	// Check to ensure we've found at least min iterations.
@@ -1061,9 +1619,9 @@ func (c *converter) emitExecuteSingleCharAtomicLoop(rm *regexpData, node *syntax
 
 	if !rtl {
 		c.writeLineFmt(`%s = %[1]s[%s:]
-			pos += %[2]s`, rm.sliceSpan, iterationLocal)
+			%s += %[2]s`, rm.sliceSpan, iterationLocal, rm.pos)
 	} else {
-		c.writeLineFmt("pos -= %s", iterationLocal)
+		c.writeLineFmt("%s -= %s", rm.pos, iterationLocal)
 	}
 }
 
@@ -1107,7 +1665,7 @@ func (c *converter) emitExecuteSingleCharRepeater(rm *regexpData, node *syntax.R
 	if rtl {
 		c.transferSliceStaticPosToPos(rm, false) // we don't use static position with rtl
 		c.writeLineFmt("for i:=0; i < %v; i++ {", iterations)
-		c.emitExecuteSingleChar(rm, node, true, nil, false)
+		c.emitExecuteSingleChar(rm, node, emitLengthCheck, nil, false)
 		c.writeLine("}")
 	} else if node.IsSetFamily() && node.Set.IsAnything() {
 		// This is a repeater for anything, which means we only care about length and can jump past that length.
@@ -1115,7 +1673,7 @@ func (c *converter) emitExecuteSingleCharRepeater(rm *regexpData, node *syntax.R
 			c.emitSpanLengthCheck(rm, iterations, nil)
 		}
 		rm.sliceStaticPos += iterations
-	} else if iterations <= MaxUnrollSize {
+	} else if iterations <= c.maxUnrollSize {
 		// if ((uint)(sliceStaticPos + iterations - 1) >= (uint)slice.Length ||
 		//     slice[sliceStaticPos] != c1 ||
 		//     slice[sliceStaticPos + 1] != c2 ||
@@ -1123,6 +1681,11 @@ func (c *converter) emitExecuteSingleCharRepeater(rm *regexpData, node *syntax.R
 		// {
 		//     goto doneLabel;
 		// }
+		if emitLengthCheck && rm.sliceStaticPos+iterations > maxJoinedLengthCheck {
+			c.emitSpanLengthCheck(rm, iterations, nil)
+			c.writeLine("")
+			emitLengthCheck = false
+		}
 		c.write("if ")
 		if emitLengthCheck {
 			c.write(spanLengthCheck(rm, iterations, nil))
@@ -1155,13 +1718,15 @@ func (c *converter) emitExecuteSingleCharRepeater(rm *regexpData, node *syntax.R
 			c.writeLine("}")
 		} else {
 			repeaterSpan := "repeaterSlice" // As this repeater doesn't wrap arbitrary node emits, this shouldn't conflict with anything
-			c.writeLineFmt(`%s := %s
+			rm.addLocalDec(fmt.Sprintf("var %s []rune", repeaterSpan))
+			c.writeLineFmt(`%s = %s
 						 for i:=0; i < len(%[1]s); i++ {`, repeaterSpan, sliceName)
 			tmpTextSpanLocal, tmpSliceStaticPos := rm.sliceSpan, rm.sliceStaticPos
 			rm.sliceSpan = repeaterSpan
 			rm.sliceStaticPos = 0
 			i := "i"
 			c.emitExecuteSingleChar(rm, node, false, &i, false)
+			c.writeLine("}")
 			rm.sliceSpan = tmpTextSpanLocal
 			rm.sliceStaticPos = tmpSliceStaticPos
 		}
@@ -1171,6 +1736,9 @@ func (c *converter) emitExecuteSingleCharRepeater(rm *regexpData, node *syntax.R
 }
 
 // Emits the code to handle a non-backtracking optional zero-or-one loop.
+// Left to right the char is read through the slice, which keeps sliceStaticPos, so moving the
+// slice and pos on by one keeps them in step. Right to left the char is read before pos and
+// the search region always starts at 0 (the runner has no separate start), so pos > 0 guards it.
 func (c *converter) emitExecuteAtomicSingleCharZeroOrOne(rm *regexpData, node *syntax.RegexNode) {
 	rtl := (node.Options & syntax.RightToLeft) != 0
 	if rtl {
@@ -1179,7 +1747,7 @@ func (c *converter) emitExecuteAtomicSingleCharZeroOrOne(rm *regexpData, node *s
 
 	expr := fmt.Sprintf("%s[%v]", rm.sliceSpan, rm.sliceStaticPos)
 	if rtl {
-		expr = "r.Runtext[pos-1]"
+		expr = fmt.Sprintf("runtext[%s-1]", rm.pos)
 	}
 
 	if node.IsSetFamily() {
@@ -1194,7 +1762,7 @@ func (c *converter) emitExecuteAtomicSingleCharZeroOrOne(rm *regexpData, node *s
 
 	var spaceAvailable string
 	if rtl {
-		spaceAvailable = "pos > 0"
+		spaceAvailable = rm.pos + " > 0"
 	} else if rm.sliceStaticPos != 0 {
 		spaceAvailable = fmt.Sprintf("len(%s) > %v", rm.sliceSpan, rm.sliceStaticPos)
 	} else {
@@ -1204,13 +1772,36 @@ func (c *converter) emitExecuteAtomicSingleCharZeroOrOne(rm *regexpData, node *s
 	c.writeLineFmt("if %s && %s {", spaceAvailable, expr)
 	if !rtl {
 		c.writeLineFmt(`%s = %[1]s[1:]
-		pos++`, rm.sliceSpan)
+		%s++`, rm.sliceSpan, rm.pos)
 	} else {
-		c.writeLineFmt("pos--")
+		c.writeLineFmt("%s--", rm.pos)
 	}
 	c.writeLine("}")
 }
 
+// Writes a comment on why the node's code takes the shape it does, with Options.DecisionComments.
+func (c *converter) emitDecision(format string, args ...any) {
+	if c.opts.DecisionComments {
+		c.writeLineFmt("// Why: "+format, args...)
+	}
+}
+
+// Writes the decisions the general and lazy loops share on how their iterations are tracked.
+func (c *converter) emitLoopDecisions(rm *regexpData, node *syntax.RegexNode, isAtomic, iterationMayBeEmpty bool) {
+	if !c.opts.DecisionComments {
+		return
+	}
+	if isAtomic {
+		c.emitDecision("atomic, the state pushed by iterations is popped on the way out")
+	}
+	if iterationMayBeEmpty {
+		c.emitDecision("iterations may be empty, so each checks it moved")
+	}
+	if rm.Analysis.IsInLoop(node) {
+		c.emitDecision("inside another loop, the iteration state goes on the backtracking stack")
+	}
+}
+
 func (c *converter) emitTimeoutCheckIfNeeded(rm *regexpData) {
 	// we just always write it for now
 	c.emitTimeoutCheck()
@@ -1224,7 +1815,7 @@ func (c *converter) tryEmitExecuteIndexOf(rm *regexpData, node *syntax.RegexNode
 	}
 
 	if node.T == syntax.NtMulti {
-		*indexOfExpr = fmt.Sprintf("helpers.%sIndexOf(%s, %s)", last, spanName, getRuneSliceLiteral(node.Str))
+		*indexOfExpr = fmt.Sprintf("%s(%s, %s)", c.helper(last+"IndexOf"), spanName, getRuneSliceLiteral(node.Str))
 		*literalLength = len(node.Str)
 		return true
 	}
@@ -1232,9 +1823,9 @@ func (c *converter) tryEmitExecuteIndexOf(rm *regexpData, node *syntax.RegexNode
 	if node.IsOneFamily() {
 		var expr string
 		if negate {
-			expr = fmt.Sprintf("helpers.%sIndexOfAnyExcept1(%s, %q)", last, spanName, node.Ch)
+			expr = fmt.Sprintf("%s(%s, %q)", c.helper(last+"IndexOfAnyExcept1"), spanName, node.Ch)
 		} else {
-			expr = fmt.Sprintf("helpers.%sIndexOfAny1(%s, %q)", last, spanName, node.Ch)
+			expr = fmt.Sprintf("%s(%s, %q)", c.helper(last+"IndexOfAny1"), spanName, node.Ch)
 		}
 		*indexOfExpr = expr
 		*literalLength = 1
@@ -1244,9 +1835,9 @@ func (c *converter) tryEmitExecuteIndexOf(rm *regexpData, node *syntax.RegexNode
 	if node.IsNotoneFamily() {
 		var expr string
 		if negate {
-			expr = fmt.Sprintf("helpers.%sIndexOfAny1(%s, %q)", last, spanName, node.Ch)
+			expr = fmt.Sprintf("%s(%s, %q)", c.helper(last+"IndexOfAny1"), spanName, node.Ch)
 		} else {
-			expr = fmt.Sprintf("helpers.%sIndexOfAnyExcept1(%s, %q)", last, spanName, node.Ch)
+			expr = fmt.Sprintf("%s(%s, %q)", c.helper(last+"IndexOfAnyExcept1"), spanName, node.Ch)
 		}
 		*indexOfExpr = expr
 		*literalLength = 1
@@ -1254,24 +1845,36 @@ func (c *converter) tryEmitExecuteIndexOf(rm *regexpData, node *syntax.RegexNode
 	}
 
 	if node.IsSetFamily() {
+		// the ranges and chars below are the set's without its negation, so a loop over [^"\\]
+		// that searches for the first char it doesn't match searches for any of " and \
 		negate = node.Set.IsNegated() != negate
 
 		// Prefer IndexOfAnyInRange over IndexOfAny, except for tiny ranges (1 or 2 items) that IndexOfAny handles more efficiently
 		if rs := node.Set.GetIfNRanges(1); len(rs) == 1 && rs[0].Last-rs[0].First > 1 {
 			var expr string
-			if negate {
-				expr = fmt.Sprintf("helpers.%sIndexOfAnyExceptInRange(%s, %q, %q)", last, spanName, rs[0].First, rs[0].Last)
+			if negate && useLast {
+				expr = fmt.Sprintf("%s(%s, %q, %q)", c.emitLastIndexOfAnyExceptInRangeHelper(), spanName, rs[0].First, rs[0].Last)
+			} else if negate {
+				expr = fmt.Sprintf("%s(%s, %q, %q)", c.helper(last+"IndexOfAnyExceptInRange"), spanName, rs[0].First, rs[0].Last)
 			} else {
-				expr = fmt.Sprintf("helpers.%sIndexOfAnyInRange(%s, %q, %q)", last, spanName, rs[0].First, rs[0].Last)
+				expr = fmt.Sprintf("%s(%s, %q, %q)", c.helper(last+"IndexOfAnyInRange"), spanName, rs[0].First, rs[0].Last)
 			}
 			*indexOfExpr = expr
 			*literalLength = 1
 			return true
 		}
 
+		// Two ranges, e.g. [A-Za-z], are two compares a char, cheaper than a lookup in the set's chars
+		// unless there are few enough of them for IndexOfAny1/2/3
+		if rs := node.Set.GetIfNRanges(2); len(rs) == 2 && (rs[0].Last-rs[0].First)+(rs[1].Last-rs[1].First) > 1 {
+			*indexOfExpr = fmt.Sprintf("%s(%s, %q, %q, %q, %q)", c.emitIndexOfAnyInRangesHelper(negate, useLast), spanName, rs[0].First, rs[0].Last, rs[1].First, rs[1].Last)
+			*literalLength = 1
+			return true
+		}
+
 		setChars := node.Set.GetSetChars(128)
 		if len(setChars) > 0 {
-			expr := c.emitIndexOfChars(setChars, negate, spanName)
+			expr := c.emitIndexOfChars(setChars, negate, useLast, spanName)
 			*indexOfExpr = expr
 			*literalLength = 1
 			return true
@@ -1283,6 +1886,33 @@ func (c *converter) tryEmitExecuteIndexOf(rm *regexpData, node *syntax.RegexNode
 	return false
 }
 
+// The right-to-left version of FindStartingLiteralNode: the concatenations of a
+// right-to-left node are already in matching order, so it's the same walk.
+func findStartingLiteralNodeRightToLeft(node *syntax.RegexNode) *syntax.RegexNode {
+	for node != nil && node.Options&syntax.RightToLeft != 0 {
+		switch node.T {
+		case syntax.NtOne, syntax.NtNotone, syntax.NtMulti, syntax.NtSet:
+			return node
+
+		case syntax.NtOneloop, syntax.NtOneloopatomic, syntax.NtOnelazy,
+			syntax.NtNotoneloop, syntax.NtNotoneloopatomic, syntax.NtNotonelazy,
+			syntax.NtSetloop, syntax.NtSetloopatomic, syntax.NtSetlazy:
+			if node.M > 0 {
+				return node
+			}
+			return nil
+
+		case syntax.NtAtomic, syntax.NtConcatenate, syntax.NtCapture, syntax.NtGroup,
+			syntax.NtLoop, syntax.NtLazyloop:
+			node = node.Children[0]
+
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
 func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 	switch node.T {
 	case syntax.NtBeginning, syntax.NtStart:
@@ -1292,9 +1922,9 @@ func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 			c.emitExecuteGoto(rm, rm.doneLabel)
 		} else {
 			if node.T == syntax.NtBeginning {
-				c.writeLine("if pos != 0 {")
+				c.writeLineFmt("if %s != 0 {", rm.pos)
 			} else {
-				c.writeLine("if pos != r.Runtextstart {")
+				c.writeLineFmt("if %s != r.Runtextstart {", rm.pos)
 			}
 			c.emitExecuteGoto(rm, rm.doneLabel)
 			c.writeLine("}")
@@ -1304,7 +1934,7 @@ func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 		if rm.sliceStaticPos > 0 {
 			c.writeLineFmt("if %s[%v-1] != '\\n' {", rm.sliceSpan, rm.sliceStaticPos)
 		} else {
-			c.writeLine("if pos > 0 && r.Runtext[pos-1] != '\\n' {")
+			c.writeLineFmt("if %[1]s > 0 && runtext[%[1]s-1] != '\\n' {", rm.pos)
 		}
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}")
@@ -1313,7 +1943,7 @@ func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 		if rm.sliceStaticPos > 0 {
 			c.writeLineFmt("if %v < len(%s) {", rm.sliceStaticPos, rm.sliceSpan)
 		} else {
-			c.writeLine("if pos < len(r.Runtext) {")
+			c.writeLineFmt("if %s < len(runtext) {", rm.pos)
 		}
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}")
@@ -1322,7 +1952,7 @@ func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 		if rm.sliceStaticPos > 0 {
 			c.writeLineFmt("if len(%s) > %v || (len(%[1]s) > %[3]v && %[1]s[%[3]v] != '\\n') {", rm.sliceSpan, rm.sliceStaticPos+1, rm.sliceStaticPos)
 		} else {
-			c.writeLine("if (pos < len(r.Runtext) - 1) || (pos < len(r.Runtext) && r.Runtext[pos] != '\\n') {")
+			c.writeLineFmt("if (%[1]s < len(runtext) - 1) || (%[1]s < len(runtext) && runtext[%[1]s] != '\\n') {", rm.pos)
 		}
 
 		c.emitExecuteGoto(rm, rm.doneLabel)
@@ -1332,7 +1962,7 @@ func (c *converter) emitExecuteAnchors(rm *regexpData, node *syntax.RegexNode) {
 		if rm.sliceStaticPos > 0 {
 			c.writeLineFmt("if %v < len(%s) && %[2]s[%[1]v] != '\\n' {", rm.sliceStaticPos, rm.sliceSpan)
 		} else {
-			c.writeLine("if pos < len(r.Runtext) && r.Runtext[pos] != '\\n' {")
+			c.writeLineFmt("if %[1]s < len(runtext) && runtext[%[1]s] != '\\n' {", rm.pos)
 		}
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}")
@@ -1357,7 +1987,7 @@ func (c *converter) emitExecuteBoundary(rm *regexpData, node *syntax.RegexNode)
 	if rm.sliceStaticPos > 0 {
 		end = fmt.Sprint(" + ", rm.sliceStaticPos)
 	}
-	c.writeLineFmt("if %s(pos%s) {", call, end)
+	c.writeLineFmt("if %s(%s%s) {", call, rm.pos, end)
 	c.emitExecuteGoto(rm, rm.doneLabel)
 	c.writeLineFmt("}")
 }
@@ -1375,10 +2005,14 @@ func (c *converter) emitExecuteNonBacktrackingRepeater(rm *regexpData, node *syn
 }
 
 func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
+	c.emitExecuteLoopIterations(rm, node, node.M, node.N)
+}
+
+// Emits the loop matching node's child between minIterations and maxIterations times, which are
+// node's own bounds except for an atomic lazy loop, which only ever matches its minimum.
+func (c *converter) emitExecuteLoopIterations(rm *regexpData, node *syntax.RegexNode, minIterations, maxIterations int) {
 	child := node.Children[0]
 
-	minIterations := node.M
-	maxIterations := node.N
 	stackCookie := c.createStackCookie()
 
 	// Special-case some repeaters.
@@ -1389,6 +2023,7 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 		}
 		if minIterations == 1 {
 			// One iteration.  Just emit the child without any loop ceremony.
+			c.emitDecision("exactly one iteration, no loop")
 			c.emitExecuteNode(rm, child, nil, true)
 			return
 		}
@@ -1396,6 +2031,7 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 			// The child doesn't backtrack.  Emit it as a non-backtracking repeater.
 			// (If the child backtracks, we need to fall through to the more general logic
 			// that supports unwinding iterations.)
+			c.emitDecision("a fixed number of iterations of a child that never backtracks, no backtracking")
 			c.emitExecuteNonBacktrackingRepeater(rm, node)
 			return
 		}
@@ -1448,11 +2084,12 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 	// additional checks if we can prove that the loop can never match empty, which we can do by computing
 	// the minimum length of the child; only if it's 0 might iterations be empty.
 	iterationMayBeEmpty := child.ComputeMinLength() == 0
+	c.emitLoopDecisions(rm, node, isAtomic, iterationMayBeEmpty)
 	var startingPos string
 	if iterationMayBeEmpty {
 		startingPos = rm.reserveName("loop_starting_pos")
 		rm.addLocalDec(fmt.Sprintf("%s, %s := 0, 0", iterationCount, startingPos))
-		c.writeLineFmt("%s = pos", startingPos)
+		c.writeLineFmt("%s = %s", startingPos, rm.pos)
 	} else {
 		rm.addLocalDec(fmt.Sprintf("%s := 0", iterationCount))
 	}
@@ -1470,13 +2107,13 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 	// true even if the loop is atomic, as we might need to backtrack within the loop in order to match the
 	// minimum iteration count.
 	if rm.expressionHasCaptures && iterationMayBeEmpty {
-		c.emitStackPush(stackCookie, "r.Crawlpos()", startingPos, "pos")
+		c.emitStackPush(stackCookie, "r.Crawlpos()", startingPos, rm.pos)
 	} else if rm.expressionHasCaptures {
-		c.emitStackPush(stackCookie, "r.Crawlpos()", "pos")
+		c.emitStackPush(stackCookie, "r.Crawlpos()", rm.pos)
 	} else if iterationMayBeEmpty {
-		c.emitStackPush(stackCookie, startingPos, "pos")
+		c.emitStackPush(stackCookie, startingPos, rm.pos)
 	} else {
-		c.emitStackPush(stackCookie, "pos")
+		c.emitStackPush(stackCookie, rm.pos)
 	}
 	c.writeLine("")
 
@@ -1485,7 +2122,7 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 	// iterations are allowed as part of min matches, but once we've met the min quote, empty matches
 	// are considered match failures.
 	if iterationMayBeEmpty {
-		c.writeLineFmt("%s = pos", startingPos)
+		c.writeLineFmt("%s = %s", startingPos, rm.pos)
 	}
 
 	// Proactively increase the number of iterations.  We do this prior to the match rather than once
@@ -1527,23 +2164,23 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 			// the iteration isn't empty or we still need more iterations to meet the minimum.
 			c.writeLineFmt(`// The loop has a lower bound of %v but no upper bound. Continue iterating greedily
 						// if the last iteration wasn't empty (or if it was, if the lower bound hasn't yet been reached).
-						if pos != %s || %s {
-						`, minIterations, startingPos, countIsLessThan(iterationCount, minIterations))
+						if %s != %s || %s {
+						`, minIterations, rm.pos, startingPos, countIsLessThan(iterationCount, minIterations))
 		} else if minIterations > 0 {
 			// Iterations may be empty and there's both a lower and upper bound on the loop.
 			c.writeLineFmt(`// The loop has a lower bound of %v and an upper bound of %v. Continue iterating
 						// greedily if the upper bound hasn't yet been reached and either the last iteration was non-empty or the
 						// lower bound hasn't yet been reached.
-						if (pos != %s || %s) && %s {`, minIterations, maxIterations, startingPos, countIsLessThan(iterationCount, minIterations), countIsLessThan(iterationCount, maxIterations))
+						if (%s != %s || %s) && %s {`, minIterations, maxIterations, rm.pos, startingPos, countIsLessThan(iterationCount, minIterations), countIsLessThan(iterationCount, maxIterations))
 		} else if maxIterations == math.MaxInt32 {
 			// Iterations may be empty and there's no lower or upper bound.
 			c.writeLineFmt(`// The loop is unbounded. Continue iterating greedily as long as the last iteration wasn't empty.
-						if pos != %s {`, startingPos)
+						if %s != %s {`, rm.pos, startingPos)
 		} else {
 			// Iterations may be empty, there's no lower bound, but there is an upper bound.
 			c.writeLineFmt(`// The loop has an upper bound of %v. Continue iterating greedily if the upper bound hasn't
 						// yet been reached (as long as the last iteration wasn't empty).
-						if pos != %s && %s {`, maxIterations, startingPos, countIsLessThan(iterationCount, maxIterations))
+						if %s != %s && %s {`, maxIterations, rm.pos, startingPos, countIsLessThan(iterationCount, maxIterations))
 		}
 
 		c.emitExecuteGoto(rm, body)
@@ -1571,16 +2208,16 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 	c.writeLine("}")
 
 	if iterationMayBeEmpty {
-		c.emitStackPop(0, "pos", startingPos) // stack cookie handled is explicitly 0 to handle it below
+		c.emitStackPop(rm, 0, rm.pos, startingPos) // stack cookie handled is explicitly 0 to handle it below
 	} else {
-		c.emitStackPop(0, "pos")
+		c.emitStackPop(rm, 0, rm.pos)
 	}
 
 	if rm.expressionHasCaptures {
 		c.emitUncaptureUntil("r.StackPop()")
 	}
 
-	c.emitStackCookieValidate(stackCookie)
+	c.emitStackCookieValidate(rm, stackCookie)
 	c.sliceInputSpan(rm, false)
 
 	// If there's a required minimum iteration count, validate now that we've processed enough iterations.
@@ -1630,6 +2267,8 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 	}
 
 	if isAtomic {
+		// backtracking past the loop goes wherever it did before the loop, e.g. to the next
+		// branch when the loop is in an alternation's branch, see TestAtomicLoop_InAlternation
 		rm.doneLabel = originalDoneLabel
 		c.emitMarkLabel(rm, endLoop, len(startingStackpos) == 0)
 
@@ -1645,7 +2284,7 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 			c.emitExecuteGoto(rm, endLoop)
 			c.writeLine("")
 
-			backtrack := rm.reserveName("LoopBacktrack")
+			backtrack := rm.reserveBacktrackLabel("LoopBacktrack")
 			c.emitMarkLabel(rm, backtrack, false)
 
 			// We're backtracking.  Check the timeout.
@@ -1691,17 +2330,17 @@ func (c *converter) emitExecuteLoop(rm *regexpData, node *syntax.RegexNode) {
 			c.writeLine("")
 
 			// Emit a backtracking section that restores the loop's state and then jumps to the previous done label
-			backtrack := rm.reserveName("LoopBacktrack")
+			backtrack := rm.reserveBacktrackLabel("LoopBacktrack")
 			c.emitMarkLabel(rm, backtrack, false)
 
 			if len(startingPos) > 0 && len(startingStackpos) > 0 {
-				c.emitStackPop(stackCookie, iterationCount, startingPos, startingPos)
+				c.emitStackPop(rm, stackCookie, iterationCount, startingStackpos, startingPos)
 			} else if len(startingPos) > 0 {
-				c.emitStackPop(stackCookie, iterationCount, startingPos)
+				c.emitStackPop(rm, stackCookie, iterationCount, startingPos)
 			} else if len(startingStackpos) > 0 {
-				c.emitStackPop(stackCookie, iterationCount, startingStackpos)
+				c.emitStackPop(rm, stackCookie, iterationCount, startingStackpos)
 			} else {
-				c.emitStackPop(stackCookie, iterationCount)
+				c.emitStackPop(rm, stackCookie, iterationCount)
 			}
 
 			// We're backtracking.  Check the timeout.
@@ -1732,11 +2371,14 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 
 	// We should only be here if the lazy loop isn't atomic due to an ancestor, as the optimizer should
 	// in such a case have lowered the loop's upper bound to its lower bound, at which point it would
-	// have been handled by the above delegation to EmitLoop.  However, if the optimizer missed doing so,
-	// this loop could still be considered atomic by ancestor by its parent nodes, in which case we want
-	// to make sure the code emitted here conforms (e.g. doesn't leave any state erroneously on the stack).
-	// So, we assert it's not atomic, but still handle that case.
-	isAtomic := rm.Analysis.IsAtomicByAncestor(node)
+	// have been handled by the above delegation to EmitLoop.  However, the optimizer misses doing so,
+	// e.g. for the last node of a right-to-left pattern like (\b)*?x, and nothing will backtrack into
+	// the loop for more iterations, so it's a repeater of its lower bound.
+	if rm.Analysis.IsAtomicByAncestor(node) {
+		c.emitDecision("atomic, so only the minimum is matched, nothing after it can backtrack in for more")
+		c.emitExecuteLoopIterations(rm, node, minIterations, minIterations)
+		return
+	}
 
 	// We might loop any number of times.  In order to ensure this loop and subsequent code sees sliceStaticPos
 	// the same regardless, we always need it to contain the same value, and the easiest such value is 0.
@@ -1755,11 +2397,13 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 	// additional checks if we can prove that the loop can never match empty, which we can do by computing
 	// the minimum length of the child; only if it's 0 might iterations be empty.
 	iterationMayBeEmpty := child.ComputeMinLength() == 0
+	c.emitLoopDecisions(rm, node, false, iterationMayBeEmpty)
 	var startingPos, sawEmpty string
 	if iterationMayBeEmpty {
 		startingPos = rm.reserveName("lazyloop_starting_pos")
 		sawEmpty = rm.reserveName("lazyloop_empty_seen")
-		c.writeLineFmt("%s, %s := pos, 0 // the lazy loop may match empty iterations", startingPos, sawEmpty)
+		rm.addLocalDec(fmt.Sprintf("%s, %s := 0, 0", startingPos, sawEmpty))
+		c.writeLineFmt("%s, %s = %s, 0 // the lazy loop may match empty iterations", startingPos, sawEmpty, rm.pos)
 	}
 
 	// If the min count is 0, start out by jumping right to what's after the loop.  Backtracking
@@ -1770,16 +2414,13 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 	c.writeLine("")
 
 	// Iteration body
-	c.emitMarkLabel(rm, body, isAtomic)
+	c.emitMarkLabel(rm, body, false)
 
 	// In case iterations are backtracked through and unwound, we need to store the current position (so that
 	// matching can resume from that location), the current crawl position if captures are possible (so that
 	// we can uncapture back to that position), and both the starting position from the iteration we're leaving
 	// and whether we've seen an empty iteration (if iterations may be empty).  Since there can be multiple
 	// iterations, this state needs to be stored on to the backtracking stack.
-	if isAtomic {
-		return
-	}
 	stackCookie := c.createStackCookie()
 	entriesPerIteration := 1 //pos
 	if iterationMayBeEmpty {
@@ -1791,7 +2432,7 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 	if stackCookie != 0 {
 		entriesPerIteration += 1
 	}
-	args := []string{"pos"}
+	args := []string{rm.pos}
 	if iterationMayBeEmpty {
 		args = append(args, startingPos, sawEmpty)
 	}
@@ -1803,7 +2444,7 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 	if iterationMayBeEmpty {
 		// We need to store the current pos so we can compare it against pos after the iteration, in order to
 		// determine whether the iteration was empty.
-		c.writeLineFmt("%s = pos", startingPos)
+		c.writeLineFmt("%s = %s", startingPos, rm.pos)
 	}
 
 	// Proactively increase the number of iterations.  We do this prior to the match rather than once
@@ -1842,10 +2483,10 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 		// If the last iteration was empty, we need to prevent further iteration from this point
 		// unless we backtrack out of this iteration.
 		c.writeLineFmt(`// If the iteration successfully matched zero-length input, record that an empty iteration was seen.
-						if pos == %s {
+						if %s == %s {
 							%s = 1 // true
 						}
-						`, startingPos, sawEmpty)
+						`, rm.pos, startingPos, sawEmpty)
 	}
 
 	// We matched the next iteration.  Jump to the subsequent code.
@@ -1867,12 +2508,13 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 		if rm.expressionHasCaptures {
 			c.emitUncaptureUntil("r.StackPop()")
 		}
-		args := []string{"pos"}
+		// popped in the reverse of the order the iteration pushed them
+		args := []string{rm.pos}
 		if iterationMayBeEmpty {
-			args = append(args, sawEmpty, startingPos)
+			args = []string{sawEmpty, startingPos, rm.pos}
 		}
 
-		c.emitStackPop(stackCookie, args...)
+		c.emitStackPop(rm, stackCookie, args...)
 		c.sliceInputSpan(rm, false)
 
 		// If the loop's child doesn't backtrack, then this loop has failed.
@@ -1924,7 +2566,7 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 	// and thus it needs to be pushed on to the backtracking stack.
 	isInLoop := rm.Analysis.IsInLoop(node)
 	stackCookie = c.createStackCookie()
-	args = []string{"pos"}
+	args = []string{rm.pos}
 	if isInLoop {
 		args = append(args, iterationCount)
 		if iterationMayBeEmpty {
@@ -1942,7 +2584,7 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 
 	// Emit a backtracking section that checks the timeout, restores the loop's state, and jumps to
 	// the appropriate label.
-	backtrack := rm.reserveName("LazyLoopBacktrack")
+	backtrack := rm.reserveBacktrackLabel("LazyLoopBacktrack")
 	c.emitMarkLabel(rm, backtrack, false)
 
 	// We're backtracking.  Check the timeout.
@@ -1952,13 +2594,13 @@ func (c *converter) emitExecuteLazy(rm *regexpData, node *syntax.RegexNode) {
 		c.emitUncaptureUntil("r.StackPop()")
 	}
 	if !isInLoop {
-		args = []string{"pos"}
+		args = []string{rm.pos}
 	} else if iterationMayBeEmpty {
-		args = []string{sawEmpty, startingPos, iterationCount, "pos"}
+		args = []string{sawEmpty, startingPos, iterationCount, rm.pos}
 	} else {
-		args = []string{iterationCount, "pos"}
+		args = []string{iterationCount, rm.pos}
 	}
-	c.emitStackPop(stackCookie, args...)
+	c.emitStackPop(rm, stackCookie, args...)
 	c.sliceInputSpan(rm, false)
 
 	// Determine where to branch, either back to the lazy loop body to add an additional iteration,
@@ -2018,6 +2660,11 @@ const SetCharsSize = 64
 func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNode) {
 	originalDoneLabel := rm.doneLabel
 
+	if node == rm.longestFirst {
+		c.emitDecision("trying the literal branches longest first")
+		node = longestFirstBranches(rm, node)
+	}
+
 	// Both atomic and non-atomic are supported.  While a parent RegexNode.Atomic node will itself
 	// successfully prevent backtracking into this child node, we can emit better / cheaper code
 	// for an Alternate when it is atomic, so we still take it into account here.
@@ -2031,7 +2678,7 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 	// we can't goto _into_ switch cases, which means we can't use this approach if there's any
 	// possibility of backtracking into the alternation.
 	useSwitchedBranches := false
-	if node.Options&syntax.RightToLeft == 0 {
+	if node.Options&syntax.RightToLeft == 0 && !rm.explain {
 		useSwitchedBranches = isAtomic
 		if !useSwitchedBranches {
 			useSwitchedBranches = true
@@ -2044,49 +2691,30 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 		}
 	}
 
-	// Detect whether every branch begins with one or more unique characters.
-	//setChars := make([]rune, 0, SetCharsSize)
+	// Detect whether every branch begins with one or more unique characters. If only some of them
+	// share starting chars, an atomic alternation can still switch: each group of branches that
+	// share chars gets one case that tries just those branches in order.
+	var groups [][]int
 	if useSwitchedBranches {
-		// Iterate through every branch, seeing if we can easily find a starting One, Multi, or small Set.
-		// If we can, extract its starting char (or multiple in the case of a set), validate that all such
-		// starting characters are unique relative to all the branches.
-		seenChars := make(map[rune]struct{})
-		for i := 0; i < len(node.Children) && useSwitchedBranches; i++ {
-			// Look for the guaranteed starting node that's a one, multi, set,
-			// or loop of one of those with at least one minimum iteration. We need to exclude notones.
-			startingLiteralNode := node.Children[i].FindStartingLiteralNode(false)
-			if startingLiteralNode == nil || startingLiteralNode.IsNotoneFamily() {
-				useSwitchedBranches = false
-				break
-			}
-
-			// If it's a One or a Multi, get the first character and add it to the set.
-			// If it was already in the set, we can't apply this optimization.
-			if startingLiteralNode.IsOneFamily() || startingLiteralNode.T == syntax.NtMulti {
-				st := startingLiteralNode.FirstCharOfOneOrMulti()
-				if _, ok := seenChars[st]; ok {
-					useSwitchedBranches = false
-					break
-				}
-				seenChars[st] = struct{}{}
-			} else {
-				// The branch begins with a set.  Make sure it's a set of only a few characters
-				// and get them.  If we can't, we can't apply this optimization.
-				setChars := startingLiteralNode.Set.GetSetChars(SetCharsSize)
-				if startingLiteralNode.Set.IsNegated() || len(setChars) == 0 {
-					useSwitchedBranches = false
-					break
-				}
+		useSwitchedBranches = false
+		if branchChars := alternationStartingChars(node); branchChars != nil {
+			groups = groupOverlappingBranches(branchChars)
+			useSwitchedBranches = len(groups) == len(node.Children) || (isAtomic && len(groups) > 1)
+		}
+	}
 
-				// Check to make sure each of the chars is unique relative to all other branches examined.
-				for _, c := range setChars {
-					if _, ok := seenChars[c]; ok {
-						useSwitchedBranches = false
-						break
-					}
-					seenChars[c] = struct{}{}
-				}
-			}
+	if !useSwitchedBranches && node.Options&syntax.RightToLeft == 0 && !rm.explain {
+		// Branches that are all literals, where no branch is a prefix of another, also match at most
+		// one branch at any position.  If several of them share a prefix we can't switch on just the
+		// first char, but we can switch char by char down a trie so shared prefixes are matched once.
+		// If the alternation is atomic, a branch can also end partway down another's path, since
+		// only the first of them that matches is ever used.
+		if trie := buildAlternationTrie(node, isAtomic); trie != nil {
+			c.emitDecision("literal branches sharing prefixes, switching char by char down a trie")
+			startingSliceStaticPos := rm.sliceStaticPos
+			c.emitExecuteAlternationTrie(rm, trie, startingSliceStaticPos, 0)
+			rm.sliceStaticPos = 0
+			return
 		}
 	}
 
@@ -2097,19 +2725,34 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 		// it'll optimize the order of checks in order to minimize the total number in the worst
 		// case.  In any case, we get easier to read and reason about C#.
 		//c.emitExecuteSwitchedBranches()
+		if len(groups) == len(node.Children) {
+			c.emitDecision("every branch starts with different chars, switching on the first")
+		} else {
+			c.emitDecision("atomic, switching on the first char, with one case for the branches that share one")
+		}
 		// We need at least 1 remaining character in the span, for the char to switch on.
 		c.emitSpanLengthCheck(rm, 1, nil)
 		c.writeLine("")
 
 		// Emit a switch statement on the first char of each branch.
 		c.writeLineFmt("switch %s[%v] {", rm.sliceSpan, rm.sliceStaticPos)
+		rm.switchCaseDepth++
 
 		startingSliceStaticPos := rm.sliceStaticPos
 
-		// Emit a case for each branch.
-		for i := 0; i < len(node.Children); i++ {
+		// Emit a case for each branch, or group of branches that share starting chars.
+		for _, group := range groups {
 			rm.sliceStaticPos = startingSliceStaticPos
 
+			if len(group) > 1 {
+				c.emitExecuteOverlappingBranches(rm, node, group)
+				rm.doneLabel = originalDoneLabel
+				c.transferSliceStaticPosToPos(rm, false)
+				c.debugAssertf(rm.sliceStaticPos == 0, "alternation case for branches %v exits at sliceStaticPos %v", group, rm.sliceStaticPos)
+				c.writeLine("")
+				continue
+			}
+
 			// We know we're only in this code if every branch has a valid starting literal node. Get it.
 			// We also get the immediate child. Ideally they're the same, in which case we might be able to
 			// use the switch as the processing of that node, e.g. if the node is a One, then by matching the
@@ -2117,7 +2760,7 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			// sufficient, e.g. if that one was wrapped in a Capture, we still want to emit the capture code,
 			// and for simplicity, we still end up emitting the re-evaluation of that character. It's still much
 			// cheaper to do this than to emit the full alternation code.
-			child := node.Children[i]
+			child := node.Children[group[0]]
 			startingLiteralNode := child.FindStartingLiteralNode(false)
 
 			// Emit the case for this branch to match on the first character.
@@ -2181,15 +2824,22 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			// matter what the value is after the branch, whatever follows the alternate
 			// will see the same sliceStaticPos.
 			c.transferSliceStaticPosToPos(rm, false)
+			c.debugAssertf(rm.sliceStaticPos == 0, "alternation case for branch %v exits at sliceStaticPos %v", group[0], rm.sliceStaticPos)
 			c.writeLine("")
 		}
 
 		// Default branch if the character didn't match the start of any branches.
 		c.emitCaseGoto(rm, "default:", rm.doneLabel)
 		c.writeLine("}")
+		rm.switchCaseDepth--
 
 	} else {
 		//c.emitExecuteAllBranches(rm)
+		if isAtomic {
+			c.emitDecision("trying each branch in turn, atomic so nothing backtracks into a branch")
+		} else {
+			c.emitDecision("trying each branch in turn, backtracking into the one that matched and then the next")
+		}
 		// Label to jump to when any branch completes successfully.
 		matchLabel := rm.reserveName("AlternationMatch")
 
@@ -2201,7 +2851,7 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 		canUseLocalsForAllState := !isAtomic && !rm.Analysis.IsInLoop(node)
 
 		rm.addLocalDec(fmt.Sprintf("%s := 0", startingPos))
-		c.writeLineFmt("%s = pos", startingPos)
+		c.writeLineFmt("%s = %s", startingPos, rm.pos)
 
 		startingSliceStaticPos := rm.sliceStaticPos
 
@@ -2241,7 +2891,7 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 		// existed (in which case the doneLabel upon emitting that node will be different from before it)
 		// or the label for the next branch.
 		labelMap := make([]string, len(node.Children))
-		backtrackLabel := rm.reserveName("AlternationBacktrack")
+		backtrackLabel := rm.reserveBacktrackLabel("AlternationBacktrack")
 
 		// We're not atomic, so we'll have to handle backtracking, but we're not inside of a loop,
 		// so we can store the current branch in a local rather than pushing it on to the backtracking
@@ -2274,6 +2924,8 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			}
 
 			// Emit the code for each branch.
+			c.debugAssertf(rm.sliceStaticPos == startingSliceStaticPos, "alternation branch %v starts at sliceStaticPos %v, expected %v", i, rm.sliceStaticPos, startingSliceStaticPos)
+			c.emitExplainTrace(rm, "branch %d at %d", strconv.Itoa(i), staticPosExpr(rm))
 			c.emitExecuteNode(rm, node.Children[i], nil, true)
 			c.writeLine("")
 
@@ -2286,10 +2938,15 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 				// the backtracking stack.  If we're not inside of a loop, simply ensure all
 				// the relevant state is stored in our locals.
 				if len(currentBranch) == 0 {
+					// the cookie is offset by the branch, see validateStackCookieWithAdditionAndReturnPoppedStack
+					branchCookie := 0
+					if stackCookie != 0 {
+						branchCookie = stackCookie + i
+					}
 					if len(startingCapturePos) != 0 {
-						c.emitStackPush(stackCookie+i, strconv.Itoa(i), startingPos, startingCapturePos)
+						c.emitStackPush(branchCookie, strconv.Itoa(i), startingPos, startingCapturePos)
 					} else {
-						c.emitStackPush(stackCookie+i, strconv.Itoa(i), startingPos)
+						c.emitStackPush(branchCookie, strconv.Itoa(i), startingPos)
 					}
 				} else {
 					c.writeLineFmt("%s = %v", currentBranch, i)
@@ -2302,6 +2959,8 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			// matter what the value is after the branch, whatever follows the alternate
 			// will see the same sliceStaticPos.
 			c.transferSliceStaticPosToPos(rm, false)
+			c.debugAssertf(rm.sliceStaticPos == 0, "alternation branch %v exits at sliceStaticPos %v", i, rm.sliceStaticPos)
+			c.emitExplainTrace(rm, "branch %d matched, at %d", strconv.Itoa(i), rm.pos)
 			if !isLastBranch || !isAtomic {
 				// If this isn't the last branch, we're about to output a reset section,
 				// and if this isn't atomic, there will be a backtracking section before
@@ -2319,7 +2978,7 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			if !isLastBranch {
 				c.writeLine("")
 				c.emitMarkLabel(rm, nextBranch, false)
-				c.writeLineFmt("pos = %s", startingPos)
+				c.writeLineFmt("%s = %s", rm.pos, startingPos)
 				c.sliceInputSpan(rm, false)
 				rm.sliceStaticPos = startingSliceStaticPos
 				if len(startingCapturePos) != 0 {
@@ -2351,12 +3010,12 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 				// We're in a loop, so we use the backtracking stack to persist our state.
 				// Pop it off and validate the stack position.
 				if len(startingCapturePos) != 0 {
-					c.emitStackPop(0, startingCapturePos, startingPos)
+					c.emitStackPop(rm, 0, startingCapturePos, startingPos)
 				} else {
-					c.emitStackPop(0, startingPos)
+					c.emitStackPop(rm, 0, startingPos)
 				}
 
-				switchClause = c.validateStackCookieWithAdditionAndReturnPoppedStack(stackCookie)
+				switchClause = c.validateStackCookieWithAdditionAndReturnPoppedStack(rm, stackCookie)
 			} else {
 				// We're not in a loop, so our locals already store the state we need.
 				switchClause = currentBranch
@@ -2368,7 +3027,278 @@ func (c *converter) emitExecuteAlternation(rm *regexpData, node *syntax.RegexNod
 			c.writeLine("}\n")
 		}
 
-		// Successfully completed the alternate.
+		// Successfully completed the alternate.  Every branch got here with sliceStaticPos moved into pos.
+		c.debugAssertf(rm.sliceStaticPos == 0, "alternation ends at sliceStaticPos %v", rm.sliceStaticPos)
+		c.emitMarkLabel(rm, matchLabel, true)
+	}
+}
+
+// Returns a copy of the alternation with its branches ordered longest first, shorter branches
+// keeping their order, for Options.LongestFirstAlternation.  The branches are literals, so each
+// has a fixed length and no groups to renumber.
+func longestFirstBranches(rm *regexpData, node *syntax.RegexNode) *syntax.RegexNode {
+	sorted := &syntax.RegexNode{T: node.T, Options: node.Options, Children: slices.Clone(node.Children)}
+	slices.SortStableFunc(sorted.Children, func(a, b *syntax.RegexNode) int {
+		return b.ComputeMinLength() - a.ComputeMinLength()
+	})
+	rm.Analysis.addLike(sorted, node)
+	return sorted
+}
+
+// Returns the chars each branch of the alternation can start with, or nil if any branch doesn't
+// start with a One, Multi or Set of a few chars, or a loop of one of those with at least one iteration.
+func alternationStartingChars(node *syntax.RegexNode) [][]rune {
+	retval := make([][]rune, len(node.Children))
+	for i, child := range node.Children {
+		// We need to exclude notones.
+		startingLiteralNode := child.FindStartingLiteralNode(false)
+		if startingLiteralNode == nil || startingLiteralNode.IsNotoneFamily() {
+			return nil
+		}
+		if startingLiteralNode.IsOneFamily() || startingLiteralNode.T == syntax.NtMulti {
+			retval[i] = []rune{startingLiteralNode.FirstCharOfOneOrMulti()}
+			continue
+		}
+		// The branch begins with a set.  Make sure it's a set of only a few characters and get them.
+		setChars := startingLiteralNode.Set.GetSetChars(SetCharsSize)
+		if startingLiteralNode.Set.IsNegated() || len(setChars) == 0 {
+			return nil
+		}
+		retval[i] = setChars
+	}
+	return retval
+}
+
+// Groups the branches that share a starting char with another branch, directly or through other
+// branches. Each group has its branch indexes in order, and the groups are in the order of their
+// first branch, so branches that share no chars with any other are groups of their own.
+func groupOverlappingBranches(branchChars [][]rune) [][]int {
+	// the group each branch is in is the lowest branch it's connected to
+	groupOf := make([]int, len(branchChars))
+	for i := range groupOf {
+		groupOf[i] = i
+	}
+	root := func(i int) int {
+		for groupOf[i] != i {
+			i = groupOf[i]
+		}
+		return i
+	}
+	firstBranch := make(map[rune]int)
+	for i, chars := range branchChars {
+		for _, ch := range chars {
+			first, ok := firstBranch[ch]
+			if !ok {
+				firstBranch[ch] = i
+				continue
+			}
+			if a, b := root(first), root(i); a != b {
+				groupOf[max(a, b)] = min(a, b)
+			}
+		}
+	}
+
+	var groups [][]int
+	index := make(map[int]int)
+	for i := range branchChars {
+		r := root(i)
+		if gi, ok := index[r]; ok {
+			groups[gi] = append(groups[gi], i)
+		} else {
+			index[r] = len(groups)
+			groups = append(groups, []int{i})
+		}
+	}
+	return groups
+}
+
+// Emits the switch case for a group of an atomic alternation's branches that share starting chars:
+// it matches any of their starting chars and then tries just those branches, in order, as an
+// alternation of their own.
+func (c *converter) emitExecuteOverlappingBranches(rm *regexpData, node *syntax.RegexNode, group []int) {
+	var chars []rune
+	alternation := &syntax.RegexNode{T: syntax.NtAlternate, Options: node.Options}
+	for _, i := range group {
+		for _, ch := range alternationStartingChars(&syntax.RegexNode{Children: node.Children[i : i+1]})[0] {
+			if !slices.Contains(chars, ch) {
+				chars = append(chars, ch)
+			}
+		}
+		alternation.Children = append(alternation.Children, node.Children[i])
+	}
+
+	cases := make([]string, len(chars))
+	for i, ch := range chars {
+		cases[i] = fmt.Sprintf("%q", ch)
+	}
+	c.writeLineFmt("case %s:", strings.Join(cases, ", "))
+
+	// the branches' own alternation is atomic like the whole one, so it doesn't leave anything
+	// to backtrack into outside of this case
+	rm.Analysis.addLike(alternation, node)
+	c.emitExecuteNode(rm, alternation, nil, true)
+	c.writeLine("")
+}
+
+// A node in the trie of an alternation's literal branches.  Children are kept
+// in the order of the branches so the generated code is stable.
+type alternationTrie struct {
+	chars    []rune
+	children []*alternationTrie
+	// the rest of the branch when only one branch goes through this node
+	remainder []rune
+	// the index of the branch that ends here, or -1
+	end int
+}
+
+func (t *alternationTrie) child(ch rune) *alternationTrie {
+	i := slices.Index(t.chars, ch)
+	if i < 0 {
+		return nil
+	}
+	return t.children[i]
+}
+
+// Builds a trie of the branches if every branch is a literal, at least two branches share a first
+// char (otherwise the plain switch handles it), and no branch is a prefix of another, so at most one
+// can match.  With allowPrefixes, for an atomic alternation, a branch can be a prefix of others; the
+// ones the first matching branch would always win over are left out.  Returns nil if any of that
+// doesn't hold.
+func buildAlternationTrie(node *syntax.RegexNode, allowPrefixes bool) *alternationTrie {
+	var branches [][]rune
+	for _, child := range node.Children {
+		switch child.T {
+		case syntax.NtOne:
+			branches = append(branches, []rune{child.Ch})
+		case syntax.NtMulti:
+			branches = append(branches, child.Str)
+		default:
+			return nil
+		}
+	}
+
+	sharedFirstChar := false
+	for i := range branches {
+		for j := range branches {
+			if i == j {
+				continue
+			}
+			if !allowPrefixes && len(branches[i]) <= len(branches[j]) && slices.Equal(branches[i], branches[j][:len(branches[i])]) {
+				return nil
+			}
+			if branches[i][0] == branches[j][0] {
+				sharedFirstChar = true
+			}
+		}
+	}
+	if !sharedFirstChar {
+		return nil
+	}
+
+	root := &alternationTrie{end: -1}
+	for i, b := range branches {
+		t := root
+		for _, ch := range b {
+			next := t.child(ch)
+			if next == nil {
+				next = &alternationTrie{end: -1}
+				t.chars = append(t.chars, ch)
+				t.children = append(t.children, next)
+			}
+			t = next
+		}
+		if t.end < 0 {
+			t.end = i
+		}
+	}
+	root.prune(len(branches))
+	root.compress()
+	return root
+}
+
+// Drops the branches that can't be the first to match: those after a branch that ends above them,
+// at a node they go through.  Returns false if no branch is left in t.
+func (t *alternationTrie) prune(bound int) bool {
+	if t.end > bound {
+		t.end = -1
+	}
+	if t.end >= 0 {
+		bound = t.end
+	}
+	for i := 0; i < len(t.children); {
+		if t.children[i].prune(bound) {
+			i++
+			continue
+		}
+		t.chars = slices.Delete(t.chars, i, i+1)
+		t.children = slices.Delete(t.children, i, i+1)
+	}
+	return t.end >= 0 || len(t.children) > 0
+}
+
+// Folds each path that only one branch goes through into the remainder of its first node.
+func (t *alternationTrie) compress() {
+	for _, child := range t.children {
+		n := child
+		var rest []rune
+		for n.end < 0 && len(n.children) == 1 {
+			rest = append(rest, n.chars[0])
+			n = n.children[0]
+		}
+		if len(n.children) == 0 {
+			child.chars, child.children, child.remainder, child.end = nil, nil, rest, n.end
+			continue
+		}
+		child.compress()
+	}
+}
+
+// Emits a nested switch for the trie, where depth chars have already been matched
+// starting at startingSliceStaticPos.  Every path out leaves sliceStaticPos at 0.
+func (c *converter) emitExecuteAlternationTrie(rm *regexpData, t *alternationTrie, startingSliceStaticPos, depth int) {
+	if len(t.chars) == 0 {
+		// the end of a branch, match whatever is left of it
+		rm.sliceStaticPos = startingSliceStaticPos + depth
+		switch len(t.remainder) {
+		case 0:
+		case 1:
+			c.emitExecuteNode(rm, &syntax.RegexNode{T: syntax.NtOne, Ch: t.remainder[0]}, nil, true)
+		default:
+			c.emitExecuteNode(rm, &syntax.RegexNode{T: syntax.NtMulti, Str: t.remainder}, nil, true)
+		}
+		c.transferSliceStaticPosToPos(rm, false)
+		c.debugAssertf(rm.sliceStaticPos == 0, "alternation trie branch at depth %v exits at sliceStaticPos %v", depth, rm.sliceStaticPos)
+		return
+	}
+
+	// A branch that ends here is what's left if the longer ones fail, so they fall back to it
+	// rather than failing the alternation.
+	originalDoneLabel := rm.doneLabel
+	var matchLabel string
+	if t.end >= 0 {
+		rm.doneLabel = rm.reserveName("AlternationTrieFallback")
+		matchLabel = rm.reserveName("AlternationTrieMatch")
+	}
+
+	rm.sliceStaticPos = startingSliceStaticPos + depth
+	c.emitSpanLengthCheck(rm, 1, nil)
+	c.writeLineFmt("switch %s[%v] {", rm.sliceSpan, rm.sliceStaticPos)
+	for i, ch := range t.chars {
+		c.writeLineFmt("case %q:", ch)
+		c.emitExecuteAlternationTrie(rm, t.children[i], startingSliceStaticPos, depth+1)
+		if t.end >= 0 {
+			c.emitExecuteGoto(rm, matchLabel)
+		}
+	}
+	c.emitCaseGoto(rm, "default:", rm.doneLabel)
+	c.writeLine("}")
+
+	if t.end >= 0 {
+		c.emitMarkLabel(rm, rm.doneLabel, false)
+		rm.doneLabel = originalDoneLabel
+		rm.sliceStaticPos = startingSliceStaticPos + depth
+		c.transferSliceStaticPosToPos(rm, false)
+		c.debugAssertf(rm.sliceStaticPos == 0, "alternation trie fallback at depth %v exits at sliceStaticPos %v", depth, rm.sliceStaticPos)
 		c.emitMarkLabel(rm, matchLabel, true)
 	}
 }
@@ -2410,15 +3340,15 @@ func (c *converter) emitBackrefWhenHasCapture(rm *regexpData, node *syntax.Regex
 	// Validate that the remaining length of the slice is sufficient
 	// to possibly match, and then do a SequenceEqual against the matched text.
 	if (node.Options & syntax.RightToLeft) == 0 {
-		c.writeLineFmt("if len(%s) < matchLength || !helpers.Equals%s(r.Runtext, r.MatchIndex(%v), matchLength, %[1]s[:matchLength]) {",
-			rm.sliceSpan, ignoreCase, capnum)
+		c.writeLineFmt("if len(%s) < matchLength || !%s(runtext, r.MatchIndex(%v), matchLength, %[1]s[:matchLength]) {",
+			rm.sliceSpan, c.helper("Equals"+ignoreCase), capnum)
 		c.emitExecuteGoto(rm, rm.doneLabel)
-		c.writeLine("}\npos += matchLength")
+		c.writeLineFmt("}\n%s += matchLength", rm.pos)
 	} else {
-		c.writeLineFmt("if pos < matchLength || !helpers.Equals%s(r.Runtext, r.MatchIndex(%v), matchLength, r.Runtext[pos-matchLength:pos]) {",
-			ignoreCase, capnum)
+		c.writeLineFmt("if %[3]s < matchLength || !%[1]s(runtext, r.MatchIndex(%[2]v), matchLength, runtext[%[3]s-matchLength:%[3]s]) {",
+			c.helper("Equals"+ignoreCase), capnum, rm.pos)
 		c.emitExecuteGoto(rm, rm.doneLabel)
-		c.writeLine("}\npos -= matchLength")
+		c.writeLineFmt("}\n%s -= matchLength", rm.pos)
 	}
 	c.sliceInputSpan(rm, false)
 }
@@ -2454,6 +3384,8 @@ func (c *converter) emitExecuteBackreferenceConditional(rm *regexpData, node *sy
 		if noBranch != nil {
 			c.writeLine("} else {")
 			c.writeLine("// Otherwise, match the second branch.")
+			// the yes branch's backtracking is inside the if block, a failure here can't jump to it
+			rm.doneLabel = originalDoneLabel
 			c.emitExecuteNode(rm, noBranch, nil, true)
 			c.writeLine("")
 			c.transferSliceStaticPosToPos(rm, false)
@@ -2473,11 +3405,11 @@ func (c *converter) emitExecuteBackreferenceConditional(rm *regexpData, node *sy
 	// followed in this resumeAt local.
 	resumeAt := rm.reserveName("conditionalbackreference_branch")
 	isInLoop := rm.Analysis.IsInLoop(node)
+	rm.addLocalDec(fmt.Sprint(resumeAt, " := 0"))
 	if isInLoop {
-		c.writeLineFmt("var %s int", resumeAt)
-	} else {
-		rm.addLocalDec(fmt.Sprint(resumeAt, " := 0"))
+		c.writeLineFmt("%s = 0", resumeAt)
 	}
+	c.writeLineFmt("_ = %s", resumeAt)
 
 	// While it would be nicely readable to use an if/else block, if the branches contain
 	// anything that triggers backtracking, labels will end up being defined, and if they're
@@ -2534,7 +3466,7 @@ func (c *converter) emitExecuteBackreferenceConditional(rm *regexpData, node *sy
 		c.writeLine("")
 
 		// Backtrack section
-		backtrack := rm.reserveName("ConditionalBackreferenceBacktrack")
+		backtrack := rm.reserveBacktrackLabel("ConditionalBackreferenceBacktrack")
 		rm.doneLabel = backtrack
 		c.emitMarkLabel(rm, backtrack, false)
 
@@ -2543,7 +3475,7 @@ func (c *converter) emitExecuteBackreferenceConditional(rm *regexpData, node *sy
 		// have been able to overwrite it in the interim, so we can just trust the value already in
 		// the local.
 		if isInLoop {
-			c.emitStackPop(stackCookie, resumeAt)
+			c.emitStackPop(rm, stackCookie, resumeAt)
 		}
 		c.writeLineFmt("switch %s {", resumeAt)
 		if postYesDoneLabel != originalDoneLabel {
@@ -2613,7 +3545,8 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 	var startingCapturePos string
 	if rm.Analysis.MayContainCapture(condition) {
 		startingCapturePos = rm.reserveName("conditionalexpression_starting_capturepos")
-		c.writeLineFmt("%v := r.Crawlpos()", startingCapturePos)
+		rm.addLocalDec(fmt.Sprint(startingCapturePos, " := 0"))
+		c.writeLineFmt("%v = r.Crawlpos()", startingCapturePos)
 	}
 
 	// Emit the condition expression.  Route any failures to after the yes branch.  This code is almost
@@ -2624,7 +3557,8 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 
 	// Save off pos.  We'll need to reset this upon successful completion of the lookaround.
 	startingPos := rm.reserveName("conditionalexpression_starting_pos")
-	c.writeLineFmt("%s := pos\n", startingPos)
+	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
+	c.writeLineFmt("%s = %s\n", startingPos, rm.pos)
 	startingSliceStaticPos := rm.sliceStaticPos
 
 	// Emit the condition. The condition expression is a zero-width assertion, which is atomic,
@@ -2643,7 +3577,7 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 	// After the condition completes successfully, reset the text positions.
 	// Do not reset captures, which persist beyond the lookaround.
 	c.writeLine("// Condition matched:")
-	c.writeLineFmt("pos = %s", startingPos)
+	c.writeLineFmt("%s = %s", rm.pos, startingPos)
 	c.sliceInputSpan(rm, false)
 	rm.sliceStaticPos = startingSliceStaticPos
 	c.writeLine("")
@@ -2663,7 +3597,7 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 	// _and_ reset captures, which should not persist when the whole expression failed.
 	c.writeLine("// Condition did not match:")
 	c.emitMarkLabel(rm, expressionNotMatched, false)
-	c.writeLineFmt("pos = %s", startingPos)
+	c.writeLineFmt("%s = %s", rm.pos, startingPos)
 	c.sliceInputSpan(rm, false)
 	rm.sliceStaticPos = startingSliceStaticPos
 	if len(startingCapturePos) > 0 {
@@ -2679,7 +3613,9 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 		c.writeLine("")
 		c.transferSliceStaticPosToPos(rm, false) // make sure sliceStaticPos is 0 after each branch
 		postNoDoneLabel = rm.doneLabel
-		if !isAtomic && postNoDoneLabel != originalDoneLabel {
+		// resumeAt is still 0 from the start, if only the yes branch backtracks this has to move
+		// it off case 0 so backtracking passes straight through
+		if !isAtomic && (postNoDoneLabel != originalDoneLabel || postYesDoneLabel != originalDoneLabel) {
 			c.writeLineFmt("%s = 1", resumeAt)
 		}
 	} else {
@@ -2701,7 +3637,7 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 		c.emitExecuteGoto(rm, endConditional)
 		c.writeLine("")
 
-		backtrack := rm.reserveName("ConditionalExpressionBacktrack")
+		backtrack := rm.reserveBacktrackLabel("ConditionalExpressionBacktrack")
 		rm.doneLabel = backtrack
 		c.emitMarkLabel(rm, backtrack, false)
 
@@ -2710,7 +3646,7 @@ func (c *converter) emitExecuteExpressionConditional(rm *regexpData, node *synta
 		if isInLoop {
 			// If we're not in a loop, the local will maintain its value until backtracking occurs.
 			// If we are in a loop, multiple iterations need their own value, so we need to use the stack.
-			c.emitStackPop(stackCookie, resumeAt)
+			c.emitStackPop(rm, stackCookie, resumeAt)
 		}
 
 		c.writeLineFmt("switch %s {", resumeAt)
@@ -2751,13 +3687,14 @@ func (c *converter) emitExecuteCapture(rm *regexpData, node *syntax.RegexNode, s
 	startingPos := rm.reserveName("capture_starting_pos")
 	// in go we have to declare vars at the top so we can use Goto freely
 	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
-	c.writeLineFmt("%s = pos", startingPos)
+	c.writeLineFmt("%s = %s", startingPos, rm.pos)
 	c.writeLine("")
 
 	child := node.Children[0]
 
 	if uncapnum != -1 {
-		c.writeLineFmt("if r.IsMatched(%v) {", uncapnum)
+		// a balancing group fails if there's nothing to balance against
+		c.writeLineFmt("if !r.IsMatched(%v) {", uncapnum)
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("}\n")
 	}
@@ -2770,10 +3707,15 @@ func (c *converter) emitExecuteCapture(rm *regexpData, node *syntax.RegexNode, s
 	c.writeLine("")
 	c.transferSliceStaticPosToPos(rm, false)
 	if uncapnum == -1 {
-		//TODO: showing 0 instead of 1?
-		c.writeLineFmt("r.Capture(%v, %s, pos)", capnum, startingPos)
+		// capnum is the slot, which only differs from the group number when numbers are sparse, e.g. (?<10>a)
+		c.writeLineFmt("r.Capture(%v, %s, %s)", capnum, startingPos, rm.pos)
+		c.emitExplainTrace(rm, "group %d = [%d, %d)", strconv.Itoa(capnum), startingPos, rm.pos)
 	} else {
-		c.writeLineFmt("r.TransferCapture(%v, %v, %s, pos)", capnum, uncapnum, startingPos)
+		// balancing group, capnum is -1 for a pure uncapture like (?<-open>). This needs regexp2 to
+		// export the transfer, until then supportsCodeGen keeps these patterns from getting here.
+		// The runner crawls both groups for a transfer, so in a loop backtracking out of an iteration
+		// undoes it with r.UncaptureUntil like any capture; only startingPos needs the stack below.
+		c.writeLineFmt("r.TransferCapture(%v, %v, %s, %s)", capnum, uncapnum, startingPos, rm.pos)
 	}
 
 	if isAtomic || !childBacktracks {
@@ -2803,10 +3745,10 @@ func (c *converter) emitExecuteCapture(rm *regexpData, node *syntax.RegexNode, s
 		c.writeLine("")
 
 		// Emit a backtracking section that restores the capture's state and then jumps to the previous done label
-		backtrack := rm.reserveName("CaptureBacktrack")
+		backtrack := rm.reserveBacktrackLabel("CaptureBacktrack")
 		c.emitMarkLabel(rm, backtrack, false)
 		if isInLoop {
-			c.emitStackPop(stackCookie, startingPos)
+			c.emitStackPop(rm, stackCookie, startingPos)
 		}
 		c.emitExecuteGoto(rm, rm.doneLabel)
 		c.writeLine("")
@@ -2837,7 +3779,7 @@ func (c *converter) emitExecutePositiveLookaroundAssertion(rm *regexpData, node
 	}
 	startingPos := rm.reserveName(name)
 	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
-	c.writeLineFmt("%s = pos\n", startingPos)
+	c.writeLineFmt("%s = %s\n", startingPos, rm.pos)
 
 	startingSliceStaticPos := rm.sliceStaticPos
 
@@ -2856,7 +3798,7 @@ func (c *converter) emitExecutePositiveLookaroundAssertion(rm *regexpData, node
 
 	// After the child completes successfully, reset the text positions.
 	// Do not reset captures, which persist beyond the lookaround.
-	c.writeLineFmt("\npos = %s", startingPos)
+	c.writeLineFmt("\n%s = %s", rm.pos, startingPos)
 	c.sliceInputSpan(rm, false)
 
 	rm.sliceStaticPos = startingSliceStaticPos
@@ -2884,7 +3826,7 @@ func (c *converter) emitExecuteNegativeLookaroundAssertion(rm *regexpData, node
 	}
 	startingPos := rm.reserveName(fmt.Sprint(variablePrefix, "starting_pos"))
 	rm.addLocalDec(fmt.Sprint(startingPos, " := 0"))
-	c.writeLineFmt("%s = pos\n", startingPos)
+	c.writeLineFmt("%s = %s\n", startingPos, rm.pos)
 	startingSliceStaticPos := rm.sliceStaticPos
 
 	negativeLookaroundDoneLabel := rm.reserveName("NegativeLookaroundMatch")
@@ -2929,7 +3871,7 @@ func (c *converter) emitExecuteNegativeLookaroundAssertion(rm *regexpData, node
 		// Pop the crawl position from the stack.
 		//TODO: our stack is backwards
 		c.writeLine("r.Runstackpos++")
-		c.emitStackCookieValidate(stackCookie)
+		c.emitStackCookieValidate(rm, stackCookie)
 	}
 	c.emitExecuteGoto(rm, originalDoneLabel)
 	c.writeLine("")
@@ -2938,7 +3880,7 @@ func (c *converter) emitExecuteNegativeLookaroundAssertion(rm *regexpData, node
 	c.emitMarkLabel(rm, negativeLookaroundDoneLabel, false)
 
 	// After the child completes in failure (success for negative lookaround), reset the text positions.
-	c.writeLineFmt("pos = %s", startingPos)
+	c.writeLineFmt("%s = %s", rm.pos, startingPos)
 	c.sliceInputSpan(rm, false)
 	rm.sliceStaticPos = startingSliceStaticPos
 
@@ -2946,7 +3888,7 @@ func (c *converter) emitExecuteNegativeLookaroundAssertion(rm *regexpData, node
 	if hasCaptures {
 		if isInLoop {
 			c.emitUncaptureUntil("r.StackPop()")
-			c.emitStackCookieValidate(stackCookie)
+			c.emitStackCookieValidate(rm, stackCookie)
 		} else {
 			c.emitUncaptureUntil(capturePos)
 		}
@@ -2965,11 +3907,17 @@ func gotoWillExitMatch(rm *regexpData, label string) bool {
 func (c *converter) emitExecuteGoto(rm *regexpData, label string) {
 	if gotoWillExitMatch(rm, label) {
 		// We only get here in the code if the whole expression fails to match and jumps to
-		// the original value of doneLabel.
-		if rm.expressionHasCaptures {
+		// the original value of doneLabel. Until a node that may capture or a label is emitted,
+		// the code is only reached straight from the start of Execute, so there's nothing to uncapture.
+		if rm.expressionHasCaptures && rm.mayHaveCaptured {
 			c.emitUncaptureUntil("0")
 		}
+		c.emitExplainTrace(rm, "no match")
 		c.writeLine("return nil // The input didn't match.")
+	} else if i := slices.Index(rm.dispatchLabels, label); i >= 0 {
+		rm.usedLabels = append(rm.usedLabels, rm.dispatchLabel)
+		c.writeLineFmt("%s = %v", rm.dispatchLocal, i)
+		c.writeLineFmt("goto %s", rm.dispatchLabel)
 	} else {
 		rm.usedLabels = append(rm.usedLabels, label)
 		c.writeLineFmt("goto %s", label)
@@ -2986,94 +3934,90 @@ func (c *converter) emitUncaptureUntil(capturepos string) {
 	c.writeLineFmt("r.UncaptureUntil(%s)", capturepos)
 }
 
-func (c *converter) emitStackPop(stackCookie int, args ...string) {
+// Pops the args in order, so callers list them in the reverse of the order they were pushed in,
+// e.g. emitStackPop(c, b, a) restores emitStackPush(a, b, c). The Runner's stack isn't exported so
+// there's no batched pop, each value comes back through StackPop, which the compiler inlines.
+// A stack cookie is popped and checked after the args, since emitStackPush pushes it under them.
+func (c *converter) emitStackPop(rm *regexpData, stackCookie int, args ...string) {
 	for _, arg := range args {
 		c.writeLineFmt("%v = r.StackPop()", arg)
 	}
+	if stackCookie != 0 {
+		c.emitStackCookieValidate(rm, stackCookie)
+	}
 }
 
+// Emits a deferred recover for Options.RecoverPanics that runs onPanic, with the recovered
+// value in p, to set the method's named result.
+func (c *converter) emitRecoverPanic(onPanic string) {
+	c.writeLineFmt(`defer func() {
+		if p := recover(); p != nil {
+			%s
+		}
+	}()`, onPanic)
+}
+
+// the first stack cookie, high enough that a cookie is unlikely to match the pos or count that an
+// imbalanced pop gets instead, see createStackCookie
+const stackCookieBase = 0x3C000000
+
+// Returns the cookie for a construct to push under the state it saves on the backtracking stack
+// with Options.StackCookies, or 0 for no cookie. Cookies are numbered rather than random, so the
+// same pattern always generates the same code, and spaced out so an alternation can add its branch
+// number to its cookie without reaching the next one.
 func (c *converter) createStackCookie() int {
-	//TODO: this is a debug function should consider setting it up
-	return 0
-}
-
-func (c *converter) emitStackCookieValidate(stackCookie int) {
-	//TODO: this
-}
-
-// / <summary>
-// / Returns an expression that:
-// / In debug, pops item 1 from the backtracking stack, pops item 2 and validates it against the cookie, then evaluates to item1.
-// / In release, pops and evaluates to an item from the backtracking stack.
-// / </summary>
-func (c *converter) validateStackCookieWithAdditionAndReturnPoppedStack(stackCookie int) string {
-	// non-debug behavior
-	return "r.StackPop()"
-
-	/*#if DEBUG
-	                const string MethodName = "ValidateStackCookieWithAdditionAndReturnPoppedStack";
-	                if (!requiredHelpers.ContainsKey(MethodName))
-	                {
-	                    requiredHelpers.Add(MethodName,
-	                    [
-	                        $"/// <summary>Validates that a stack cookie popped off the backtracking stack holds the expected value. Debug only.</summary>",
-	                        $"internal static int {MethodName}(int poppedStack, int expectedCookie, int actualCookie)",
-	                        $"{{",
-	                        $"    expectedCookie += poppedStack;",
-	                        $"    if (expectedCookie != actualCookie)",
-	                        $"    {{",
-	                        $"          throw new Exception($\"Backtracking stack imbalance detected. Expected {{expectedCookie}}. Actual {{actualCookie}}.\");",
-	                        $"    }}",
-	                        $"    return poppedStack;",
-	                        $"}}",
-	                    ]);
-	                }
-
-	                return $"{HelpersTypeName}.{MethodName}({StackPop()}, {stackCookie}, {StackPop()})";
-	#else*/
-
-}
-
-/*
-#if DEBUG
-            /// <summary>Returns an expression that validates and returns a debug stack cookie.</summary>
-            string StackCookieValidate(int stackCookie)
-            {
-                const string MethodName = "ValidateStackCookie";
-                if (!requiredHelpers.ContainsKey(MethodName))
-                {
-                    requiredHelpers.Add(MethodName,
-                    [
-                        $"/// <summary>Validates that a stack cookie popped off the backtracking stack holds the expected value. Debug only.</summary>",
-                        $"internal static int {MethodName}(int expected, int actual)",
-                        $"{{",
-                        $"    if (expected != actual)",
-                        $"    {{",
-                        $"        throw new Exception($\"Backtracking stack imbalance detected. Expected {{expected}}. Actual {{actual}}.\");",
-                        $"    }}",
-                        $"    return actual;",
-                        $"}}",
-                    ]);
-                }
-
-                return $"{HelpersTypeName}.{MethodName}({stackCookie}, {StackPop()})";
-            }
-#endif
-*/
+	if !c.opts.StackCookies {
+		return 0
+	}
+	c.stackCookies++
+	return stackCookieBase + c.stackCookies<<16
+}
+
+// Pops a stack cookie and returns an error from Execute if it isn't the one that was pushed. This is
+// an error rather than a panic, so Options.RecoverPanics engines stay panic free.
+func (c *converter) emitStackCookieValidate(rm *regexpData, stackCookie int) {
+	if stackCookie == 0 {
+		return
+	}
+	c.writeLineFmt(`if cookie := r.StackPop(); cookie != %#x {
+		return fmt.Errorf("%s: backtracking stack imbalance detected, expected %#[1]x, got %%#x", cookie)
+	}`, stackCookie, rm.GeneratedName)
+}
 
+// Returns an expression for the item on top of the backtracking stack, e.g. the branch an alternation
+// took. With a stack cookie, the item is popped into a local first, then the cookie under it is
+// popped and checked against stackCookie plus the item, the way the item was pushed.
+func (c *converter) validateStackCookieWithAdditionAndReturnPoppedStack(rm *regexpData, stackCookie int) string {
+	if stackCookie == 0 {
+		return "r.StackPop()"
+	}
+
+	popped := rm.reserveName("stack_popped")
+	rm.addLocalDec(fmt.Sprint(popped, " := 0"))
+	c.writeLineFmt("%s = r.StackPop()", popped)
+	c.writeLineFmt(`if cookie := r.StackPop(); cookie != %#x+%s {
+		return fmt.Errorf("%s: backtracking stack imbalance detected, expected %%#x, got %%#x", %#[1]x+%[2]s, cookie)
+	}`, stackCookie, popped, rm.GeneratedName)
+	return popped
+}
+
+// Pushes all the args with one call. Every emit point pushes its state together, so there's never
+// a run of pushes to coalesce. StackPush3 is over the compiler's inlining budget while StackPushN
+// isn't, so three or more args use StackPushN to keep the push inline. A stack cookie goes in the
+// same call, first, so it's under the args.
 func (c *converter) emitStackPush(stackCookie int, args ...string) {
+	c.stackPushes++
+	if stackCookie != 0 {
+		args = append([]string{fmt.Sprintf("%#x", stackCookie)}, args...)
+	}
 	switch len(args) {
 	case 1:
 		c.writeLineFmt("r.StackPush(%s)", args[0])
 	case 2:
 		c.writeLineFmt("r.StackPush2(%s, %s)", args[0], args[1])
-	case 3:
-		c.writeLineFmt("r.StackPush3(%s, %s, %s)", args[0], args[1], args[2])
 	default:
 		c.writeLineFmt("r.StackPushN(%s)", strings.Join(args, ", "))
 	}
-
-	//TODO: stack cookie debugging
 }
 
 // Emits the sum of a constant and a value from a local.
@@ -3094,6 +4038,10 @@ func (c *converter) emitSpanLengthCheck(rm *regexpData, requiredLength int, dyna
 	c.writeLine("}")
 }
 
+// There's no local caching the slice's length: len(slice) is already just a load of the slice
+// header, and the compiler only proves indexes in bounds from checks against len(slice) itself, so
+// a cached copy would have to be kept in step with every place slice is narrowed, not only
+// sliceInputSpan, for no gain.
 func spanLengthCheck(rm *regexpData, requiredLength int, dynamicRequiredLength *string) string {
 	if dynamicRequiredLength == nil && rm.sliceStaticPos+requiredLength == 1 {
 		return fmt.Sprintf("len(%v) == 0", rm.sliceSpan)
@@ -3101,15 +4049,22 @@ func spanLengthCheck(rm *regexpData, requiredLength int, dynamicRequiredLength *
 	return fmt.Sprintf("len(%v) < %s", rm.sliceSpan, sum(rm.sliceStaticPos+requiredLength, dynamicRequiredLength))
 }
 
+// Checks the converter's own bookkeeping while emitting, see Options.DebugAssertions
+func (c *converter) debugAssertf(cond bool, format string, args ...any) {
+	if c.opts.DebugAssertions && !cond {
+		panic("regexp2cg: " + fmt.Sprintf(format, args...))
+	}
+}
+
 // Adds the value of sliceStaticPos into the pos local, slices slice by the corresponding amount,
-// and zeros out sliceStaticPos.
-// forceSliceReload = false
+// and zeros out sliceStaticPos.  With forceSliceReload, slice is reloaded even if sliceStaticPos
+// is already 0, unless nothing emitted since the last reload could have left it stale (rm.sliceDirty).
 func (c *converter) transferSliceStaticPosToPos(rm *regexpData, forceSliceReload bool) {
 	if rm.sliceStaticPos > 0 {
-		c.emitAddStmt("pos", rm.sliceStaticPos)
+		c.emitAddStmt(rm.pos, rm.sliceStaticPos)
 		rm.sliceStaticPos = 0
 		c.sliceInputSpan(rm, false)
-	} else if forceSliceReload {
+	} else if forceSliceReload && rm.sliceDirty {
 		c.sliceInputSpan(rm, false)
 	}
 }
@@ -3134,7 +4089,8 @@ func (c *converter) sliceInputSpan(rm *regexpData, declare bool) {
 	if declare {
 		c.write("var ")
 	}
-	c.writeLineFmt("%s = r.Runtext[pos:]", rm.sliceSpan)
+	c.writeLineFmt("%s = runtext[%s:]", rm.sliceSpan, rm.pos)
+	rm.sliceDirty = false
 }
 
 func (c *converter) emitTimeoutCheck() {
@@ -3143,6 +4099,25 @@ func (c *converter) emitTimeoutCheck() {
 	}`)
 }
 
+// Returns how deep the node is in the tree and the child indexes that lead to it from the root,
+// e.g. "0.1.0" is the first child of the second child of the root's only child. These line up
+// with the tree dump above each engine. Nodes made up while emitting aren't in the tree.
+func nodePath(rm *regexpData, node *syntax.RegexNode) (int, string, bool) {
+	var indexes []string
+	for ; node.Parent != nil; node = node.Parent {
+		i := slices.Index(node.Parent.Children, node)
+		if i < 0 {
+			return 0, "", false
+		}
+		indexes = append(indexes, strconv.Itoa(i))
+	}
+	if node != rm.Tree.Root {
+		return 0, "", false
+	}
+	slices.Reverse(indexes)
+	return len(indexes), strings.Join(indexes, "."), true
+}
+
 // / <summary>Gets a textual description of the node fit for rendering in a comment in source.</summary>
 func describeNode(rm *regexpData, node *syntax.RegexNode) string {
 	rtl := node.Options&syntax.RightToLeft != 0
@@ -3247,7 +4222,7 @@ func describeCapture(rm *regexpData, capNum int) string {
 	name := groupNameFromNumber(rm.Tree, capNum)
 
 	id, err := strconv.Atoi(name)
-	if err == nil || id != capNum {
+	if name != "" && (err != nil || id != capNum) {
 		return fmt.Sprintf("%#v capture group", name)
 	}
 	// Otherwise, create a numerical description of the capture group.
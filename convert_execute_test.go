@@ -0,0 +1,1367 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/dlclark/regexp2/helpers"
+	"github.com/dlclark/regexp2/syntax"
+)
+
+// generates the code for a pattern without compiling it
+func generateCode(t testing.TB, pattern string, opts syntax.RegexOptions) string {
+	return generateCodeWithOptions(t, pattern, opts, Options{})
+}
+
+func generateCodeWithOptions(t testing.TB, pattern string, opts syntax.RegexOptions, genOpts Options) string {
+	// every pattern the tests convert also checks the converter's bookkeeping
+	genOpts.DebugAssertions = true
+	out := &bytes.Buffer{}
+	c, err := newConverter(out, "main", genOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addRegexp("MyFile.go:120:10", "MyPattern", pattern, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addFooter(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestSliceReload_NestedLoop(t *testing.T) {
+	code := generateCode(t, `(?<=(?:a+b)+)(?:(c+)d*)+e`, 0)
+	// each loop iteration reloaded the slice, 6 times in all, before redundant reloads were skipped
+	if n := strings.Count(code, "= runtext[pos:]"); n == 0 || n >= 6 {
+		t.Errorf("expected fewer than 6 slice reloads, got %v in:\n%s", n, code)
+	}
+
+	// the skipped reloads don't change what matches
+	pattern := `(?<=(?:a+b)+)(?:(c+)d*)+e`
+	exec := generateAndCompile(t, pattern, 0)
+	for _, input := range []string{"abce", "aabccdcce", "abcdde", "ce", "abxce", "ababcdcdccdde", "abcdd"} {
+		runMatchLikeInterpreter(t, pattern, 0, exec, input)
+	}
+}
+
+func BenchmarkSliceReload_NestedLoop(b *testing.B) {
+	pattern := `(?<=(?:a+b)+)(?:(c+)d*)+e`
+	var code string
+	for i := 0; i < b.N; i++ {
+		code = generateCode(b, pattern, 0)
+	}
+	b.ReportMetric(float64(strings.Count(code, "= runtext[pos:]")), "reslices")
+}
+
+var boundsCheckRegex = regexp.MustCompile(`(?m)^.*gen\.go:(\d+):\d+: Found IsInBounds$`)
+
+// builds the generated code with -d=ssa/check_bce and returns how many of the indexes in Execute
+// the compiler couldn't prove are in bounds
+func executeBoundsChecks(t testing.TB, pattern string) int {
+	code := generateCode(t, pattern, 0) + "\nfunc main() {}\n"
+	file := filepath.Join(t.TempDir(), "gen.go")
+	if err := os.WriteFile(file, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("go", "build", "-gcflags=-d=ssa/check_bce", "-o", os.DevNull, file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("build error for pattern %v: %s", pattern, out)
+	}
+
+	// Execute runs from its signature to the closing brace at the start of a line
+	lines := strings.Split(code, "\n")
+	start, end := 0, 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "func (MyPattern_Engine) Execute(") {
+			start = i + 1
+		} else if start > 0 && line == "}" {
+			end = i + 1
+			break
+		}
+	}
+
+	count := 0
+	for _, m := range boundsCheckRegex.FindAllStringSubmatch(string(out), -1) {
+		if line, _ := strconv.Atoi(m[1]); line >= start && line <= end {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBoundsChecks_Execute(t *testing.T) {
+	// fixed runs of chars, a long repeater and a bounded atomic loop shouldn't need any
+	for _, pattern := range []string{`\w{5}x`, `abc\d\dx[a-c]`, `[a-f\d]{20}x`, `(?>[\w-]{2,9})!`, `a[\w-]{3,}b`} {
+		if count := executeBoundsChecks(t, pattern); count != 0 {
+			t.Errorf("expected no bounds checks in Execute for %v, got %v", pattern, count)
+		}
+	}
+}
+
+func TestSetRepeater_Loop(t *testing.T) {
+	// more than defaultMaxUnrollSize chars of a set that can't be searched for are checked in a loop
+	pattern := `[a-f\d]{20}x`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "-0123456789abcdef0123x", " 0: 0123456789abcdef0123x")
+	runNoMatch(t, pattern, exec, "0123456789abcdef012x")
+	runNoMatch(t, pattern, exec, "0123456789abcdeg0123x")
+}
+
+func BenchmarkBoundsChecks_Execute(b *testing.B) {
+	pattern := `\w{5}(?>[\w-]{2,9})-\d{4}`
+	var count int
+	for i := 0; i < b.N; i++ {
+		count = executeBoundsChecks(b, pattern)
+	}
+	b.ReportMetric(float64(count), "boundschecks")
+}
+
+var stackPushRegex = regexp.MustCompile(`(?m)^\s*r\.StackPush\w*\(.*\)\n\s*r\.StackPush`)
+
+func TestStackPush_OneCallPerEmit(t *testing.T) {
+	// each emit point pushes its state with a single call, and three values use the inlined StackPushN
+	for _, pattern := range []string{`(?:(?:([a-z])+?,)*;)*!`, `(a|b)*?c(d+)`, `(?:(\w)|-)+?x\1`, `((?:ab)*)*c`} {
+		code := generateCode(t, pattern, 0)
+		if stackPushRegex.MatchString(code) {
+			t.Errorf("expected no consecutive stack pushes for %v", pattern)
+		}
+		if strings.Contains(code, "r.StackPush3(") {
+			t.Errorf("expected StackPushN instead of StackPush3 for %v", pattern)
+		}
+	}
+}
+
+func TestStackPop_ReverseOrder(t *testing.T) {
+	// a loop in a loop with a minimum count and possibly empty iterations pushes its starting
+	// pos, starting stack pos and iteration count, and has to pop them back in the reverse order
+	pattern := `(?:(?:a|ab|){2,}c)+d`
+	code := generateCode(t, pattern, 0)
+	push := "r.StackPushN(loop_starting_pos, startingStackpos, loop_iteration1)"
+	pop := "loop_iteration1 = r.StackPop()\n\tstartingStackpos = r.StackPop()\n\tloop_starting_pos = r.StackPop()"
+	if !strings.Contains(code, push) || !strings.Contains(code, pop) {
+		t.Errorf("expected the loop state to be popped in the reverse of the order it's pushed")
+	}
+
+	inputs := []string{"abcd", "aabcabcd", "abacd", "acabcabd", "xabcxabcd", "ccd", "abcabcabd", "aaacacabacd"}
+	for _, pattern := range []string{pattern, `(?:x(?:a?b?|ab){2,5}c)*d`, `((?:(a|ab|)){2,}c)*d`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func BenchmarkStackPush_NestedLoop(b *testing.B) {
+	// nested loops that push the position, iteration count and crawl position on every iteration
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `(?:(?:([a-z])+?,)*;)*!`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, strings.Repeat("ab,cd,ef;", 30))
+}
+
+func TestSingleCharLoopBacktrack_LastIndexOfSet(t *testing.T) {
+	// the backtracking loop searches backwards for the start of the next node,
+	// these need to find the last occurrence, not the first
+	pattern := `.*[xy]z`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "axzyz", " 0: axzyz")
+	runNoMatch(t, pattern, exec, "axayaz")
+
+	pattern = `.*[xyw]z`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "axzwz", " 0: axzwz")
+
+	pattern = `\w*[^ab]b`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xbcbab", " 0: xbcb")
+
+	pattern = `.*[^a-f]z`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xzgzaz", " 0: xzgz")
+}
+
+var indexOfAnyBenchInput = []rune(strings.Repeat("the quick brown fox jumps over the lazy dog ", 100) + "!")
+var indexOfAnyExceptBenchInput = []rune(strings.Repeat("a e ", 1000) + "!")
+
+func BenchmarkIndexOfAny2(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAny2(indexOfAnyBenchInput, '!', '?')
+	}
+}
+
+func BenchmarkIndexOfAny2_Chars(b *testing.B) {
+	find := []rune("!?")
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAny(indexOfAnyBenchInput, find)
+	}
+}
+
+func BenchmarkIndexOfAny3(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAny3(indexOfAnyBenchInput, '!', '?', '#')
+	}
+}
+
+func BenchmarkIndexOfAny3_Chars(b *testing.B) {
+	find := []rune("!?#")
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAny(indexOfAnyBenchInput, find)
+	}
+}
+
+func BenchmarkIndexOfAnyExcept3(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAnyExcept3(indexOfAnyExceptBenchInput, ' ', 'a', 'e')
+	}
+}
+
+func BenchmarkIndexOfAnyExcept3_Chars(b *testing.B) {
+	bad := []rune(" ae")
+	for i := 0; i < b.N; i++ {
+		helpers.IndexOfAnyExcept(indexOfAnyExceptBenchInput, bad)
+	}
+}
+
+func TestSetLoop_TwoRanges(t *testing.T) {
+	pattern := `[A-Za-z]+\d`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "indexOfAnyExceptInRanges(slice, 'A', 'Z', 'a', 'z')") {
+		t.Errorf("expected the loop to search outside of the two ranges")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "@[`{AZaz1", " 0: AZaz1")
+	runMatch(t, pattern, exec, "abé1 xY2", " 0: xY2")
+	runNoMatch(t, pattern, exec, "ab@1 [2 `3 {4")
+
+	// backtracking searches for the last char in the ranges
+	pattern = `.*[A-Ea-e]x`
+	code = generateCode(t, pattern, 0)
+	if !strings.Contains(code, "lastIndexOfAnyInRanges(") {
+		t.Errorf("expected the backtracking to search for the last char in the two ranges")
+	}
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "axbxfxFx", " 0: axbx")
+	runNoMatch(t, pattern, exec, "fx@xFx`x")
+
+	pattern = `[^0-9a-f]+1`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "a1gz1", " 0: gz1")
+	runNoMatch(t, pattern, exec, "0g91f1")
+}
+
+func BenchmarkSetLoop_TwoRanges(b *testing.B) {
+	input := strings.Repeat("TheQuickBrownFoxJumpsOverTheLazyDog", 1000) + " "
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `[A-Za-z]+`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, input)
+}
+
+func TestAtomicLoop_NegatedSetIndexOf(t *testing.T) {
+	// loops over negated sets search for the chars the set excludes, [^"] is a Notone loop
+	for pattern, want := range map[string]string{
+		`"[^"]*"`:     `iteration = helpers.IndexOfAny1(slice, '"')`,
+		`"[^"\\]*"`:   `iteration = helpers.IndexOfAny2(slice, '"', '\\')`,
+		`"[^"\\\n]*"`: `iteration = helpers.IndexOfAny3(slice, '\n', '"', '\\')`,
+	} {
+		code := generateCode(t, pattern, 0)
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %v for %v in:\n%s", want, pattern, code)
+		}
+		exec := generateAndCompile(t, pattern, 0)
+		runMatch(t, pattern, exec, `a "" b`, ` 0: ""`)
+		runMatch(t, pattern, exec, `a "bc" "d"`, ` 0: "bc"`)
+		runNoMatch(t, pattern, exec, `a "bc`)
+	}
+}
+
+func BenchmarkAtomicLoop_QuotedStrings(b *testing.B) {
+	// each run finds one match, so the strings are long enough for the search to dominate
+	quoted := `"` + strings.Repeat("the quick brown fox jumps over the lazy dog ", 100) + `", `
+	input := strings.Repeat(quoted, 10)
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `"[^"]*"`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, input)
+}
+
+func TestAtomicLoop_InAlternation(t *testing.T) {
+	// failing after an atomic loop that ends a branch backtracks to the next branch, not into the loop
+	pattern := `(?:(?>(?:ab|a)+)|a)b`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aab", " 0: ab")
+	runMatch(t, pattern, exec, "abab", " 0: ab")
+	runNoMatch(t, pattern, exec, "aaa")
+
+	patterns := []string{`(?>a+)|b`, `(?:(?>a+)|b)c`, pattern, `(?:x(?>(a|ab)+)|x(?>a+)b|xa)bc`,
+		`((?>(?:a|ab){1,3})|a)b`, `(?:(?>(?:a|ab)*)b|a)+c`, `(?:(?>a{2,})|aa|a)+?b`, `((?>a+)|(a))+?\2b`}
+	inputs := []string{"b", "ac", "bc", "aab", "abb", "xabbc", "xaabc", "xabc", "abababc", "aabc", "ababac", "aaab", "aabb"}
+	for _, opts := range []syntax.RegexOptions{0, syntax.RightToLeft} {
+		for _, pattern := range patterns {
+			exec := generateAndCompile(t, pattern, opts)
+			for _, input := range inputs {
+				runMatchLikeInterpreter(t, pattern, opts, exec, input)
+			}
+		}
+	}
+}
+
+func TestLoopWithInnerCapture_Backtrack(t *testing.T) {
+	pattern := `((\d)x)+y`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "1x2xy", " 0: 1x2xy")
+	runMatch(t, pattern, exec, "1x2xy", " 1: 2x")
+	runMatch(t, pattern, exec, "1x2xy", " 2: 2")
+	runNoMatch(t, pattern, exec, "1x2xz")
+
+	// giving back an iteration restores pos, the slice has to follow it
+	pattern = `((\d)x)+2xy`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "1x2xy", " 0: 1x2xy")
+	runMatch(t, pattern, exec, "1x2xy", " 1: 1x")
+	runMatch(t, pattern, exec, "1x2xy", " 2: 1")
+	runNoMatch(t, pattern, exec, "3x2xz")
+
+	// the capture's child backtracks, so the capture pops its starting position too
+	pattern = `((\d+)x?)+2xy`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "1x12xy", " 0: 1x12xy")
+	runMatch(t, pattern, exec, "1x12xy", " 1: 1")
+	runMatch(t, pattern, exec, "112xy", " 2: 11")
+	runNoMatch(t, pattern, exec, "13xz")
+}
+
+func TestSingleCharLoop_MinMaxBacktrack(t *testing.T) {
+	pattern := `a{2,3}b`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aab", " 0: aab")
+	runMatch(t, pattern, exec, "aaab", " 0: aaab")
+	runMatch(t, pattern, exec, "aaaab", " 0: aaab")
+	runNoMatch(t, pattern, exec, "ab")
+
+	// the parser folds the trailing a into the loop
+	pattern = `a{2,3}ab`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aaab", " 0: aaab")
+	runMatch(t, pattern, exec, "aaaab", " 0: aaaab")
+	runNoMatch(t, pattern, exec, "aab")
+
+	// the set can force giving back an iteration, but never below the minimum
+	pattern = `a{2,3}[ab]c`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aaac", " 0: aaac")
+	runMatch(t, pattern, exec, "aabc", " 0: aabc")
+	runMatch(t, pattern, exec, "aaaac", " 0: aaaac")
+	runNoMatch(t, pattern, exec, "aac")
+	runNoMatch(t, pattern, exec, "abc")
+}
+
+func TestEngineOptions(t *testing.T) {
+	pattern := `^abc$`
+	opts := syntax.IgnoreCase | syntax.Multiline
+	exec := generateAndCompileMain(t, "_runenginemain.go", pattern, opts, Options{})
+	runMatch(t, pattern, exec, "", fmt.Sprintf("Options: %d", opts))
+
+	exec = generateAndCompileMain(t, "_runenginemain.go", pattern, 0, Options{})
+	runMatch(t, pattern, exec, "", "Options: 0")
+}
+
+func TestEnginePattern(t *testing.T) {
+	// the pattern comes back as written, whatever chars it has
+	pattern := "a\"b`c\\d\n\u00e9\x00"
+	exec := generateAndCompileMain(t, "_runenginemain.go", pattern, 0, Options{})
+	runMatch(t, pattern, exec, "", fmt.Sprintf("Pattern: %q", pattern))
+}
+
+func TestAlternationTrie(t *testing.T) {
+	pattern := `(?:foo|bar|fob|baz|fa)!`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "switch slice[2]") {
+		t.Errorf("expected a nested switch for the shared prefixes of %v", pattern)
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "fob!", " 0: fob!")
+	runMatch(t, pattern, exec, "fa!", " 0: fa!")
+	runMatch(t, pattern, exec, "xbaz!", " 0: baz!")
+	runNoMatch(t, pattern, exec, "fo!")
+	runNoMatch(t, pattern, exec, "bax!")
+
+	pattern = `(?:abc|xyz|abd)q`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abdq", " 0: abdq")
+	runMatch(t, pattern, exec, "ababcq", " 0: abcq")
+	runNoMatch(t, pattern, exec, "abq")
+
+	// the Compiled find optimizations used to leak xyw's and xyz's last chars into ab's set
+	pattern = `abc|abd|xyz|xyw`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xyw", " 0: xyw")
+	runNoMatch(t, pattern, exec, "abz")
+	runNoMatch(t, pattern, exec, "xyc")
+
+	// a branch that's a prefix of another can't use the trie
+	pattern = `(?:ab|abc|x)d`
+	if code := generateCode(t, pattern, 0); strings.Contains(code, "switch slice[1]") {
+		t.Errorf("unexpected trie for %v", pattern)
+	}
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abcd", " 0: abcd")
+	runMatch(t, pattern, exec, "abd", " 0: abd")
+
+	// unless the alternation is atomic, then the longer branches fall back to the shorter one
+	pattern = `foo|foobar|food`
+	genOpts := Options{LongestFirstAlternation: true}
+	if code := generateCodeWithOptions(t, pattern, 0, genOpts); !strings.Contains(code, "switch slice[3]") || !strings.Contains(code, "AlternationTrieFallback:") {
+		t.Errorf("expected a trie falling back to foo for %v", pattern)
+	}
+	exec = generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "xfoobar", " 0: foobar")
+	runMatch(t, pattern, exec, "food", " 0: food")
+	runMatch(t, pattern, exec, "foobaz", " 0: foo")
+	runMatch(t, pattern, exec, "foo", " 0: foo")
+	runNoMatch(t, pattern, exec, "fob")
+
+	// and the branches after one that ends above them are left out
+	alternation := &syntax.RegexNode{T: syntax.NtAlternate, Children: []*syntax.RegexNode{
+		{T: syntax.NtMulti, Str: []rune("foo")},
+		{T: syntax.NtMulti, Str: []rune("foobar")},
+		{T: syntax.NtMulti, Str: []rune("fa")},
+	}}
+	trie := buildAlternationTrie(alternation, true)
+	if trie == nil || len(trie.chars) != 1 {
+		t.Fatalf("expected a trie for foo|foobar|fa")
+	}
+	if f := trie.children[0]; len(f.chars) != 2 || string(f.children[0].remainder) != "o" || f.children[0].end != 0 || f.children[1].end != 2 {
+		t.Errorf("expected foobar to be left out of the trie")
+	}
+}
+
+func TestFindMatchingNode(t *testing.T) {
+	tree, err := syntax.Parse(`a+b`, syntax.Compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := syntax.Parse(`a+b`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tree.Root.Children[0].Children[0]
+	if n := findMatchingNode(tree.Root, plain.Root, target); n != plain.Root.Children[0].Children[0] {
+		t.Errorf("expected the loop of the plain parse, got %v", n)
+	}
+
+	// a tree of another shape has no matching node rather than indexing past its children
+	other, err := syntax.Parse(`a`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := findMatchingNode(tree.Root, other.Root, target); n != nil {
+		t.Errorf("expected no matching node in a tree of another shape, got %v", n)
+	}
+}
+
+func TestNegativeLookahead_Anchor(t *testing.T) {
+	// the anchor is checked at the position the lookahead starts
+	pattern := `a(?!$)`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "ab", " 0: a")
+	runMatch(t, pattern, exec, "aab", " 0: a")
+	runNoMatch(t, pattern, exec, "a")
+	runNoMatch(t, pattern, exec, `a\n`)
+
+	exec = generateAndCompile(t, pattern, syntax.Multiline)
+	runNoMatch(t, pattern, exec, `a\nb`)
+	runMatch(t, pattern, exec, `a\nab`, " 0: a")
+}
+
+func TestSingleCharLoopBacktrack_RightToLeft(t *testing.T) {
+	// giving back chars in a lookbehind moves forward, towards where the loop started
+	pattern := `(?<=a.*bc)d`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "zzabbbcd", " 0: d")
+	runMatch(t, pattern, exec, "abcd", " 0: d")
+	runNoMatch(t, pattern, exec, "xbcd")
+
+	pattern = `(?<=xy.*bc)d`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xxybcd", " 0: d")
+	runMatch(t, pattern, exec, "xyxyzbcd", " 0: d")
+	runNoMatch(t, pattern, exec, "xzybcd")
+
+	pattern = `(?<=ab\w{1,3})c`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abzzc", " 0: c")
+	runMatch(t, pattern, exec, "aabxxxc", " 0: c")
+	runNoMatch(t, pattern, exec, "abzzzzc")
+}
+
+func BenchmarkSingleCharLoopBacktrack_RightToLeft(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `(?<=a.*bc)d`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, strings.Repeat("zzzzzzzzzbcd", 200))
+}
+
+func TestAnchors_TrailingNewline(t *testing.T) {
+	// \Z and $ allow a single trailing \n, \z allows none
+	tests := []struct {
+		pattern  string
+		input    string
+		expected string // empty for no match
+	}{
+		{`\Aab`, "ab", " 0: ab"},
+		{`\Aab`, `ab\n`, " 0: ab"},
+		{`\Aab`, "xab", ""},
+		{`ab\Z`, "ab", " 0: ab"},
+		{`ab\Z`, `ab\n`, " 0: ab"},
+		{`ab\Z`, `ab\n\n`, ""},
+		{`ab\Z`, "abx", ""},
+		{`ab\z`, "ab", " 0: ab"},
+		{`ab\z`, `ab\n`, ""},
+		{`ab\z`, `ab\n\n`, ""},
+		{`ab$`, `ab\n`, " 0: ab"},
+		{`ab$`, `ab\n\n`, ""},
+		{`b\Z\n?`, `ab\n`, ` 0: b\x0a`},
+		{`b\z\n?`, `ab\n`, ""},
+	}
+
+	for _, test := range tests {
+		exec := generateAndCompile(t, test.pattern, 0)
+		if test.expected == "" {
+			runNoMatch(t, test.pattern, exec, test.input)
+		} else {
+			runMatch(t, test.pattern, exec, test.input, test.expected)
+		}
+	}
+}
+
+func TestAnchors_FixedLength(t *testing.T) {
+	// anchored at both ends with a fixed length, one check on the input's length replaces the rest
+	pattern := `^[a-z]{3}-\d{2}x$`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "if pos != 0 || (len(runtext) != 7 && (len(runtext) != 8 || runtext[7] != '\\n')) {") ||
+		strings.Contains(code, "len(slice) <") {
+		t.Errorf("expected a single length check in:\n%s", code)
+	}
+	if code := generateCode(t, `^\d{2,4}$`, 0); strings.Contains(code, "only all of the input can match") {
+		t.Errorf("unexpected fixed length check for a variable length pattern")
+	}
+
+	for _, pattern := range []string{pattern, `\Aab[^c]{20}\z`, `^(?i)é\w\Z`, `\A\d{4}\z`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"abc-12x", "abc-12x\n", "abc-12x\n\n", "abc-12xx", "xabc-12x", "abc-1x", "ab" + strings.Repeat("d", 20),
+			"ab" + strings.Repeat("d", 20) + "\n", "ab" + strings.Repeat("d", 19) + "c", "ÉA", "éa\n", "é!", "2024", "2024\n", "20245", "202a"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestAstralCodePoints(t *testing.T) {
+	// runes above U+FFFF are single runes in Runtext, so sets compare them like any other
+	patterns := []string{`\x{1F600}`, `[\x{1F600}-\x{1F64F}]+`, `a[\x{1F600}-\x{1F64F}b]{2}`, `[^\x{1F600}-\x{1F64F}]+`,
+		`[\x{1F600}\x{1F680}\x{10000}]+`, `\x{1F600}+?\x{1F601}`, `(?i)\x{10428}\x{1F600}`, `[\x{FFFF}-\x{10001}]`,
+		`[\x{1F600}-\x{1F60F}\x{1F620}-\x{1F62F}\x{1F640}-\x{1F64F}\x{1F680}-\x{1F68F}\x{10000}-\x{1000F}]+`}
+	for _, pattern := range patterns {
+		for _, genOpts := range []Options{{}, {BinarySearchSets: true, LeadingSetTable: true}} {
+			exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+			for _, input := range []string{"a😀🙂x", "😀😀😁", "ab🙏", "x🚀𐀀y", "😀", "\uffff\U00010000", "\U00010400😀", "\U00010428😀", "🙐", "🙀😐"} {
+				runMatchLikeInterpreter(t, pattern, 0, exec, input)
+			}
+		}
+	}
+}
+
+func TestNotone_Astral(t *testing.T) {
+	// the char is compared as a whole rune, so U+F600, its low 16 bits, and U+1F601 aren't it
+	pattern := `a[^\x{1F600}]b`
+	code := generateCode(t, pattern, 0)
+	for _, want := range []string{"slice[1] == '😀'", "Match any character other than '😀'."} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+	for _, pattern := range []string{pattern, `[^\x{1F600}]+`, `(?:[^\x{1F600}]x)+?y`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"a\uf600b", "a😁b", "a😀b", "x😀\uf600x😁xy", "😀😀", "😀\U0001F5FF😀"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+
+	// surrogates have no Go rune literal and don't survive the set hash, so they're left to the interpreter
+	for _, pattern := range []string{`[^\ud83d\ude00]+`, `a[^\ud83d]b`, `\ud83d\ude00`, `[\ud800-\udbff][\udc00-\udfff]`} {
+		c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addRegexp("MyFile.go:120:10", "MyPattern", pattern, 0); err == nil || !strings.Contains(err.Error(), "code generation not supported") {
+			t.Errorf("expected surrogates to be unsupported for %v, got %v", pattern, err)
+		}
+	}
+}
+
+func TestBalancingGroup_EmptyCapture(t *testing.T) {
+	// transferring a capture needs the runner to do it, and the runner doesn't export that,
+	// so these are left to the interpreter rather than generating code that can't build
+	for _, pattern := range []string{
+		`(?<a>)(?<b-a>x)`,
+		`(?<a>y?)x(?<-a>)`,
+		`((?<open>\()|(?<close-open>\)))+`,
+		// balanced parentheses, with a pure uncapture for the closing ones
+		`^(?:[^()]|(?<open>\()|(?<-open>\)))*(?(open)(?!))$`,
+		// nested brackets, where backtracking an iteration has to undo its transfer
+		`\[(?:[^\[\]]|(?<open>\[)|(?<inner-open>\]))*?\]`,
+	} {
+		c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.addRegexp("MyFile.go:120:10", "MyPattern", pattern, 0)
+		if err == nil || !strings.Contains(err.Error(), "balancing groups are not supported") {
+			t.Errorf("expected balancing groups to be unsupported for %v, got %v", pattern, err)
+		}
+	}
+}
+
+func TestQuoting_Unsupported(t *testing.T) {
+	// regexp2's parser has no \Q...\E quoting, the converter reports its error
+	c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.addRegexp("MyFile.go:120:10", "MyPattern", `\Qa.b*\E`, 0)
+	if err == nil || !strings.Contains(err.Error(), `unrecognized escape sequence \Q`) {
+		t.Errorf("expected \\Q to be rejected by the parser, got %v", err)
+	}
+
+	// escaping each metacharacter instead matches the literal
+	pattern := `a\.b\*`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xa.b*", " 0: a.b*")
+	runNoMatch(t, pattern, exec, "ab")
+	runNoMatch(t, pattern, exec, "axbbb")
+}
+
+func TestSubroutineCall_Unsupported(t *testing.T) {
+	// regexp2's parser has no subroutine calls or recursion, the converter reports its error
+	for pattern, want := range map[string]string{
+		`(a)(?1)`: "unrecognized grouping construct: (?1",
+		`a(?R)?b`: "unrecognized grouping construct: (?R",
+		`(?&x)`:   "unrecognized grouping construct: (?&",
+	} {
+		c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addRegexp("MyFile.go:120:10", "MyPattern", pattern, 0); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %v, got %v", want, pattern, err)
+		}
+	}
+
+	// a node type without an emitter is an error rather than a panic when emitting
+	node := &syntax.RegexNode{T: syntax.NtConcatenate, Children: []*syntax.RegexNode{{T: syntax.NtOne, Ch: 'a'}, {T: syntax.NtUnknown}}}
+	if err := supportsCodeGenNode(node); err == nil || err.Error() != "node type -1 isn't supported" {
+		t.Errorf("expected an unknown node type to be unsupported, got %v", err)
+	}
+}
+
+func TestUnhandledNode_Error(t *testing.T) {
+	// the parser reduces groups away, one left in the tree has no emitter
+	pattern := `a(?:b|cd)+e`
+	tree, err := syntax.Parse(pattern, syntax.Compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := tree.Root.Children[0].Children[1]
+	loop.Children[0] = &syntax.RegexNode{T: syntax.NtGroup, Children: []*syntax.RegexNode{loop.Children[0]}}
+
+	c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := &regexpData{GeneratedName: "MyPattern", Pattern: pattern, Tree: tree, Analysis: analyze(tree)}
+	c.emitExecute(rm)
+	if rm.err == nil || rm.err.Error() != `unhandled node Group (type 29) in "a(?:b|cd)+e"` {
+		t.Errorf("expected an error for the group, got %v", rm.err)
+	}
+}
+
+func TestGenericNewline(t *testing.T) {
+	// regexp2 has no \R, it's an unrecognized escape, or a literal R with ECMAScript and RE2
+	c, err := newConverter(&bytes.Buffer{}, "main", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.addRegexp("MyFile.go:120:10", "MyPattern", `\R+`, 0)
+	if err == nil || !strings.Contains(err.Error(), `unrecognized escape sequence \R`) {
+		t.Errorf("expected \\R to be rejected by the parser, got %v", err)
+	}
+	exec := generateAndCompile(t, `\R+`, syntax.ECMAScript)
+	runMatch(t, `\R+`, exec, "aRRb", " 0: RR")
+
+	// \v is the vertical tab alone
+	for _, pattern := range []string{`a\vb`, `[\v]+`, `\v+?b`, `[\v\n]+`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"a\vb", "a\nb", "\v\v\nb", "\f\vb", "ab"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+
+	// what \R stands for elsewhere, \r\n before the single newline chars so a pair is one
+	for _, pattern := range []string{`(?:\r\n|[\n\v\f\r\x85\u2028\u2029])+`, `(\r\n|[\n\v\f\r\x85\u2028\u2029])+?x`, `^(?:\r\n|[\n\r])*$`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"a\r\n\n\r\r\nb", "\n\r\n\rx", "\r\r\n\n", "a\u2028\u0085\u2029\vx", "\r\na", ""} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestNamedCaptureNumbers(t *testing.T) {
+	// named groups are numbered after the unnamed ones
+	pattern := `(?<year>\d{4})-(?<month>\d{2})`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "on 2024-10-16", " 1: 2024")
+	runMatch(t, pattern, exec, "on 2024-10-16", " 2: 10")
+	exec = generateAndCompileMain(t, "_runenginemain.go", pattern, 0, Options{})
+	runMatch(t, pattern, exec, "", "CapNames: map[0:0 month:2 year:1]")
+
+	pattern = `(?<month>\d{2})/(\d{4})`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "10/2024", " 1: 2024")
+	runMatch(t, pattern, exec, "10/2024", " 2: 10")
+
+	// explicit numbers leave gaps, the engine captures into the slot for the number
+	pattern = `(?<10>\d{4})-(?<3>\d{2})`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "2024-10", " 1: 10")
+	runMatch(t, pattern, exec, "2024-10", " 2: 2024")
+	exec = generateAndCompileMain(t, "_runenginemain.go", pattern, 0, Options{})
+	runMatch(t, pattern, exec, "", "Caps: map[0:0 3:1 10:2]")
+}
+
+func TestBackreference_IgnoreCase(t *testing.T) {
+	pattern := `(?i)(ab)\1`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abAB", " 0: abAB")
+	runMatch(t, pattern, exec, "aBAb", " 1: aB")
+	runNoMatch(t, pattern, exec, "abac")
+
+	pattern = `(?i)(\w+)\1`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xyXYz", " 0: xyXY")
+
+	// only the backreference ignores case
+	pattern = `(ab)(?i:\1)`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abAB", " 0: abAB")
+	runNoMatch(t, pattern, exec, "ABab")
+}
+
+func TestInlineOptions_IgnoreCase(t *testing.T) {
+	// the case of each node comes from its own options, not the pattern's
+	pattern := `(?i:abc)DEF`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "AbCDEF", " 0: AbCDEF")
+	runMatch(t, pattern, exec, "xabcDEF", " 0: abcDEF")
+	runNoMatch(t, pattern, exec, "abcdef")
+	runNoMatch(t, pattern, exec, "ABCDEf")
+
+	pattern = `(?i)abc(?-i:DEF)`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aBcDEF", " 0: aBcDEF")
+	runNoMatch(t, pattern, exec, "abcdef")
+
+	// loops and sets inside the scope
+	pattern = `a(?i:[b-d]+x?)e`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "aBcDXe", " 0: aBcDXe")
+	runNoMatch(t, pattern, exec, "ABcde")
+	runNoMatch(t, pattern, exec, "abcdE")
+
+	// the same with IgnoreCase set for the pattern and turned off in the scope
+	pattern = `a(?-i:[b-d]+x?)e`
+	exec = generateAndCompile(t, pattern, syntax.IgnoreCase)
+	runMatch(t, pattern, exec, "AbcdxE", " 0: AbcdxE")
+	runNoMatch(t, pattern, exec, "aBcde")
+}
+
+func TestUncapture_BeforeCaptures(t *testing.T) {
+	// failing before the group can't have captured anything, only the failures after it uncapture
+	pattern := `^\d+-(\w+)`
+	code := generateCode(t, pattern, 0)
+	if n := strings.Count(code, "r.UncaptureUntil(0)"); n != 1 {
+		t.Errorf("expected 1 uncapture, got %v in:\n%s", n, code)
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "12-ab", " 1: ab")
+	runNoMatch(t, pattern, exec, "12-")
+	runNoMatch(t, pattern, exec, "x12-ab")
+
+	// the b in the second iteration fails after the first captured
+	pattern = `(?:b(a)){3}x`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, "r.UncaptureUntil(0)\n\t\t\treturn nil") {
+		t.Errorf("expected the failures in the loop to uncapture in:\n%s", code)
+	}
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "babaxbababax", " 0: bababax")
+	runMatch(t, pattern, exec, "babaxbababax", " 1: a")
+
+	// the second branch fails before its own group, after the first one captured
+	pattern = `x(a)b|y(c)`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xaxyc", " 0: yc")
+	runMatch(t, pattern, exec, "xaxyc", " 1: <unset>")
+	runMatch(t, pattern, exec, "xaxyc", " 2: c")
+}
+
+func TestLoop_SingleIteration(t *testing.T) {
+	// a {1} loop is just its child, greedy or lazy, with no loop code
+	for _, test := range []struct {
+		pattern       string
+		input, output string
+	}{
+		{`xa{1}b`, "zxab", " 0: xab"},
+		{`x[a-z]{1}y`, "xxqy", " 0: xqy"},
+		{`x[a-z]{1}?y`, "xqy", " 0: xqy"},
+		{`x(?:ab|c){1}y`, "xcy", " 0: xcy"},
+		{`x(?:ab|cd){1}?y`, "xaby", " 0: xaby"},
+		{`x(a){1}y`, "xay", " 1: a"},
+	} {
+		code := generateCode(t, test.pattern, 0)
+		_, execute, _ := strings.Cut(code, "func (MyPattern_Engine) Execute(")
+		execute, _, _ = strings.Cut(execute, "\n}\n")
+		if strings.Contains(execute, "for ") || strings.Contains(execute, "Loop") {
+			t.Errorf("unexpected loop for %v in:\n%s", test.pattern, execute)
+		}
+		exec := generateAndCompile(t, test.pattern, 0)
+		runMatch(t, test.pattern, exec, test.input, test.output)
+		runNoMatch(t, test.pattern, exec, "xy")
+	}
+}
+
+func TestAlternation_AfterFixedRun(t *testing.T) {
+	// the switch on the alternation has to index past the "abc" that's still in sliceStaticPos
+	pattern := `abc(?:dx|ey)`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, "switch slice[3] {") {
+		t.Errorf("expected the switch to read slice[3]")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abcdx", " 0: abcdx")
+	runMatch(t, pattern, exec, "zabcey", " 0: abcey")
+	runNoMatch(t, pattern, exec, "abcd")
+	runNoMatch(t, pattern, exec, "abcx")
+	runNoMatch(t, pattern, exec, "abdx")
+
+	// d|e is parsed as the set [de]
+	pattern = `abc(d|e)`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abcd", " 1: d")
+	runMatch(t, pattern, exec, "abce", " 1: e")
+	runNoMatch(t, pattern, exec, "abcf")
+}
+
+func TestAlternation_SliceStaticPos(t *testing.T) {
+	// each branch starts back at the alternation's sliceStaticPos, and hands on pos past what it
+	// matched, so backtracking from c into the shorter branch reads the right chars
+	patterns := []string{`(ab|a)c`, `x(ab|a)c`, `xy(?:ab|a(bc|b)|a)c`, `(?:x(ab|a)|yz)c`, `(?:x(ab|a)c)+d`, `(\w(ab|a)){2}c`}
+	for _, pattern := range patterns {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"abc", "ac", "xabc", "xac", "xyabcc", "xyabc", "xyac", "yzc", "xacxabcd", "zaczabc", "xab", "aab"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestAlternation_OverlappingBranches(t *testing.T) {
+	// the atomic alternation still switches, with one case trying both branches that start with a
+	pattern := `(?>a\d|[cx]y|[ab]\w|d)z`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, "case 'a', 'b':") || !strings.Contains(code, "case 'd':") {
+		t.Errorf("expected a switch with a case for the overlapping branches")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	for _, input := range []string{"a1z", "abz", "bbz", "a1", "axz", "cyz", "xyz", "dz", "ez", "b1z"} {
+		runMatchLikeInterpreter(t, pattern, 0, exec, input)
+	}
+
+	// two groups, with captures and in a loop
+	for _, pattern := range []string{`(?>(a)1|(b)2|a\w|(c)|b\w)+!`, `\b(?:(x)\d+|y|[xz]\w)\b`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"a1b2c!", "aab3!", "cc!", "b2a!", "x12 z", "xa", "y", "za", "d!"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+
+	// without atomicity a branch that fails later has to backtrack into the next, so there's no switch
+	if code := generateCode(t, `(a\d|[cx]y|[ab]\w|d)+z`, 0); strings.Contains(code, "switch slice[0]") {
+		t.Errorf("expected no switch for a backtracking alternation with overlapping branches")
+	}
+}
+
+var labelDeclRegex = regexp.MustCompile(`(?m)^\s*(\w+):\s*;?\s*$`)
+
+func TestNoUnusedLabels(t *testing.T) {
+	patterns := []string{`\d+x`, `a(b)c`, `[a-z]+`, `(?>a+)b`, `a{2,5}?b`, `\bfoo\b`, `(a|bc)d`,
+		`x(?=y)`, `(?<!a)b`, `^\w+$`, `(a+)(ab|c)`, `(\w+)\s+\1`, `(?:ab|cd)*e`}
+	for _, pattern := range patterns {
+		for _, opts := range []Options{{}, {ExplainMatch: true}} {
+			code := generateCodeWithOptions(t, pattern, 0, opts)
+			for _, m := range labelDeclRegex.FindAllStringSubmatch(code, -1) {
+				if m[1] == "default" {
+					continue
+				}
+				if !regexp.MustCompile(`goto ` + m[1] + `\b`).MatchString(code) {
+					t.Errorf("label %v is never jumped to for %v", m[1], pattern)
+				}
+			}
+		}
+	}
+}
+
+// checks the generated engine finds the same groups as the interpreter, nothing's
+// registered in this process so MustCompile here is the interpreter
+func runMatchLikeInterpreter(t *testing.T, pattern string, opts syntax.RegexOptions, reExec, input string) {
+	t.Helper()
+	m, err := regexp2.MustCompile(pattern, regexp2.RegexOptions(opts)).FindStringMatch(input)
+	if err != nil {
+		t.Fatalf("interpreter error for %v: %v", pattern, err)
+	}
+	if m == nil {
+		runNoMatch(t, pattern, reExec, input)
+		return
+	}
+	for i, g := range m.Groups() {
+		want := fmt.Sprintf("%2v: <unset>", i)
+		if len(g.Captures) > 0 {
+			want = fmt.Sprintf("%2v: %s", i, escapeGroup(g.String()))
+		}
+		runMatch(t, pattern, reExec, input, want)
+	}
+}
+
+// writes the bytes outside of printable ASCII as \x00, the same as the test main does
+func escapeGroup(val string) string {
+	buf := &strings.Builder{}
+	for i := 0; i < len(val); i++ {
+		if ch := val[i]; ch <= 0x1f || ch >= 0x7f {
+			fmt.Fprintf(buf, "\\x%.2x", ch)
+		} else {
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+func TestAnchors_Multiline(t *testing.T) {
+	// without Multiline ^ and $ are Beginning and EndZ, with it they're Bol and Eol
+	pattern := `^abc$`
+	code := generateCode(t, pattern, 0)
+	// the tree dump, as the fixed length of abc means Execute only checks the input's length for these
+	if !strings.Contains(code, "\n  Beginning\n") || !strings.Contains(code, "\n  EndZ\n") || strings.Contains(code, "beginning-of-line") {
+		t.Errorf("expected no line anchors without Multiline")
+	}
+	code = generateCode(t, pattern, syntax.Multiline)
+	if !strings.Contains(code, "// Node: Bol-M") || !strings.Contains(code, "// Node: Eol-M") {
+		t.Errorf("expected line anchors with Multiline")
+	}
+
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abc", " 0: abc")
+	runMatch(t, pattern, exec, "abc\\n", " 0: abc")
+	runNoMatch(t, pattern, exec, "x\\nabc")
+	runNoMatch(t, pattern, exec, "abc\\nx")
+	runNoMatch(t, pattern, exec, "abc\\n\\n")
+
+	exec = generateAndCompile(t, pattern, syntax.Multiline)
+	runMatch(t, pattern, exec, "abc", " 0: abc")
+	runMatch(t, pattern, exec, "x\\nabc", " 0: abc")
+	runMatch(t, pattern, exec, "abc\\nx", " 0: abc")
+	runMatch(t, pattern, exec, "x\\nabc\\ny", " 0: abc")
+	runMatch(t, pattern, exec, "abc\\n\\n", " 0: abc")
+	runNoMatch(t, pattern, exec, "xabc\\nabcx")
+}
+
+func TestAnchors_MultilineCRLF(t *testing.T) {
+	// as in .NET, $ with Multiline only matches before \n, so the \r of a \r\n line ending
+	// has to be matched before it, foo$\r\n never matches
+	pattern := `foo$\r\nbar`
+	exec := generateAndCompile(t, pattern, syntax.Multiline)
+	runNoMatch(t, pattern, exec, "foo\\r\\nbar")
+	runNoMatch(t, pattern, exec, "foo\\r\\r\\nbar")
+
+	pattern = `foo\r$\nbar`
+	exec = generateAndCompile(t, pattern, syntax.Multiline)
+	runMatch(t, pattern, exec, "foo\\r\\nbar", ` 0: foo\x0d\x0abar`)
+
+	pattern = `\w+\r?$`
+	exec = generateAndCompile(t, pattern, syntax.Multiline)
+	runMatch(t, pattern, exec, "foo\\r\\nbar", ` 0: foo\x0d`)
+
+	inputs := []string{"foo\r\nbar", "foo\nbar", "foo\r\n", "foo\r", "x\r\nfoo\r\nbar\r\n"}
+	for _, pattern := range []string{`foo$\r\nbar`, `foo\r$\nbar`, `foo$`, `\w+$`, `(?:o|\r)$`, `$\n`} {
+		for _, opts := range []syntax.RegexOptions{syntax.Multiline, syntax.Multiline | syntax.RightToLeft} {
+			exec := generateAndCompile(t, pattern, opts)
+			for _, input := range inputs {
+				runMatchLikeInterpreter(t, pattern, opts, exec, input)
+			}
+		}
+	}
+}
+
+func TestExecute_MinLength(t *testing.T) {
+	pattern := `ab\d+c|xyz\w`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, "if len(runtext)-pos < 4 {") {
+		t.Errorf("expected Execute to check for at least 4 chars")
+	}
+	if code := generateCode(t, pattern, syntax.RightToLeft); !strings.Contains(code, "if pos < 4 {") {
+		t.Errorf("expected the right-to-left Execute to check for at least 4 chars before pos")
+	}
+	// nothing to check when the pattern can match empty
+	if code := generateCode(t, `a?(?=b)`, 0); strings.Contains(code, "Any possible match is at least") {
+		t.Errorf("expected no length check for a pattern that can match empty")
+	}
+
+	for _, opts := range []syntax.RegexOptions{0, syntax.RightToLeft} {
+		exec := generateAndCompile(t, pattern, opts)
+		for _, input := range []string{"ab1c", "zab12c", "ab1", "xyz", "xyz_", "zzxyzab1c", "abc"} {
+			runMatchLikeInterpreter(t, pattern, opts, exec, input)
+		}
+	}
+}
+
+func TestAtomic_Stackpos(t *testing.T) {
+	// the child only backtracks through its locals, so there's no stack position to save
+	pattern := `(?>\w+\d)x`
+	if code := generateCode(t, pattern, 0); strings.Contains(code, "atomic_stackpos") {
+		t.Errorf("expected no atomic_stackpos for %v", pattern)
+	}
+	// the loop pushes the capture's state on each iteration, which has to be removed after it
+	if code := generateCode(t, `(?>(\w)+\d)x`, 0); !strings.Contains(code, "r.Runstackpos = atomic_stackpos") {
+		t.Errorf("expected atomic_stackpos to be reset after the loop")
+	}
+
+	inputs := []string{"ab1x", "ab1", "a12x", "1x", "abc", "ab1x2x", "aab1c"}
+	for _, pattern := range []string{pattern, `(?>(\w)+\d)x`, `(?:(?>\w+\d)x|\w+)c`, `((?>a+?b)|a)*c`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestPositiveLookaround_Captures(t *testing.T) {
+	// the capture persists after the lookahead succeeds, even though it consumes nothing
+	pattern := `(?=(\d+))\d`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "ab123", " 0: 1")
+	runMatch(t, pattern, exec, "ab123", " 1: 123")
+
+	// the capture is undone when the lookahead's child fails after it, or a later
+	// node fails and the match backtracks to another branch
+	inputs := []string{"123", "12x", "1y", "12y", "aab", "abc!", "ab!", "xa", "1x", "1a"}
+	for _, pattern := range []string{`(?=(\d+)\d)\d`, `(?=(\d+))\d?x|\w+`, `(?:(?=(\d+)\d)\d)*y`,
+		`((?=(a))\w)*?b|a+`, `(?:(?=(\w+))\w{2})+?!|(x)`, `(?<=(\d+))[a-z]`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestLoop_Lookaround(t *testing.T) {
+	inputs := []string{"abc", "ab", "a", "a1b2c3", "xy zab", "aab", "!!", "ababx"}
+	for _, pattern := range []string{`(?:\w(?=\w))+`, `(?:a(?=b))+`, `(?:(\w)(?!\d))+`, `(?:\w(?<=\w))*x`, `(?:(?=a))*b`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestBackreference_Named(t *testing.T) {
+	pattern := `(?<q>['"]).*?\k<q>`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, `// Match the same text as matched by the "q" capture group.`) {
+		t.Errorf("expected the backreference to describe the group by name")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "say 'hi' now", " 0: 'hi'")
+	runMatch(t, pattern, exec, `a "b" c`, ` 1: "`)
+	runMatch(t, pattern, exec, `"x'y"z`, ` 0: "x'y"`)
+	runNoMatch(t, pattern, exec, `'mixed"`)
+
+	pattern = `(?<q>['"]).*?\k'q'`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "say 'hi' now", " 0: 'hi'")
+
+	// numbered groups are described by number
+	code = generateCode(t, `(a)(?<5>b)\1\5`, 0)
+	if !strings.Contains(code, "by the 1st capture group.") || !strings.Contains(code, "by the 5th capture group.") {
+		t.Errorf("expected numbered groups to be described by number")
+	}
+}
+
+func BenchmarkConcatenation_SingleChars(b *testing.B) {
+	// a long run of single char checks joined behind one length check
+	exec := generateAndCompileMain(b, "_runbenchmain.go", strings.Repeat(`[a-c]\d`, 12)+"x", 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, strings.Repeat("a1b2c3", 1000)+strings.Repeat("a1b2c3", 4)+"x")
+}
+
+func TestMultiCharString_Words(t *testing.T) {
+	code := generateCode(t, `\d\w+https://|\s\n'zz`, 0)
+	for _, want := range []string{
+		`!startsWith8(slice, [8]rune{'h', 't', 't', 'p', 's', ':', '/', '/'})`,
+		`[4]rune{'\n', '\'', 'z', 'z'}`,
+		"func startsWith4(s []rune, lit [4]rune) bool {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	pattern := `\d\w+https://`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "1xhttps://", " 0: 1xhttps://")
+	runMatch(t, pattern, exec, "x 1ahttps://x", " 0: 1ahttps://")
+	// ends before the whole literal
+	runNoMatch(t, pattern, exec, "1xhttps:/")
+	runNoMatch(t, pattern, exec, "1xhttps:")
+
+	pattern = `\s(?:ab|cdef)`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "x cdef", " 0:  cdef")
+	runNoMatch(t, pattern, exec, "x cde")
+}
+
+var multiCharStringBenchInput = strings.Repeat("abcd", 3000) + "x"
+
+func BenchmarkMultiCharString_Words(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `(?:abcd)+x`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, multiCharStringBenchInput)
+}
+
+func TestLoop_EmptyIterationCaptures(t *testing.T) {
+	// the crawl position is pushed first and popped last, so backtracking an iteration
+	// restores pos and the starting pos before uncapturing what the iteration captured
+	code := generateCode(t, `(\b)*x`, 0)
+	push := "r.StackPushN(r.Crawlpos(), loop_starting_pos, pos)"
+	pop := "pos = r.StackPop()\n\tloop_starting_pos = r.StackPop()\n\tr.UncaptureUntil(r.StackPop())"
+	if !strings.Contains(code, push) || !strings.Contains(code, pop) {
+		t.Errorf("expected the crawl position pushed before the loop positions and popped after them in:\n%s", code)
+	}
+
+	// an empty iteration ends the loop, keeping its captures, and an atomic lazy loop, e.g. the
+	// last node right to left, only ever matches its minimum
+	inputs := []string{"", "x", " x", "a b", "ab", "aab", "yx", " c", "a,,b,", "xyz", "abababc"}
+	for _, pattern := range []string{`(\b)*`, `(\b)*x`, `(\b)*?x`, `(\b){2,}x`, `(a?)*b`, `((\b)|a)*?b`,
+		`(\b|a)*\1`, `(?:(\b)(a?))*c`, `(?:(\b)*,)+`, `(?:(\b)*?y|x)+z`, `(\B)*x`, `((?=a))*a`, `(?:(a|ab)(\b)){2,3}?$`} {
+		for _, opts := range []syntax.RegexOptions{0, syntax.RightToLeft} {
+			exec := generateAndCompile(t, pattern, opts)
+			for _, input := range inputs {
+				runMatchLikeInterpreter(t, pattern, opts, exec, input)
+			}
+		}
+	}
+	// the interpreter gives an empty match for this one right to left, though x has to match
+	pattern := `(\b)+?x`
+	exec := generateAndCompile(t, pattern, syntax.RightToLeft)
+	runMatch(t, pattern, exec, " x", " 0: x")
+	runMatch(t, pattern, exec, " x", " 1: ")
+	runNoMatch(t, pattern, exec, " y")
+}
+
+func TestLazyLoop_MinIterations(t *testing.T) {
+	// the minimum is matched before the lazy loop, with IndexOf once it's too long to unroll
+	pattern := `([0-9]{20,}?)([0-9]*)x`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "IndexOfAnyExceptInRange(slice[0:20], '0', '9') >= 0") {
+		t.Errorf("expected the min iterations searched with IndexOf in:\n%s", code)
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	digits := strings.Repeat("0123456789", 3)
+	runMatch(t, pattern, exec, digits+"x", " 1: "+digits[:20])
+	runMatch(t, pattern, exec, digits+"x", " 2: "+digits[20:])
+	runNoMatch(t, pattern, exec, digits[:19]+"x")
+	runNoMatch(t, pattern, exec, digits[:10]+"a"+digits[:10]+"x")
+
+	// lazy matches the minimum and extends it only as far as what follows needs, greedy takes all it can
+	for _, test := range []struct {
+		pattern, input string
+		want           []string
+	}{
+		{`(\d{2,}?)(\d*)x`, "12345x", []string{" 1: 12", " 2: 345"}},
+		{`(\d{2,})(\d*)x`, "12345x", []string{" 1: 12345", " 2: "}},
+		{`(\d{2,}?)(\d)x`, "12345x", []string{" 1: 1234", " 2: 5"}},
+		{`(\d{2,4}?)(\d)x`, "123456x", []string{" 0: 23456x", " 1: 2345"}},
+		{`(\d{2,}?)x`, "12345x", []string{" 1: 12345"}},
+	} {
+		exec := generateAndCompile(t, test.pattern, 0)
+		for _, want := range test.want {
+			runMatch(t, test.pattern, exec, test.input, want)
+		}
+		runMatchLikeInterpreter(t, test.pattern, 0, exec, test.input)
+	}
+}
+
+func TestLazyOptional(t *testing.T) {
+	// a and b can't overlap, so a??b is made atomic and a is matched if it's there
+	pattern := `a??b`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "ab", " 0: ab")
+	runMatch(t, pattern, exec, "xb", " 0: b")
+	runNoMatch(t, pattern, exec, "aa")
+
+	// otherwise a is skipped unless what follows can't match without it
+	pattern = `(a??)(\w)b`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "lazyoptional_pos = -1") || strings.Contains(code, "lazyloop_iteration") {
+		t.Errorf("expected the lazy optional fast path in:\n%s", code)
+	}
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "ab", " 1: ")
+	runMatch(t, pattern, exec, "aab", " 1: a")
+	runMatch(t, pattern, exec, "aab", " 2: a")
+	runNoMatch(t, pattern, exec, "a!b")
+
+	// in a loop, with captures, and right to left
+	for _, test := range []struct {
+		pattern string
+		opts    syntax.RegexOptions
+	}{{`(?:x(a??)a)+y`, 0}, {`(a??)\w{2}`, 0}, {`(a??)(\w)b`, syntax.RightToLeft}, {`^(?:(a??)\w)*$`, 0}} {
+		exec := generateAndCompile(t, test.pattern, test.opts)
+		for _, input := range []string{"xaay", "xaxaay", "xay", "aab", "ab", "aaab", "aaa", "aba", "b"} {
+			runMatchLikeInterpreter(t, test.pattern, test.opts, exec, input)
+		}
+	}
+}
+
+func TestLazyLoop_MultiCharLiteral(t *testing.T) {
+	pattern := `<div>(.*?)</div>`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, `lazyloop_pos = indexOfBefore(slice, []rune("</div>"), '\n')`) {
+		t.Errorf("expected a search for the whole literal")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "<div>a<b>c</b></div></div>", " 1: a<b>c</b>")
+	runMatch(t, pattern, exec, "<div></di</div>", " 1: </di")
+	// the loop can't go past a newline
+	runNoMatch(t, pattern, exec, "<div>a\n</div>")
+	runMatch(t, pattern, exec, "<div>a\n<div>b</div>", " 1: b")
+
+	// the literal can include the char the loop stops at
+	pattern = `<([^>]*?)>x`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "<a>y<b>x", " 1: b")
+
+	pattern = `([a-z]*?)abc`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, `helpers.IndexOf(slice[:helpers.Min(len(slice), lazyloop_pos+3)], []rune("abc"))`) {
+		t.Errorf("expected a search for the whole literal up to the first char not in the set")
+	}
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xyab abc", " 1: ")
+	runMatch(t, pattern, exec, "xyababc", " 1: xyab")
+	runNoMatch(t, pattern, exec, "xyab")
+}
+
+var lazyLoopBenchInput = "<div>" + strings.Repeat("<li><b>x</b></li>", 200) + "</div>"
+
+func BenchmarkLazyLoop_MultiCharLiteral(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `<div>.*?</div>`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, lazyLoopBenchInput)
+}
+
+var lazySetLoopBenchInput = strings.Repeat("abcdefgh", 300) + "ing"
+
+func BenchmarkLazyLoop_SetMultiCharLiteral(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `^[a-z]+?ing`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, lazySetLoopBenchInput)
+}
+
+func TestBackreferenceConditional_Named(t *testing.T) {
+	pattern := `(?<a>x)?(?(a)yes|no)`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, `whether the "a" capture group matched.`) {
+		t.Errorf("expected the conditional's comment to name the group")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xyes", " 0: xyes")
+	runMatch(t, pattern, exec, "xno", " 0: no")
+	runMatch(t, pattern, exec, "yesno", " 0: no")
+	runNoMatch(t, pattern, exec, "xye")
+
+	// the group comes later, so it's only matched on a later iteration, and the
+	// names resolve to their group numbers, not their position
+	for _, pattern := range []string{`(?(a)yes|no)(?<a>x)`, `(?:(?(a)yes|no)(?<a>x))+`, `(?<b>y)?(?<a>x)?(?(a)1|2)`, `(?<5>x)?(?(5)y|n)`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range []string{"xyes", "nox", "noxyesx", "yesx", "yx1", "y1", "y2", "x1", "xy", "n", "xn"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestDot_Singleline(t *testing.T) {
+	// . is anything but \n unless Singleline, where it's anything, alone and in loops
+	code := generateCode(t, `a.b`, 0)
+	if !strings.Contains(code, "slice[1] == '\\n' || /* Match any character other than '\\n'. */") {
+		t.Errorf("expected . to exclude \\n")
+	}
+	if code := generateCode(t, `a.b`, syntax.Singleline); strings.Contains(code, "'\\n'") {
+		t.Errorf("unexpected \\n check for Singleline .")
+	}
+
+	for _, pattern := range []string{`a.b`, `a.+b`, `a.*?b`, `a.{1}b`, `a(?>.+)`, `a(?:.|x)b`} {
+		exec := generateAndCompile(t, pattern, 0)
+		runNoMatch(t, pattern, exec, "a\\x0ab")
+		runMatch(t, pattern, exec, "a\\x0aazb", " 0: azb")
+
+		exec = generateAndCompile(t, pattern, syntax.Singleline)
+		runMatch(t, pattern, exec, "a\\x0ab", ` 0: a\x0ab`)
+	}
+}
+
+func TestLabels_Scope(t *testing.T) {
+	inputs := []string{"xab", "xaabd", "xcd", "d", "xyzd", "abce", "bce", "de", "aab", "xaaab", "zz"}
+	for _, pattern := range []string{
+		// the no branch can't backtrack into the yes branch's loop, which is inside the if block
+		`(?>(x)?(?(1)a+?b|c))d`,
+		// branches and iterations that fail jump past the locals of the nodes after them
+		`(?:(?:x?)*?y|z)d`,
+		`(?:(?(?=a)ab|c)|d)e`,
+		`(a)?(?:(?(1)b|c)+|z)`,
+		`(?:x|(?(?=a)a+|b))+c`,
+	} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+}
+
+func TestEmptyMatch_FindNext(t *testing.T) {
+	// an empty match ends where it starts, the next search has to bump past it
+	tests := []struct {
+		pattern string
+		opts    syntax.RegexOptions
+		input   string
+		want    string
+	}{
+		{`a*`, 0, "bbb", "0: \n1: \n2: \n3: \n"},
+		{`a*`, 0, "baab", "0: \n1: aa\n3: \n4: \n"},
+		{``, 0, "ab", "0: \n1: \n2: \n"},
+		{`(?:)`, 0, "a", "0: \n1: \n"},
+		{`a*b?`, 0, "xab", "0: \n1: ab\n3: \n"},
+		{`a*`, syntax.RightToLeft, "bab", "3: \n1: a\n1: \n0: \n"},
+	}
+
+	for _, test := range tests {
+		exec := generateAndCompileMain(t, "_runallmain.go", test.pattern, test.opts, Options{})
+		if out := matchString(t, test.pattern, exec, test.input); out != test.want {
+			t.Errorf("pattern %v input %v: expected %q, got %q", test.pattern, test.input, test.want, out)
+		}
+	}
+}
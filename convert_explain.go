@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The explain engine is registered under the pattern with this comment in front, so it
+// gets its own Regexp without colliding with the real engine for the pattern.
+func explainPattern(rm *regexpData) string {
+	return "(?#explain)" + rm.Pattern
+}
+
+// Emits ExplainMatch along with a copy of the engine whose Execute records a trace as it goes.
+func (c *converter) emitExplainMatch(rm *regexpData) error {
+	oldOut := c.buf
+	c.buf = &bytes.Buffer{}
+	rm.emittedLabels, rm.usedLabels, rm.switchCaseLabels = nil, nil, nil
+
+	c.writeLineFmt(`// Runs the match for %[1]s_Engine.ExplainMatch, recording what Execute does
+		type %[1]s_explainEngine struct {
+			%[1]s_Engine
+			mu    sync.Mutex
+			trace strings.Builder
+		}
+
+		func (e *%[1]s_explainEngine) tracef(format string, args ...any) {
+			fmt.Fprintf(&e.trace, format+"\n", args...)
+		}
+
+		var %[1]s_explain = &%[1]s_explainEngine{}
+
+		// ExplainMatch finds the first match of the pattern in s and returns a trace of how it got
+		// there: each position tried, the alternation branches taken, backtracking and the groups.
+		func (%[1]s_Engine) ExplainMatch(s string) string {
+			e := %[1]s_explain
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.trace.Reset()
+
+			m, err := regexp2.MustCompile(%[2]s, %[3]s).FindStringMatch(s)
+			if err != nil {
+				e.tracef("error: %%v", err)
+			} else if m == nil {
+				e.tracef("no match found")
+			} else {
+				for _, g := range m.Groups() {
+					if len(g.Captures) == 0 {
+						e.tracef("group %%s: <unset>", g.Name)
+					} else {
+						e.tracef("group %%s: %%q", g.Name, g.String())
+					}
+				}
+			}
+			return e.trace.String()
+		}
+		`, rm.GeneratedName, getGoLiteral(explainPattern(rm)), getOptString(rm.Options))
+
+	rm.explain = true
+	c.emitExecute(rm)
+	rm.explain = false
+
+	output := c.buf.String()
+	c.buf = oldOut
+	removeUnusedLabels(&output, rm)
+	if err := verify(rm, output); err != nil {
+		return err
+	}
+	c.buf.Write([]byte(output))
+	return nil
+}
+
+// Emits a line of the ExplainMatch trace when emitting the explain engine; args are Go expressions
+// for the format verbs.
+func (c *converter) emitExplainTrace(rm *regexpData, format string, args ...string) {
+	if !rm.explain {
+		return
+	}
+	if len(args) == 0 {
+		c.writeLineFmt("e.tracef(%q)", format)
+		return
+	}
+	c.writeLineFmt("e.tracef(%q, %s)", format, strings.Join(args, ", "))
+}
+
+// the Go expression for the current position, including any static offset into the slice
+func staticPosExpr(rm *regexpData) string {
+	if rm.sliceStaticPos == 0 {
+		return rm.pos
+	}
+	return fmt.Sprintf("%s+%d", rm.pos, rm.sliceStaticPos)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExplainMatch_Alternation(t *testing.T) {
+	// the parser turns a|b into the set [ab], so there aren't branches to trace
+	pattern := `a|b`
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ExplainMatch: true})
+	runMatch(t, pattern, exec, "b", "try at 0")
+	runMatch(t, pattern, exec, "b", `group 0: "b"`)
+
+	pattern = `ab|cd`
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ExplainMatch: true})
+	runMatch(t, pattern, exec, "cd", "branch 0 at 0")
+	runMatch(t, pattern, exec, "cd", "branch 1 matched, at 2")
+	runMatch(t, pattern, exec, "cd", "matched [0, 2)")
+	runMatch(t, pattern, exec, "xy", "no match found")
+}
+
+func TestExplainMatch_Backtracking(t *testing.T) {
+	pattern := `(a+)(ab|c)`
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ExplainMatch: true})
+	runMatch(t, pattern, exec, "aaab", "group 1 = [0, 3)")
+	runMatch(t, pattern, exec, "aaab", "backtrack to CharLoopBacktrack at 3")
+	runMatch(t, pattern, exec, "aaab", "group 1 = [0, 2)")
+	runMatch(t, pattern, exec, "aaab", `group 1: "aa"`)
+	runMatch(t, pattern, exec, "aaab", `group 2: "ab"`)
+}
@@ -4,13 +4,28 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"unicode"
 
 	"github.com/dlclark/regexp2/syntax"
 )
 
+// Emits FindFirstChar, the port of .NET's TryFindNextPossibleStartingPosition: it moves
+// r.Runtextpos to the next place a match could start, per the tree's FindOptimizations, and
+// reports false if there isn't one so the scan loop stops without calling Execute.
 func (c *converter) emitFindFirstChar(rm *regexpData) {
-	c.writeLineFmt("func (%s_Engine) FindFirstChar(r *regexp2.Runner) bool {", rm.GeneratedName)
+	if c.opts.RecoverPanics {
+		c.writeLineFmt("func (%s_Engine) FindFirstChar(r *regexp2.Runner) (candidate bool) {", rm.GeneratedName)
+		// FindFirstChar can't return an error, so it reports a candidate for Execute to return it.
+		store, take := c.emitFindFirstCharPanics(rm)
+		c.emitRecoverPanic(fmt.Sprintf("%s(r, p)\ncandidate = true", store))
+		// drop a panic left by the last call on this runner, whose Execute call never came
+		// because the match timed out in between
+		c.writeLineFmt("%s(r)", take)
+	} else {
+		c.writeLineFmt("func (%s_Engine) FindFirstChar(r *regexp2.Runner) bool {", rm.GeneratedName)
+	}
 	//c.writeLine(`fmt.Println("FindFirstChar")`)
 	defer func() {
 		c.writeLine("}\n")
@@ -39,7 +54,7 @@ func (c *converter) emitFindFirstChar(rm *regexpData) {
 		c.buf = oldOut
 
 		if needPosVar {
-			c.writeLine("pos := r.Runtextpos")
+			c.writeLineFmt("%s := r.Runtextpos", rm.pos)
 		}
 
 		// write additionalDeclarations
@@ -63,16 +78,16 @@ func (c *converter) emitFindFirstChar(rm *regexpData) {
 		if minRequiredLength == 1 {
 			c.writeLine("// Empty matches aren't possible")
 			if !rtl {
-				c.writeLine("if pos < len(r.Runtext) {")
+				c.writeLineFmt("if %s < len(r.Runtext) {", rm.pos)
 			} else {
-				c.writeLine("if pos > 1 {")
+				c.writeLineFmt("if %s > 0 {", rm.pos)
 			}
 		} else {
 			c.writeLineFmt("// Any possible match is at least %v characters", minRequiredLength)
 			if !rtl {
-				c.writeLineFmt("if pos <= len(r.Runtext) - %v {", minRequiredLength)
+				c.writeLineFmt("if %s <= len(r.Runtext) - %v {", rm.pos, minRequiredLength)
 			} else {
-				c.writeLineFmt("if pos >= %v {", minRequiredLength)
+				c.writeLineFmt("if %s >= %v {", rm.pos, minRequiredLength)
 			}
 		}
 		endBlock = "}"
@@ -83,6 +98,10 @@ func (c *converter) emitFindFirstChar(rm *regexpData) {
 	if !c.emitAnchors(rm) {
 		// Either anchors weren't specified, or they don't completely root all matches to a specific location.
 
+		if c.opts.InteriorLiteralSearch {
+			c.emitInteriorLiteralCheck(rm)
+		}
+
 		// Emit the code for whatever find mode has been determined.
 		switch rm.Tree.FindOptimizations.FindMode {
 		case syntax.LeadingString_LeftToRight, syntax.LeadingString_OrdinalIgnoreCase_LeftToRight, syntax.FixedDistanceString_LeftToRight:
@@ -136,13 +155,12 @@ func (c *converter) emitAnchors(rm *regexpData) bool {
 		c.writeLine("// The pattern leads with a beginning (\\A) anchor.")
 		// If we're at the beginning, we're at a possible match location.  Otherwise,
 		// we'll never be, so fail immediately.
-		c.writeLine(`if pos == 0 {
+		c.writeLineFmt(`if %s == 0 {
 			return true
-		}`)
+		}`, rm.pos)
 		return true
 
-	case syntax.LeadingAnchor_LeftToRight_Start:
-	case syntax.LeadingAnchor_RightToLeft_Start:
+	case syntax.LeadingAnchor_LeftToRight_Start, syntax.LeadingAnchor_RightToLeft_Start:
 		c.write("// The pattern leads with a start (\\G) anchor")
 		if regexTree.FindOptimizations.FindMode == syntax.LeadingAnchor_RightToLeft_Start {
 			c.write(" when processed right to left")
@@ -151,86 +169,86 @@ func (c *converter) emitAnchors(rm *regexpData) bool {
 		// For both left-to-right and right-to-left, if we're  currently at the start,
 		// we're at a possible match location.  Otherwise, because we've already moved
 		// beyond it, we'll never be, so fail immediately.
-		c.writeLine(`
-			if (pos == r.Runtextstart) {
+		c.writeLineFmt(`
+			if (%s == r.Runtextstart) {
 				return true
 			}
-		`)
+		`, rm.pos)
 		return true
 
 	case syntax.LeadingAnchor_LeftToRight_EndZ:
 		// If we're not currently at the end (or a newline just before it), skip ahead
 		// since nothing until then can possibly match.
-		c.writeLine(`// The pattern leads with an end (\Z) anchor.
-		if pos < len(r.Runtext) - 1 {
+		c.writeLineFmt(`// The pattern leads with an end (\Z) anchor.
+		if %s < len(r.Runtext) - 1 {
 			r.Runtextpos = len(r.Runtext) - 1
 		}
 		return true
-		`)
+		`, rm.pos)
 		rm.findEndsInAlwaysReturningTrue = true
 		return true
 
 	case syntax.LeadingAnchor_LeftToRight_End:
 		// If we're not currently at the end (or a newline just before it), skip ahead
 		// since nothing until then can possibly match.
-		c.writeLine(`// The pattern leads with an end (\z) anchor.
-		if pos < len(r.Runtext) {
+		c.writeLineFmt(`// The pattern leads with an end (\z) anchor.
+		if %s < len(r.Runtext) {
 			r.Runtextpos = len(r.Runtext)
 		}
 		return true
-		`)
+		`, rm.pos)
 		rm.findEndsInAlwaysReturningTrue = true
 		return true
 
 	case syntax.LeadingAnchor_RightToLeft_Beginning:
-		c.writeLine(`// The pattern leads with a beginning (\A) anchor when processed right to left.
-		if pos != 0 {
+		c.writeLineFmt(`// The pattern leads with a beginning (\A) anchor when processed right to left.
+		if %s != 0 {
 			r.Runtextpos = 0
 		}
 		return true
-		`)
+		`, rm.pos)
 		rm.findEndsInAlwaysReturningTrue = true
 		return true
 
 	case syntax.LeadingAnchor_RightToLeft_EndZ:
 		// If we're currently at the end, we're at a valid position to try.  Otherwise,
 		// we'll never be (we're iterating from end to beginning), so fail immediately.
-		c.writeLine(`// The pattern leads with an end (\Z) anchor when processed right to left.
-		if pos >= len(r.Runtext) - 1 && (pos >= len(r.Runtext) || r.Runtext[pos] == '\n') {
+		c.writeLineFmt(`// The pattern leads with an end (\Z) anchor when processed right to left.
+		if %[1]s >= len(r.Runtext) - 1 && (%[1]s >= len(r.Runtext) || r.Runtext[%[1]s] == '\n') {
 			return true
 		}
-		`)
+		`, rm.pos)
 		return true
 
 	case syntax.LeadingAnchor_RightToLeft_End:
 		// If we're currently at the end, we're at a valid position to try.  Otherwise,
 		// we'll never be (we're iterating from end to beginning), so fail immediately.
-		c.writeLine(`// The pattern leads with an end (\z) anchor when processed right to left.
-		if pos >= len(r.Runtext) {
+		c.writeLineFmt(`// The pattern leads with an end (\z) anchor when processed right to left.
+		if %s >= len(r.Runtext) {
 			return true
 		}
-		`)
+		`, rm.pos)
 		return true
 
 	case syntax.TrailingAnchor_FixedLength_LeftToRight_EndZ:
 		// Jump to the end, minus the min required length, which in this case is actually the fixed length, minus 1 (for a possible ending \n).
 		c.writeLineFmt(`// The pattern has a trailing end (\Z) anchor, and any possible match is exactly %v characters.
-		if pos < len(r.Runtext) - %v {
-			r.Runtextpos = len(r.Runtext) - %[2]v
+		if %s < len(r.Runtext) - %v {
+			r.Runtextpos = len(r.Runtext) - %[3]v
 		}
 		return true
-		`, regexTree.FindOptimizations.MinRequiredLength, regexTree.FindOptimizations.MinRequiredLength+1)
+		`, regexTree.FindOptimizations.MinRequiredLength, rm.pos, regexTree.FindOptimizations.MinRequiredLength+1)
 		rm.findEndsInAlwaysReturningTrue = true
 		return true
 
 	case syntax.TrailingAnchor_FixedLength_LeftToRight_End:
 		// Jump to the end, minus the min required length, which in this case is actually the fixed length.
 		c.writeLineFmt(`// The pattern has a trailing end (\z) anchor, and any possible match is exactly %v characters.
-		if pos < len(r.Runtext) - %[1]v {
+		if %[2]s < len(r.Runtext) - %[1]v {
 			r.Runtextpos = len(r.Runtext) - %[1]v
 		}
 		return true
-		`, regexTree.FindOptimizations.MinRequiredLength)
+		`, regexTree.FindOptimizations.MinRequiredLength, rm.pos)
 		rm.findEndsInAlwaysReturningTrue = true
 		return true
 	}
@@ -251,18 +269,18 @@ func (c *converter) emitAnchors(rm *regexpData) bool {
 		// the other anchors, which all skip all subsequent processing if found, with BOL we just use it
 		// to boost our position to the next line, and then continue normally with any searches.
 		c.writeLineFmt(`// The pattern has a leading beginning-of-line anchor.
-			if pos > 0 && r.Runtext[pos-1] != '\n' {
-				newlinePos := helpers.IndexOfAny1(r.Runtext[pos:], '\n')
-				if newlinePos > len(r.Runtext) - pos - 1 {
+			if %[4]s > 0 && r.Runtext[%[4]s-1] != '\n' {
+				newlinePos := %[1]s(r.Runtext[%[4]s:], '\n')
+				if newlinePos > len(r.Runtext) - %[4]s - 1 {
 					goto NoMatchFound
 				}
-				pos += newlinePos + 1
+				%[4]s += newlinePos + 1
 
-				if pos %v len(r.Runtext)%v {
+				if %[4]s %[2]v len(r.Runtext)%[3]v {
 					goto NoMatchFound
 				}
 			}
-			`, str1, str2)
+			`, c.helper("IndexOfAny1"), str1, str2, rm.pos)
 		rm.noMatchFoundLabelNeeded = true
 	}
 
@@ -270,16 +288,16 @@ func (c *converter) emitAnchors(rm *regexpData) bool {
 	if regexTree.FindOptimizations.MaxPossibleLength > -1 {
 		if regexTree.FindOptimizations.TrailingAnchor == syntax.NtEnd {
 			c.writeLineFmt(`// The pattern has a trailing end (\z) anchor, and any possible match is no more than %v characters.
-			if pos < len(r.Runtext) - %[1]v {
-				pos = len(r.Runtext) - %[1]v
+			if %[2]s < len(r.Runtext) - %[1]v {
+				%[2]s = len(r.Runtext) - %[1]v
 			}
-			`, regexTree.FindOptimizations.MaxPossibleLength)
+			`, regexTree.FindOptimizations.MaxPossibleLength, rm.pos)
 		} else if regexTree.FindOptimizations.TrailingAnchor == syntax.NtEndZ {
 			c.writeLineFmt(`// The pattern has a trailing end (\Z) anchor, and any possible match is no more than %v characters.
-			if pos < len(r.Runtext) - %[1]v {
-				pos = len(r.Runtext) - %[1]v
+			if %[2]s < len(r.Runtext) - %[1]v {
+				%[2]s = len(r.Runtext) - %[1]v
 			}
-			`, regexTree.FindOptimizations.MaxPossibleLength+1)
+			`, regexTree.FindOptimizations.MaxPossibleLength+1, rm.pos)
 		}
 
 	}
@@ -287,6 +305,53 @@ func (c *converter) emitAnchors(rm *regexpData) bool {
 	return false
 }
 
+// Emits a check that a literal required somewhere after the start of the pattern occurs in the input at all.
+// The check only runs when a scan starts, so it costs one pass over the input per scan rather than one per
+// possible starting position, and lets inputs that can't contain a match be skipped entirely.
+func (c *converter) emitInteriorLiteralCheck(rm *regexpData) {
+	switch rm.Tree.FindOptimizations.FindMode {
+	case syntax.LeadingSet_LeftToRight, syntax.FixedDistanceSets_LeftToRight, syntax.NoSearch:
+	default:
+		// the other modes are already searching for a literal or are right-to-left
+		return
+	}
+
+	literal := findInteriorLiteral(rm.Tree.Root.Children[0])
+	if literal == nil {
+		return
+	}
+
+	c.writeLineFmt(`// The pattern requires the literal %#v. If it doesn't occur in the input there's no match.
+		if %[4]s == r.Runtextstart && %[2]s(r.Runtext[%[4]s:], %[3]s) < 0 {
+			goto NoMatchFound
+		}
+		`, string(literal), c.helper("IndexOf"), getRuneSliceLiteral(literal), rm.pos)
+	rm.noMatchFoundLabelNeeded = true
+}
+
+// Finds the longest literal string that any match must contain after its start.
+// Only the top-level concatenation (looking through captures and atomics) is considered.
+func findInteriorLiteral(node *syntax.RegexNode) []rune {
+	for node.T == syntax.NtCapture || node.T == syntax.NtAtomic {
+		node = node.Children[0]
+	}
+	if node.T != syntax.NtConcatenate || node.Options&syntax.RightToLeft != 0 {
+		return nil
+	}
+
+	var best []rune
+	for i := 1; i < len(node.Children); i++ {
+		child := node.Children[i]
+		for child.T == syntax.NtCapture || child.T == syntax.NtAtomic {
+			child = child.Children[0]
+		}
+		if child.T == syntax.NtMulti && len(child.Str) > len(best) {
+			best = child.Str
+		}
+	}
+	return best
+}
+
 // Emits a case-sensitive left-to-right search for a substring.
 func (c *converter) emitIndexOfString_LeftToRight(rm *regexpData) {
 	opts := rm.Tree.FindOptimizations
@@ -329,12 +394,133 @@ func (c *converter) emitIndexOfString_LeftToRight(rm *regexpData) {
 				[]rune(substring), fieldName, ignoreCase)
 		}*/
 
+	if c.opts.ChunkedPrefixScan && stringComparison == "" && len(substring) >= chunkedScanMinLength && isAscii([]rune(substring)) {
+		c.writeLineFmt(`// The pattern has the literal %#v %v. Find the next occurrence,
+		// checking %v positions at a time. If it can't be found, there's no match
+		if i := %[4]s(r.Runtext[%[7]s%[5]v:], %[6]s); i >= 0 {
+			r.Runtextpos = %[7]s + i
+			return true
+		}`, substring, offsetDescription, chunkedScanWidth, c.emitIndexOfChunkedHelper(), offset, getRuneSliceLiteral(substring), rm.pos)
+		return
+	}
+
+	if c.opts.HorspoolPrefixScan && stringComparison == "" && len(substring) >= horspoolMinLength && isAscii([]rune(substring)) {
+		c.writeLineFmt(`// The pattern has the literal %#v %v. Find the next occurrence,
+		// skipping ahead by the char under the literal's last char. If it can't be found, there's no match
+		if i := %[3]s(r.Runtext[%[7]s%[4]v:], %[5]s, &%[6]s); i >= 0 {
+			r.Runtextpos = %[7]s + i
+			return true
+		}`, substring, offsetDescription, c.emitIndexOfHorspoolHelper(), offset, getRuneSliceLiteral(substring), c.emitHorspoolTable(substring), rm.pos)
+		return
+	}
+
 	c.writeLineFmt(`// The pattern has the literal %#v %v. Find the next occurrence.
 	// If it can't be found, there's no match
-	if i := helpers.IndexOf%v(r.Runtext[pos%v:], %s); i >= 0 {
-		r.Runtextpos = pos + i
+	if i := %[3]s(r.Runtext[%[6]s%[4]v:], %[5]s); i >= 0 {
+		r.Runtextpos = %[6]s + i
 		return true
-	}`, substring, offsetDescription, stringComparison, offset, getRuneSliceLiteral(substring))
+	}`, substring, offsetDescription, c.helper("IndexOf"+stringComparison), offset, getRuneSliceLiteral(substring), rm.pos)
+}
+
+// Literals at least this long are searched for with indexOfChunked when the option is set,
+// shorter ones don't have first and last chars far enough apart to filter much.
+const chunkedScanMinLength = 4
+
+// the number of positions indexOfChunked checks in each block
+const chunkedScanWidth = 8
+
+// Emits a helper that searches for a literal a block of positions at a time. Each block compares
+// the literal's first and last chars at every position without branching between them, which keeps
+// the loop free of the data-dependent branches in helpers.IndexOf and rules out most positions
+// before comparing the whole literal.
+func (c *converter) emitIndexOfChunkedHelper() string {
+	const name = "indexOfChunked"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		buf := &bytes.Buffer{}
+		for k := 0; k < chunkedScanWidth; k++ {
+			fmt.Fprintf(buf, "if (block[%[1]v] == first) && (tail[%[1]v] == last) {\nm |= 1 << %[1]v\n}\n", k)
+		}
+		c.requiredHelpers[name] = fmt.Sprintf(`// Finds the first index of lit in s, or -1. Checks blocks of %[1]v positions for lit's first
+		// and last chars before comparing the whole literal.
+		func indexOfChunked(s []rune, lit []rune) int {
+			first, last := lit[0], lit[len(lit)-1]
+			i := 0
+			for ; i+len(lit)-1+%[1]v <= len(s); i += %[1]v {
+				block := s[i : i+%[1]v : i+%[1]v]
+				tail := s[i+len(lit)-1 : i+len(lit)-1+%[1]v : i+len(lit)-1+%[1]v]
+				var m uint
+				%[2]s
+				for k := 0; m != 0; k, m = k+1, m>>1 {
+					if m&1 != 0 && %[3]s(s[i+k:], lit) {
+						return i + k
+					}
+				}
+			}
+			// the remainder is shorter than a block
+			if j := %[4]s(s[i:], lit); j >= 0 {
+				return i + j
+			}
+			return -1
+		}`, chunkedScanWidth, buf.String(), c.helper("StartsWith"), c.helper("IndexOf"))
+	}
+	return name
+}
+
+// Literals at least this long are searched for with indexOfHorspool when the option is set. The
+// skips are at most the literal's length, so shorter ones are left to helpers.IndexOf.
+const horspoolMinLength = 4
+
+// Emits the skip table for an ASCII literal's Horspool search and returns its name. An entry is one
+// past the last index of the char in the literal, not counting the last char, so the search moves
+// on by the literal's length less the entry, and chars that aren't in the literal are 0.
+func (c *converter) emitHorspoolTable(lit string) string {
+	name := fmt.Sprint("horspoolSkip_", getSHA256FieldName(lit))
+	if _, ok := c.requiredHelpers[name]; !ok {
+		last := make(map[rune]int)
+		for i, ch := range lit[:len(lit)-1] {
+			last[ch] = i + 1
+		}
+		chars := make([]rune, 0, len(last))
+		for ch := range last {
+			chars = append(chars, ch)
+		}
+		slices.Sort(chars)
+		entries := make([]string, len(chars))
+		for i, ch := range chars {
+			entries[i] = fmt.Sprintf("%q: %v", ch, last[ch])
+		}
+		c.requiredHelpers[name] = fmt.Sprintf(`// The skip table for searching for %#v with indexOfHorspool
+		var %s = [128]int{%s}`, lit, name, strings.Join(entries, ", "))
+	}
+	return name
+}
+
+// Emits a helper that searches for an ASCII literal with Boyer-Moore-Horspool: each position checks
+// the char under the literal's last char, and the skip table says how far along the next position
+// that could line that char up with the literal is.
+func (c *converter) emitIndexOfHorspoolHelper() string {
+	const name = "indexOfHorspool"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = fmt.Sprintf(`// Finds the first index of the ASCII literal lit in s, or -1, using lit's skip table
+		func indexOfHorspool(s []rune, lit []rune, skip *[128]int) int {
+			n := len(lit)
+			last := lit[n-1]
+			for i := 0; i+n <= len(s); {
+				ch := s[i+n-1]
+				if ch == last && %s(s[i:], lit) {
+					return i
+				}
+				if uint32(ch) < 128 {
+					i += n - skip[ch]
+				} else {
+					// not in lit, the whole literal can move past it
+					i += n
+				}
+			}
+			return -1
+		}`, c.helper("StartsWith"))
+	}
+	return name
 }
 
 // Emits a case-sensitive right-to-left search for a substring.
@@ -343,12 +529,12 @@ func (c *converter) emitIndexOfString_RightToLeft(rm *regexpData) {
 
 	c.writeLineFmt(`// The pattern begins with a literal %#[1]v. Find the next occurrence right-to-left.
 	// If it can't be found, there's no match.
-	pos = r.LastIndexOf(r.Runtext, pos, []rune(%#[1]v))
-	if pos >= 0 {
-		r.Runtextpos = pos + %[2]v
+	%[4]s = %[3]s(r.Runtext[:%[4]s], []rune(%#[1]v))
+	if %[4]s >= 0 {
+		r.Runtextpos = %[4]s + %[2]v
 		return true
 	}
-	`, prefix, len(prefix))
+	`, prefix, len(prefix), c.helper("LastIndexOf"), rm.pos)
 }
 
 func getRuneSliceSliceLiteral(vals []string) string {
@@ -380,16 +566,16 @@ func (c *converter) emitIndexOfStrings_LeftToRight(rm *regexpData) {
 	if _, ok := c.requiredHelpers[fieldName]; !ok {
 		// explicitly using an array in case prefixes is large
 		c.requiredHelpers[fieldName] = fmt.Sprintf(`// Supports searching for the specified strings
-		var %v = helpers.NewStringSearchValues(%s, %v)`,
-			fieldName, prefixes, ignoreCase)
+		var %v = %s(%s, %v)`,
+			fieldName, c.helper("NewStringSearchValues"), prefixes, ignoreCase)
 	}
 
 	c.writeLineFmt(`// The pattern has multiple strings that could begin the match. Search for any of them.
 	// If none can be found, there's no match
-	if i := %v.IndexOfAny(r.Runtext[pos:]); i >= 0 {
-		r.Runtextpos = pos + i
+	if i := %[1]v.IndexOfAny(r.Runtext[%[2]s:]); i >= 0 {
+		r.Runtextpos = %[2]s + i
 		return true
-	}`, fieldName)
+	}`, fieldName, rm.pos)
 }
 
 func (c *converter) emitSetDefinition(set *syntax.CharSet) string {
@@ -439,7 +625,7 @@ func (c *converter) emitFixedSet_LeftToRight(rm *regexpData) {
 
 	endBlock := ""
 	if needLoop {
-		c.writeLine("span := r.Runtext[pos:]")
+		c.writeLineFmt("span := r.Runtext[%s:]", rm.pos)
 		upperBound := "len(span)"
 		if setsToUse > 1 || primarySet.Distance != 0 {
 			upperBound = fmt.Sprint(upperBound, " - ", rm.Tree.FindOptimizations.MinRequiredLength-1)
@@ -458,32 +644,42 @@ func (c *converter) emitFixedSet_LeftToRight(rm *regexpData) {
 			}
 		} else {
 			if primarySet.Distance == 0 {
-				span = "r.Runtext[pos:]"
+				span = fmt.Sprintf("r.Runtext[%s:]", rm.pos)
 			} else {
-				span = fmt.Sprint("r.Runtext[pos+", primarySet.Distance, ":]")
+				span = fmt.Sprintf("r.Runtext[%s+%v:]", rm.pos, primarySet.Distance)
 			}
 		}
 
 		// Get the IndexOf* expression to use to perform the search.
 		var indexOf string
 
-		if len(primarySet.Chars) > 0 {
-			indexOf = c.emitIndexOfChars(primarySet.Chars, primarySet.Negated, span)
+		if c.opts.LeadingSetTable && primarySet.Set.Analyze().ContainsOnlyAscii {
+			// search with the set's lookup table, which is shared with everything else matching the set
+			table := c.emitAsciiBitmapDefinition(getAsciiBitVector(primarySet.Set))
+			indexOf = fmt.Sprintf("%s(%s, &%s)", c.emitIndexOfAnyInTableHelper(), span, table)
+
+		} else if rs := primarySet.Set.GetIfNRanges(2); len(primarySet.Chars) > 3 && len(rs) == 2 {
+			// two ranges, e.g. [A-Za-z], are cheaper to compare against than a lookup in the chars,
+			// the same as in tryEmitExecuteIndexOf
+			indexOf = fmt.Sprintf("%s(%s, %q, %q, %q, %q)", c.emitIndexOfAnyInRangesHelper(primarySet.Negated, false), span, rs[0].First, rs[0].Last, rs[1].First, rs[1].Last)
+
+		} else if len(primarySet.Chars) > 0 {
+			indexOf = c.emitIndexOfChars(primarySet.Chars, primarySet.Negated, false, span)
 
 		} else if primarySet.Range != nil {
 			// We have a range, so we can use IndexOfAny{Except}InRange to search for it.  In the corner case,
 			// where we end up with a set of a single char, we can use IndexOf instead.
 			if primarySet.Range.First == primarySet.Range.Last {
 				if primarySet.Negated {
-					indexOf = fmt.Sprintf("helpers.IndexOfAnyExcept(%v, %q)", span, primarySet.Range.First)
+					indexOf = fmt.Sprintf("%s(%v, %q)", c.helper("IndexOfAnyExcept"), span, primarySet.Range.First)
 				} else {
-					indexOf = fmt.Sprintf("helpers.IndexOfAny1(%v, %q)", span, primarySet.Range.First)
+					indexOf = fmt.Sprintf("%s(%v, %q)", c.helper("IndexOfAny1"), span, primarySet.Range.First)
 				}
 			} else {
 				if primarySet.Negated {
-					indexOf = fmt.Sprintf("helpers.IndexOfAnyExceptInRange(%v, %q, %q)", span, primarySet.Range.First, primarySet.Range.Last)
+					indexOf = fmt.Sprintf("%s(%v, %q, %q)", c.helper("IndexOfAnyExceptInRange"), span, primarySet.Range.First, primarySet.Range.Last)
 				} else {
-					indexOf = fmt.Sprintf("helpers.IndexOfAnyInRange(%v, %q, %q)", span, primarySet.Range.First, primarySet.Range.Last)
+					indexOf = fmt.Sprintf("%s(%v, %q, %q)", c.helper("IndexOfAnyInRange"), span, primarySet.Range.First, primarySet.Range.Last)
 				}
 			}
 		} else if isSmall, setChars, negated, desc := primarySet.Set.IsUnicodeCategoryOfSmallCharCount(); isSmall {
@@ -519,28 +715,27 @@ func (c *converter) emitFixedSet_LeftToRight(rm *regexpData) {
 					if sets[i].Distance > maxDistance {
 						maxDistance = sets[i].Distance
 					}
+				}
 
-					if maxDistance > primarySet.Distance {
-						numRemainingSets := setsToUse - 1
-						c.writeLineFmt(`// The primary set being searched for was found. %v more set(s) will be checked so as
-								 // to minimize the number of places TryMatchAtCurrentPosition is run unnecessarily.
-								 // Make sure everything fits in the remainder of the input.
-								 if i+%v >= len(span) {
-									goto NoMatchFound
-								 }
-								 `, numRemainingSets, maxDistance)
-						rm.noMatchFoundLabelNeeded = true
-
-					}
+				if maxDistance > primarySet.Distance {
+					numRemainingSets := setsToUse - 1
+					c.writeLineFmt(`// The primary set being searched for was found. %v more set(s) will be checked so as
+							 // to minimize the number of places TryMatchAtCurrentPosition is run unnecessarily.
+							 // Make sure everything fits in the remainder of the input.
+							 if i+%v >= len(span) {
+								goto NoMatchFound
+							 }
+							 `, numRemainingSets, maxDistance)
+					rm.noMatchFoundLabelNeeded = true
 				}
 			}
 		} else {
 			c.writeLineFmt(`i := %v
 						if i >= 0 {
-							r.Runtextpos = pos + i
+							r.Runtextpos = %s + i
 							return true
 						}
-						`, indexOf)
+						`, indexOf, rm.pos)
 		}
 
 		setIndex = 1
@@ -574,8 +769,8 @@ func (c *converter) emitFixedSet_LeftToRight(rm *regexpData) {
 			c.writeLine(` {`)
 			endBlock2 = "}"
 		}
-		c.writeLine(`r.Runtextpos = pos + i
-						return true`)
+		c.writeLineFmt(`r.Runtextpos = %s + i
+						return true`, rm.pos)
 		c.writeLine(endBlock2)
 	}
 
@@ -592,18 +787,18 @@ func (c *converter) emitFixedSet_RightToLeft(rm *regexpData) {
 	// Find the next occurrence. If it can't be found, there's no match.`, set.Set.String())
 
 	if len(set.Chars) == 1 {
-		c.writeLineFmt(`pos = r.LastIndexOfRune(0, pos, %q)
-		if pos >= 0 {
-			r.Runtextpos = pos + 1
+		c.writeLineFmt(`%[2]s = r.LastIndexOfRune(0, %[2]s, %[1]q)
+		if %[2]s >= 0 {
+			r.Runtextpos = %[2]s + 1
 			return true
-		}`, set.Chars[0])
+		}`, set.Chars[0], rm.pos)
 	} else {
-		c.writeLineFmt(`for pos--; pos < len(r.Runtext); pos-- {
-			if %v {
-				r.Runtextpos = pos + 1
+		c.writeLineFmt(`for %[2]s--; %[2]s >= 0; %[2]s-- {
+			if %[1]v {
+				r.Runtextpos = %[2]s + 1
 				return true
 			}
-		}`, c.emitMatchCharacterClass(rm, set.Set, false, "r.Runtext[pos]"))
+		}`, c.emitMatchCharacterClass(rm, set.Set, false, fmt.Sprintf("r.Runtext[%s]", rm.pos)), rm.pos)
 	}
 }
 
@@ -639,17 +834,17 @@ func (c *converter) emitLiteralAfterAtomicLoop(rm *regexpData) {
 		endBlock = "}"
 	}
 
-	c.writeLine("slice := r.Runtext[pos:]\n")
+	c.writeLineFmt("slice := r.Runtext[%s:]\n", rm.pos)
 	// Find the literal.  If we can't find it, we're done searching.
 	if len(target.String) > 0 {
 		// find string
-		c.writeLineFmt("i := helpers.IndexOf(slice, %s)", getRuneSliceLiteral(target.String))
+		c.writeLineFmt("i := %s(slice, %s)", c.helper("IndexOf"), getRuneSliceLiteral(target.String))
 	} else if len(target.Chars) > 0 {
 		// find char any
-		c.writeLineFmt("i := %v", c.emitIndexOfChars(target.Chars, false, "slice"))
+		c.writeLineFmt("i := %v", c.emitIndexOfChars(target.Chars, false, false, "slice"))
 	} else {
 		// find char any
-		c.writeLineFmt("i := %v", c.emitIndexOfChars([]rune{target.Char}, false, "slice"))
+		c.writeLineFmt("i := %v", c.emitIndexOfChars([]rune{target.Char}, false, false, "slice"))
 	}
 
 	endBlock2 := ""
@@ -674,18 +869,18 @@ func (c *converter) emitLiteralAfterAtomicLoop(rm *regexpData) {
 		// If we found fewer than needed, loop around to try again.  The loop doesn't overlap with the literal,
 		// so we can start from after the last place the literal matched.
 		c.writeLineFmt(`if (i - prev - 1) < %v {
-				pos += i + 1
+				%s += i + 1
 				continue
 			}
-			`, target.LoopNode.M)
+			`, target.LoopNode.M, rm.pos)
 	}
 
 	// We have a winner.  The starting position is just after the last position that failed to match the loop.
 	// We also store the position after the loop into runtrackpos (an extra, unused field on RegexRunner) in order
 	// to communicate this position to the match algorithm such that it can skip the loop.
-	c.writeLine(`r.Runtextpos = pos + prev + 1
-	r.Runtrackpos = pos + i
-	return true`)
+	c.writeLineFmt(`r.Runtextpos = %[1]s + prev + 1
+	r.Runtrackpos = %[1]s + i
+	return true`, rm.pos)
 
 	c.writeLine(endBlock2)
 	c.writeLine(endBlock)
@@ -727,6 +922,9 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 	//this is in-line and produces an expression that resolves to a bool,
 	//so anything that requires a new var must call a function
 
+	// With IgnoreCase the parser has already added the case equivalents from regexp2's
+	// case table to the set, e.g. k is [KkK] and s is [Ssſ], so there's no folding here.
+
 	// We need to perform the equivalent of calling RegexRunner.CharInClass(ch, charClass),
 	// but that call is relatively expensive.  Before we fall back to it, we try to optimize
 	// some common cases for which we can do much better, such as known character classes
@@ -752,7 +950,8 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 	if val, eq := getFuncCallIfEqual(set, negate, syntax.SpaceClass(), syntax.NotSpaceClass(), "unicode.IsSpace", chExpr); eq {
 		return val
 	}
-	if val, eq := getFuncCallIfEqual(set, negate, syntax.WordClass(), syntax.NotWordClass(), "helpers.IsWordChar", chExpr); eq {
+	if set.Equals(syntax.WordClass()) || set.Equals(syntax.NotWordClass()) {
+		val, _ := getFuncCallIfEqual(set, negate, syntax.WordClass(), syntax.NotWordClass(), c.helper("IsWordChar"), chExpr)
 		return val
 	}
 	/*
@@ -835,9 +1034,9 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 			return fmt.Sprintf("(%v == %q)", chExpr, r.First)
 		}
 		if negate {
-			return fmt.Sprintf("!helpers.IsBetween(%s, %q, %q)", chExpr, r.First, r.Last)
+			return fmt.Sprintf("!%s(%s, %q, %q)", c.helper("IsBetween"), chExpr, r.First, r.Last)
 		}
-		return fmt.Sprintf("helpers.IsBetween(%s, %q, %q)", chExpr, r.First, r.Last)
+		return fmt.Sprintf("%s(%s, %q, %q)", c.helper("IsBetween"), chExpr, r.First, r.Last)
 	}
 
 	// Next, if the character class contains nothing but Unicode categories, we can call char.GetUnicodeCategory and
@@ -943,7 +1142,7 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 		if negate {
 			negStr = "!"
 		}
-		return fmt.Sprintf("%shelpers.IsInMask32(%s-%q, 0x%x)", negStr, chExpr, analysis.LowerBoundInclusiveIfOnlyRanges, bitmap)
+		return fmt.Sprintf("%s%s(%s-%q, 0x%x)", negStr, c.helper("IsInMask32"), chExpr, analysis.LowerBoundInclusiveIfOnlyRanges, bitmap)
 	}
 
 	// Next, handle sets where the high - low + 1 range is <= 64.  As with the 32-bit case above, we can emit
@@ -976,13 +1175,129 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 		if negate {
 			negStr = "!"
 		}
-		return fmt.Sprintf("%shelpers.IsInMask64(%s-%q, 0x%x)", negStr, chExpr, analysis.LowerBoundInclusiveIfOnlyRanges, bitmap)
+		return fmt.Sprintf("%s%s(%s-%q, 0x%x)", negStr, c.helper("IsInMask64"), chExpr, analysis.LowerBoundInclusiveIfOnlyRanges, bitmap)
+	}
+
+	// With AsciiOnly every set is ASCII, see checkAsciiOnly, so the lookup table can be checked
+	// inline instead of calling the per-set function, which also checks the table first.
+	// Unlike ContainsOnlyAscii this takes sets with 0x7F too.
+	if c.opts.AsciiOnly && !set.IsNegated() && analysis.OnlyRanges && analysis.UpperBoundExclusiveIfOnlyRanges <= unicode.MaxASCII+1 {
+		bitmapField := c.emitAsciiBitmapDefinition(getAsciiBitVector(set))
+		if negate {
+			return fmt.Sprintf("(uint(%s) >= 128 || %s[%[1]s>>6]&(1<<(%[1]s&63)) == 0)", chExpr, bitmapField)
+		}
+		return fmt.Sprintf("(uint(%s) < 128 && %s[%[1]s>>6]&(1<<(%[1]s&63)) != 0)", chExpr, bitmapField)
 	}
 
 	// All options after this point require a ch local.
-	// in the C# version this requires assignment statements, which Go doesn't have
-	// so we just repeat chExpr and let the compiler handle temp var
-	//rm.addLocalDec("var ch rune")
+	// in the C# version this requires assignment statements, which Go doesn't have,
+	// so they're in a function per set that's emitted once and called wherever the set is used
+	if c.opts.SetFuncTable {
+		if negate {
+			return fmt.Sprintf("!%s(%s)", c.emitSetFuncTableEntry(rm, set), chExpr)
+		}
+		return fmt.Sprintf("%s(%s)", c.emitSetFuncTableEntry(rm, set), chExpr)
+	}
+	name := c.emitSetMatchFunc(set)
+	if name == "" {
+		// very base option, not optimized
+		name = c.emitSetDefinition(set) + ".CharIn"
+	}
+	if negate {
+		return fmt.Sprintf("!%s(%s)", name, chExpr)
+	}
+	return fmt.Sprintf("%s(%s)", name, chExpr)
+}
+
+// Emits a function that reports whether its ch arg is in the set, for the sets that
+// emitMatchCharacterClass checks with an expression using ch more than once. Returns
+// "" if there's no better check than the set's CharIn.
+func (c *converter) emitSetMatchFunc(set *syntax.CharSet) string {
+	key := set.String()
+	if name, ok := c.setMatchFuncs[key]; ok {
+		return name
+	}
+	name := ""
+	if expr := c.emitMatchCharacterClassWithCh(set); expr != "" {
+		name = "isInSet_" + getSHA256FieldName(string(set.Hash()))
+		c.requiredHelpers[name] = fmt.Sprintf(`// Reports whether ch is in the set %s
+			func %s(ch rune) bool {
+				return %s
+			}`, key, name, expr)
+	}
+	c.setMatchFuncs[key] = name
+	return name
+}
+
+// Emits the pattern's map of the panics its FindFirstChar recovered by runner, for Options.RecoverPanics,
+// and returns the funcs that store and take a runner's panic, e.g. storeFindFirstCharPanic_MyPattern
+// and takeFindFirstCharPanic_MyPattern.
+func (c *converter) emitFindFirstCharPanics(rm *regexpData) (store, take string) {
+	name, count := "findFirstCharPanics_"+rm.GeneratedName, "findFirstCharPanicCount_"+rm.GeneratedName
+	store, take = "storeFindFirstCharPanic_"+rm.GeneratedName, "takeFindFirstCharPanic_"+rm.GeneratedName
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = fmt.Sprintf(`// The panics %[1]s's FindFirstChar recovered, by runner, for the Execute call after it to return,
+		// and how many there are, so a runner only looks itself up once there's one to find
+		var %[2]s sync.Map
+		var %[5]s atomic.Int32
+
+		func %[3]s(r *regexp2.Runner, p any) {
+			if _, loaded := %[2]s.Swap(r, p); !loaded {
+				%[5]s.Add(1)
+			}
+		}
+
+		// Removes and returns the panic r's last FindFirstChar call recovered, if it hasn't been taken yet
+		func %[4]s(r *regexp2.Runner) (any, bool) {
+			if %[5]s.Load() == 0 {
+				return nil, false
+			}
+			p, ok := %[2]s.LoadAndDelete(r)
+			if ok {
+				%[5]s.Add(-1)
+			}
+			return p, ok
+		}`, rm.GeneratedName, name, store, take, count)
+	}
+	return store, take
+}
+
+// Adds the set to the pattern's table of closures that report whether their ch arg is in
+// a set, for Options.SetFuncTable, and returns the table entry to call, e.g. setFns_MyPattern[0].
+// The table is rewritten as each set is added, the sets are only known once the code's emitted.
+func (c *converter) emitSetFuncTableEntry(rm *regexpData, set *syntax.CharSet) string {
+	tableName := "setFns_" + rm.GeneratedName
+	key := set.String()
+	k := slices.Index(rm.setFuncTable, key)
+	if k < 0 {
+		k = len(rm.setFuncTable)
+		rm.setFuncTable = append(rm.setFuncTable, key)
+
+		buf := &bytes.Buffer{}
+		fmt.Fprintf(buf, "// Report whether ch is in each of the sets %s checks\n", rm.GeneratedName)
+		fmt.Fprintf(buf, "var %s = [...]func(ch rune) bool{\n", tableName)
+		for i, key := range rm.setFuncTable {
+			expr := c.setFuncTableExprs[key]
+			if i == k {
+				expr = c.emitMatchCharacterClassWithCh(set)
+				if expr == "" {
+					expr = c.emitSetDefinition(set) + ".CharIn(ch)"
+				}
+				c.setFuncTableExprs[key] = expr
+			}
+			fmt.Fprintf(buf, "// %s\nfunc(ch rune) bool { return %s },\n", key, expr)
+		}
+		buf.WriteString("}")
+		c.requiredHelpers[tableName] = buf.String()
+	}
+	return fmt.Sprintf("%s[%d]", tableName, k)
+}
+
+// The checks for emitSetMatchFunc, or "" for the CharIn fallback.
+func (c *converter) emitMatchCharacterClassWithCh(set *syntax.CharSet) string {
+	negate := false
+	const chExpr = "ch"
+	analysis := set.Analyze()
 
 	// Next, handle simple sets of two ranges, e.g. [\p{IsGreek}\p{IsGreekExtended}].
 	if ranges := set.GetIfNRanges(2); len(ranges) == 2 {
@@ -992,12 +1307,25 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 		if negate {
 			op = "&&"
 		}
-		return fmt.Sprintf("%s %s %s",
+		return fmt.Sprintf("(%s %s %s)",
 			getRangeCheckClause(chExpr, ranges[0], negate),
 			op,
 			getRangeCheckClause(chExpr, ranges[1], negate))
 	}
 
+	// Optionally, sets of many ranges that the ASCII lookup below can't fully handle
+	// are checked with a binary search over a table of the range boundaries.
+	if c.opts.BinarySearchSets && !analysis.ContainsOnlyAscii {
+		if ranges := getSetRanges(set); len(ranges) >= binarySearchSetMinRanges {
+			negate = (negate != set.IsNegated())
+			negStr := ""
+			if negate {
+				negStr = "!"
+			}
+			return fmt.Sprintf("%s%s(%s, %s[:])", negStr, c.emitInRangeTableHelper(), chExpr, c.emitRangeTableDefinition(set, ranges))
+		}
+	}
+
 	if analysis.ContainsNoAscii {
 		// We determined that the character class contains only non-ASCII,
 		// for example if the class were [\u1000-\u2000\u3000-\u4000\u5000-\u6000].
@@ -1029,13 +1357,7 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 	// Generate the lookup table to store 128 answers as bits. We use a const string instead of a byte[] / static
 	// data property because it lets IL emit handle all the details for us.
 	// String length is 8 chars == 16 bytes == 128 bits.
-	bitVector := make([]uint64, 2)
-
-	for i := rune(0); i < unicode.MaxASCII; i++ {
-		if set.CharIn(i) {
-			bitVector[i/64] |= (1 << (i % 64))
-		}
-	}
+	bitVector := getAsciiBitVector(set)
 
 	// There's a chance that the class contains either no ASCII characters or all of them,
 	// and the analysis could not find it (for example if the class has a subtraction).
@@ -1048,6 +1370,17 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 		// all ascii is included
 		return c.emitAllAsciiContained(negate, chExpr, set)
 	}
+
+	if analysis.ContainsOnlyAscii {
+		// If all inputs that could match are ASCII, we only need the lookup table, guarded
+		// by a check for the upper bound (which serves both to limit for what characters
+		// we need to access the lookup table and to bounds check the lookup table access).
+		bitmapField := c.emitAsciiBitmapDefinition(bitVector)
+		if negate {
+			return fmt.Sprintf("(uint(%s) >= 128 || %s[%[1]s>>6]&(1<<(%[1]s&63)) == 0)", chExpr, bitmapField)
+		}
+		return fmt.Sprintf("(uint(%s) < 128 && %s[%[1]s>>6]&(1<<(%[1]s&63)) != 0)", chExpr, bitmapField)
+	}
 	/*
 	   // We determined that the character class may contain ASCII, so we
 	   // output the lookup against the lookup table.
@@ -1094,12 +1427,101 @@ func (c *converter) emitMatchCharacterClass(rm *regexpData, set *syntax.CharSet,
 	   return $"((ch = {chExpr}) < 128 ? {asciiExpr} : {(negate ? "!" : "")}RegexRunner.CharInClass((char)ch, {Literal(charClass)}))";
 	*/
 
-	// very base option, not optimized
-	setField := c.emitSetDefinition(set)
-	if negate {
-		return fmt.Sprintf("!%s.CharIn(%s)", setField, chExpr)
+	return ""
+}
+
+// Returns the 128 bits for whether each ASCII char is in the set
+func getAsciiBitVector(set *syntax.CharSet) []uint64 {
+	bitVector := make([]uint64, 2)
+	for i := rune(0); i <= unicode.MaxASCII; i++ {
+		if set.CharIn(i) {
+			bitVector[i/64] |= (1 << (i % 64))
+		}
+	}
+	return bitVector
+}
+
+// Emits a helper that finds the first rune in a span that's in a table from emitAsciiBitmapDefinition
+func (c *converter) emitIndexOfAnyInTableHelper() string {
+	const name = "indexOfAnyInTable"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = `// Returns the index of the first rune in s that's in the ASCII lookup table, or -1
+		func indexOfAnyInTable(s []rune, table *[2]uint64) int {
+			for i, ch := range s {
+				if uint(ch) < 128 && table[ch>>6]&(1<<(ch&63)) != 0 {
+					return i
+				}
+			}
+			return -1
+		}`
+	}
+	return name
+}
+
+// Emits a package-level 128-bit lookup table for an ASCII set, bit n is set if rune n is in the set
+func (c *converter) emitAsciiBitmapDefinition(bitVector []uint64) string {
+	fieldName := fmt.Sprintf("asciiBitmap_%016x%016x", bitVector[1], bitVector[0])
+
+	if _, ok := c.requiredHelpers[fieldName]; !ok {
+		c.requiredHelpers[fieldName] = fmt.Sprintf(`// Lookup table for the ASCII chars in a set
+		var %v = [2]uint64{0x%x, 0x%x}`,
+			fieldName, bitVector[0], bitVector[1])
+	}
+
+	return fieldName
+}
+
+// the fewest ranges in a set for BinarySearchSets to use a range table
+const binarySearchSetMinRanges = 8
+
+// Returns the ranges of a set that is only ranges (no categories or subtraction), or nil.
+func getSetRanges(set *syntax.CharSet) []syntax.SingleRange {
+	// the set doesn't expose its range count, so probe for it
+	for n := 1; n <= 1024; n++ {
+		if ranges := set.GetIfNRanges(n); ranges != nil {
+			return ranges
+		}
+	}
+	return nil
+}
+
+// Emits a package-level table of the [first, last+1) boundaries of the set's ranges
+func (c *converter) emitRangeTableDefinition(set *syntax.CharSet, ranges []syntax.SingleRange) string {
+	fieldName := fmt.Sprint("setRanges_", getSHA256FieldName(string(set.Hash())))
+
+	if _, ok := c.requiredHelpers[fieldName]; !ok {
+		buf := &bytes.Buffer{}
+		for _, r := range ranges {
+			fmt.Fprintf(buf, "%#x, %#x,\n", r.First, r.Last+1)
+		}
+		c.requiredHelpers[fieldName] = fmt.Sprintf(`// Range boundaries for the set %v
+		var %v = [...]rune{
+		%s}`, set.String(), fieldName, buf.String())
+	}
+
+	return fieldName
+}
+
+// Emits a helper that binary searches a range boundary table from emitRangeTableDefinition
+func (c *converter) emitInRangeTableHelper() string {
+	const name = "inRangeTable"
+	if _, ok := c.requiredHelpers[name]; !ok {
+		c.requiredHelpers[name] = `// Reports whether ch is in one of the [first, last+1) ranges of the sorted boundary table
+		func inRangeTable(ch rune, table []rune) bool {
+			lo, hi := 0, len(table)
+			for lo < hi {
+				m := int(uint(lo+hi) >> 1)
+				if table[m] <= ch {
+					lo = m + 1
+				} else {
+					hi = m
+				}
+			}
+			// an odd number of boundaries at or below ch means it's inside a range
+			return lo&1 == 1
+		}`
 	}
-	return fmt.Sprintf("%s.CharIn(%s)", setField, chExpr)
+	return name
 }
 
 func getRangeCheckClause(chExpr string, r syntax.SingleRange, negate bool) string {
@@ -1107,13 +1529,13 @@ func getRangeCheckClause(chExpr string, r syntax.SingleRange, negate bool) strin
 		if r.First == r.Last {
 			return fmt.Sprintf("%s != %q", chExpr, r.First)
 		} else {
-			return fmt.Sprintf("%s - %q > %v", chExpr, r.First, r.Last-r.First)
+			return fmt.Sprintf("uint(%s - %q) > %v", chExpr, r.First, r.Last-r.First)
 		}
 	}
 	if r.First == r.Last {
 		return fmt.Sprintf("%s == %q", chExpr, r.First)
 	}
-	return fmt.Sprintf("%s - %q <= %v", chExpr, r.First, r.Last-r.First)
+	return fmt.Sprintf("uint(%s - %q) <= %v", chExpr, r.First, r.Last-r.First)
 }
 
 func (c *converter) emitIndexOfAnyCustomHelper(rm *regexpData, set *syntax.CharSet, negate bool, spanName string) string {
@@ -1122,21 +1544,21 @@ func (c *converter) emitIndexOfAnyCustomHelper(rm *regexpData, set *syntax.CharS
 
 	// this is the most general form of the helper
 	match := c.emitMatchCharacterClass(rm, set, negate, "ch")
-	return fmt.Sprintf("helpers.IndexFunc(%s, func(ch rune) bool { return %s })", spanName, match)
+	return fmt.Sprintf("%s(%s, func(ch rune) bool { return %s })", c.helper("IndexFunc"), spanName, match)
 }
 
 func (c *converter) emitContainsNoAscii(negate bool, chExpr string, set *syntax.CharSet) string {
 	setField := c.emitSetDefinition(set)
 	if negate {
-		return fmt.Sprintf("%s < 128 || !%s.CharIn(%[1]s)", chExpr, setField)
+		return fmt.Sprintf("(%s < 128 || !%s.CharIn(%[1]s))", chExpr, setField)
 	}
-	return fmt.Sprintf("%s >= 128 && %s.CharIn(%[1]s)", chExpr, setField)
+	return fmt.Sprintf("(%s >= 128 && %s.CharIn(%[1]s))", chExpr, setField)
 }
 
 func (c *converter) emitAllAsciiContained(negate bool, chExpr string, set *syntax.CharSet) string {
 	setField := c.emitSetDefinition(set)
 	if negate {
-		return fmt.Sprintf("%s >= 128 && !%s.CharIn(%[1]s)", chExpr, setField)
+		return fmt.Sprintf("(%s >= 128 && !%s.CharIn(%[1]s))", chExpr, setField)
 	}
-	return fmt.Sprintf("%s < 128 || %s.CharIn(%[1]s)", chExpr, setField)
+	return fmt.Sprintf("(%s < 128 || %s.CharIn(%[1]s))", chExpr, setField)
 }
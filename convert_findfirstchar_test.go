@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func TestInteriorLiteralSearch(t *testing.T) {
+	pattern := `[a-z]+\d+-required-\d+`
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{InteriorLiteralSearch: true})
+	runMatch(t, pattern, exec, "ab12-required-3", " 0: ab12-required-3")
+	runMatch(t, pattern, exec, "ab12-required-x z1-required-2", " 0: z1-required-2")
+	runNoMatch(t, pattern, exec, "ab12-require-3")
+	runNoMatch(t, pattern, exec, "ab12-required-")
+}
+
+var interiorLiteralBenchInput = strings.Repeat("abc123-optional-456 ", 500)
+
+func BenchmarkInteriorLiteralSearch_Off(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `[a-z]+\d+-required-\d+`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, interiorLiteralBenchInput)
+}
+
+func BenchmarkInteriorLiteralSearch_On(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `[a-z]+\d+-required-\d+`, 0, Options{InteriorLiteralSearch: true})
+	b.ResetTimer()
+	runBench(b, exec, interiorLiteralBenchInput)
+}
+
+func TestAsciiBitmapSet(t *testing.T) {
+	pattern := `@[a-zA-Z0-9_\-.]{3}`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "x@a-.", " 0: @a-.")
+	runMatch(t, pattern, exec, "@Z9_", " 0: @Z9_")
+	runNoMatch(t, pattern, exec, "x@a~.")
+	runNoMatch(t, pattern, exec, "@aé_")
+	runNoMatch(t, pattern, exec, "@a\x7f_")
+
+	// \w is ASCII only for RE2
+	pattern = `@[\w.-]{3}`
+	exec = generateAndCompile(t, pattern, syntax.RE2)
+	runMatch(t, pattern, exec, "x@a-.", " 0: @a-.")
+	runNoMatch(t, pattern, exec, "@aé_")
+}
+
+func BenchmarkAsciiBitmapSet(b *testing.B) {
+	pattern := `[\w.-]{4}@`
+	code := generateCode(b, pattern, syntax.RE2)
+	exec := generateAndCompileMain(b, "_runbenchmain.go", pattern, syntax.RE2, Options{})
+	b.ResetTimer()
+	runBench(b, exec, strings.Repeat("ab.c-d_e ", 200))
+	b.ReportMetric(float64(len(code)), "code-bytes")
+}
+
+func TestAllAsciiSet_InLoop(t *testing.T) {
+	// the ASCII fast path is an || expression, it has to hold up inside a loop condition
+	pattern := `[\x00-\xff\s]+`
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "\\x0a\\x0b\\x0c\\x0d", ` 0: \x0a\x0b\x0c\x0d`)
+	runMatch(t, pattern, exec, "abĀ", " 0: ab")
+}
+
+func TestFindFirstChar_FindModes(t *testing.T) {
+	// a literal prefix is searched for, anchors go straight to the one position that can match
+	tests := []struct {
+		pattern, search, input, expected string
+	}{
+		{`hello\d`, `helpers.IndexOf(r.Runtext[pos:], []rune("hello"))`, "hellohello1", " 0: hello1"},
+		{`^abc`, "if pos == 0 {", "abcabc", " 0: abc"},
+		{`abc$`, "r.Runtextpos = len(r.Runtext) - 4", "abcabc", " 0: abc"},
+	}
+	for _, test := range tests {
+		code := generateCode(t, test.pattern, 0)
+		ffc := code[strings.Index(code, "FindFirstChar("):strings.Index(code, "Execute(")]
+		if !strings.Contains(ffc, test.search) {
+			t.Errorf("expected FindFirstChar for %v to contain %v", test.pattern, test.search)
+		}
+		exec := generateAndCompile(t, test.pattern, 0)
+		runMatch(t, test.pattern, exec, test.input, test.expected)
+	}
+}
+
+func TestLeadingSet_IndexOf(t *testing.T) {
+	// FindFirstChar jumps to the next char in the leading set instead of trying each position
+	tests := []struct {
+		pattern, search, input, expected string
+	}{
+		{`[A-Z]\w+`, "helpers.IndexOfAnyInRange(span[i:], 'A', 'Z')", "ab Cd efG1 Hi", "3: Cd\n8: G1\n11: Hi\n"},
+		{`[A-Za-z]\d`, "indexOfAnyInRanges(span[i:], 'A', 'Z', 'a', 'z')", "12 a1 -B2 z", "3: a1\n7: B2\n"},
+		{`[xz]+`, "helpers.IndexOfAny2(r.Runtext[pos:], 'x', 'z')", "abxzc z", "2: xz\n6: z\n"},
+	}
+	for _, test := range tests {
+		code := generateCode(t, test.pattern, 0)
+		ffc := code[strings.Index(code, "FindFirstChar("):strings.Index(code, "Execute(")]
+		if !strings.Contains(ffc, test.search) {
+			t.Errorf("expected FindFirstChar for %v to contain %v", test.pattern, test.search)
+		}
+		exec := generateAndCompileMain(t, "_runallmain.go", test.pattern, 0, Options{})
+		if got := matchString(t, test.pattern, exec, test.input); got != test.expected {
+			t.Errorf("pattern %v input %q: expected %q, got %q", test.pattern, test.input, test.expected, got)
+		}
+	}
+}
+
+func TestSet_Backspace(t *testing.T) {
+	// \b in a set is the backspace char, not a boundary
+	for _, pattern := range []string{`x[\b]`, `x[\ba]`, `x[\b\w]+`} {
+		if strings.Contains(generateCode(t, pattern, 0), "IsBoundary") {
+			t.Errorf("unexpected boundary check for %v", pattern)
+		}
+		exec := generateAndCompile(t, pattern, 0)
+		runMatch(t, pattern, exec, "x x\\x08", ` 0: x\x08`)
+		runNoMatch(t, pattern, exec, "x x\\x07")
+	}
+
+	pattern := `x\b`
+	if !strings.Contains(generateCode(t, pattern, 0), "if !r.IsBoundary(pos + 1) {") {
+		t.Errorf("expected a boundary check for %v", pattern)
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xy x", " 0: x")
+	runNoMatch(t, pattern, exec, "xy xz")
+}
+
+func TestStartAnchor_FindNext(t *testing.T) {
+	// each match has to start where the previous one ended
+	tests := []struct {
+		pattern, input, expected string
+	}{
+		{`\G\d`, "123 456", "0: 1\n1: 2\n2: 3\n"},
+		{`\G\d+,?`, "12,34,5 67", "0: 12,\n3: 34,\n6: 5\n"},
+		{`\G\d+`, "x12", ""},
+		{`\G(?:ab|c)`, "abcabx", "0: ab\n2: c\n3: ab\n"},
+	}
+
+	for _, test := range tests {
+		exec := generateAndCompileMain(t, "_runallmain.go", test.pattern, 0, Options{})
+		if out := matchString(t, test.pattern, exec, test.input); out != test.expected {
+			t.Errorf("pattern %v input %v: expected %q, got %q", test.pattern, test.input, test.expected, out)
+		}
+	}
+}
+
+// a set of 20 ranges of Cyrillic, Armenian, Hebrew and Arabic chars
+var twentyRangeSet = func() string {
+	sb := &strings.Builder{}
+	sb.WriteString("[")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(sb, `\u%04x-\u%04x`, 0x400+i*32, 0x400+i*32+15)
+	}
+	sb.WriteString("]")
+	return sb.String()
+}()
+
+func TestBinarySearchSet(t *testing.T) {
+	pattern := "x" + twentyRangeSet + "+y"
+	code := generateCodeWithOptions(t, pattern, 0, Options{BinarySearchSets: true})
+	if !strings.Contains(code, "inRangeTable(") {
+		t.Fatalf("expected a binary search for %v", pattern)
+	}
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{BinarySearchSets: true})
+	// first and last chars of the first and last ranges
+	runMatch(t, pattern, exec, "xЀЏ٠ٯy", ` 0: x\xd0\x80\xd0\x8f\xd9\xa0\xd9\xafy`)
+	// just outside the ranges
+	runNoMatch(t, pattern, exec, "xАy")
+	runNoMatch(t, pattern, exec, "xϿy")
+	runNoMatch(t, pattern, exec, "xٰy")
+	runNoMatch(t, pattern, exec, "xay")
+
+	pattern = "x[^" + twentyRangeSet[1:] + "y"
+	exec = generateAndCompileWithOptions(t, pattern, 0, Options{BinarySearchSets: true})
+	runMatch(t, pattern, exec, "xАy", ` 0: x\xd0\x90y`)
+	runMatch(t, pattern, exec, "xay", " 0: xay")
+	runNoMatch(t, pattern, exec, "xРy")
+}
+
+var binarySearchSetBenchInput = strings.Repeat("x"+strings.Repeat("Ѐԡ٪хՅ", 20)+"y ", 20)
+
+func BenchmarkBinarySearchSet_Off(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", "x"+twentyRangeSet+"+z", 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, binarySearchSetBenchInput)
+}
+
+func BenchmarkBinarySearchSet_On(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", "x"+twentyRangeSet+"+z", 0, Options{BinarySearchSets: true})
+	b.ResetTimer()
+	runBench(b, exec, binarySearchSetBenchInput)
+}
+
+func TestChunkedPrefixScan(t *testing.T) {
+	pattern := `hello\w+`
+	if code := generateCodeWithOptions(t, pattern, 0, Options{ChunkedPrefixScan: true}); !strings.Contains(code, "indexOfChunked(r.Runtext[pos:]") {
+		t.Errorf("expected the chunked search for %v", pattern)
+	}
+	// too short to be worth it, and not ASCII
+	for _, pattern := range []string{`hel\w+`, `héllo\w+`, `(?i)hello\w+`} {
+		if code := generateCodeWithOptions(t, pattern, 0, Options{ChunkedPrefixScan: true}); strings.Contains(code, "indexOfChunked") {
+			t.Errorf("unexpected chunked search for %v", pattern)
+		}
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{ChunkedPrefixScan: true})
+	runMatch(t, pattern, exec, "hellox", " 0: hellox")
+	// in the first block, across blocks, and in the remainder after the last block
+	runMatch(t, pattern, exec, "xxxxxxxhelloq", " 0: helloq")
+	runMatch(t, pattern, exec, strings.Repeat("h", 26)+"elloz", " 0: helloz")
+	runMatch(t, pattern, exec, strings.Repeat("x", 16)+"hellohelloa", " 0: hellohelloa")
+	runMatch(t, pattern, exec, strings.Repeat("x", 20)+"helloa", " 0: helloa")
+	runNoMatch(t, pattern, exec, "hellhellhellhell")
+	runNoMatch(t, pattern, exec, strings.Repeat("x", 25)+"hello")
+}
+
+var chunkedPrefixScanBenchInput = strings.Repeat("help here, hell there, oh hey ", 200) + "hello world1"
+
+func BenchmarkChunkedPrefixScan_Off(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `hello world\d`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, chunkedPrefixScanBenchInput)
+}
+
+func BenchmarkChunkedPrefixScan_On(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `hello world\d`, 0, Options{ChunkedPrefixScan: true})
+	b.ResetTimer()
+	runBench(b, exec, chunkedPrefixScanBenchInput)
+}
+
+func TestHorspoolPrefixScan(t *testing.T) {
+	pattern := `abcab\w`
+	code := generateCodeWithOptions(t, pattern, 0, Options{HorspoolPrefixScan: true})
+	if !strings.Contains(code, "indexOfHorspool(r.Runtext[pos:], []rune(\"abcab\"), &horspoolSkip_") ||
+		!strings.Contains(code, "= [128]int{'a': 4, 'b': 2, 'c': 3}") {
+		t.Errorf("expected the Horspool search with its skip table for %v", pattern)
+	}
+	// too short to be worth it, and not ASCII
+	for _, pattern := range []string{`hel\w+`, `héllo\w+`, `(?i)hello\w+`} {
+		if code := generateCodeWithOptions(t, pattern, 0, Options{HorspoolPrefixScan: true}); strings.Contains(code, "indexOfHorspool") {
+			t.Errorf("unexpected Horspool search for %v", pattern)
+		}
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{HorspoolPrefixScan: true})
+	runMatch(t, pattern, exec, "abcabx", " 0: abcabx")
+	runMatch(t, pattern, exec, "abcaabcabz", " 0: abcabz")
+	runMatch(t, pattern, exec, "ababcaabcabq", " 0: abcabq")
+	runMatch(t, pattern, exec, "xxéxxabcab1", " 0: abcab1")
+	runNoMatch(t, pattern, exec, "abcaabcbabca")
+	runNoMatch(t, pattern, exec, "xxabcab")
+
+	// at a fixed distance into the pattern
+	pattern = `\d{2}abcdef`
+	exec = generateAndCompileWithOptions(t, pattern, 0, Options{HorspoolPrefixScan: true})
+	runMatch(t, pattern, exec, "abcdef1abcdef12abcdef", " 0: 12abcdef")
+}
+
+var horspoolPrefixScanBenchInput = strings.Repeat("the quick brown fox, a lazy dog ", 200) + "prefix1234 5"
+
+func BenchmarkHorspoolPrefixScan_Off(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `prefix1234\s\d`, 0, Options{})
+	b.ResetTimer()
+	runBench(b, exec, horspoolPrefixScanBenchInput)
+}
+
+func BenchmarkHorspoolPrefixScan_On(b *testing.B) {
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `prefix1234\s\d`, 0, Options{HorspoolPrefixScan: true})
+	b.ResetTimer()
+	runBench(b, exec, horspoolPrefixScanBenchInput)
+}
+
+func TestLeadingSetTable(t *testing.T) {
+	pattern := `[a-f]\d`
+	code := generateCodeWithOptions(t, pattern, 0, Options{LeadingSetTable: true})
+	if !strings.Contains(code, "indexOfAnyInTable(span[i:], &asciiBitmap_0000007e000000000000000000000000)") ||
+		!strings.Contains(code, "var asciiBitmap_0000007e000000000000000000000000 = [2]uint64{0x0, 0x7e00000000}") {
+		t.Errorf("expected the search to use the set's table")
+	}
+
+	// FindOptimizations only keeps the "oo" for [a-f]oo, there's no leading set to search for
+	if code := generateCodeWithOptions(t, `[a-f]oo`, 0, Options{LeadingSetTable: true}); !strings.Contains(code, `helpers.IndexOf(r.Runtext[pos+1:], []rune("oo"))`) {
+		t.Errorf("expected [a-f]oo to search for the literal")
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{LeadingSetTable: true})
+	runMatch(t, pattern, exec, "zzc5", " 0: c5")
+	runMatch(t, pattern, exec, "é€f1", " 0: f1")
+	runNoMatch(t, pattern, exec, "xyz9f")
+	runNoMatch(t, pattern, exec, "ff")
+}
+
+func TestFixedDistanceSets_End(t *testing.T) {
+	// the primary set is found in the last char, where the next set can't fit
+	pattern := `[a-f]\d`
+	exec := generateAndCompile(t, pattern, 0)
+	runNoMatch(t, pattern, exec, "xyz9f")
+	runMatch(t, pattern, exec, "xyz9f1", " 0: f1")
+}
+
+func TestSetMatchFunc(t *testing.T) {
+	pattern := `[a-fက]x[a-fက]y[^a-fက]`
+	code := generateCode(t, pattern, 0)
+	const name = "isInSet_6c069d91309c7860cfd3c5c413aa0d354e771716584fcbd5a0c777e2b5a572e7"
+	if n := strings.Count(code, "func "+name+"(ch rune) bool {"); n != 1 {
+		t.Errorf("expected the set's func once, got %v", n)
+	}
+	if n := strings.Count(code, name+"("); n != 4 {
+		t.Errorf("expected the set's func to be called for each use, got %v in:\n%s", n-1, code)
+	}
+	if !strings.Contains(code, "!"+name+"(") {
+		t.Errorf("expected the negated set to call the same func")
+	}
+
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "bxကyz", ` 0: bx\xe1\x80\x80yz`)
+	runNoMatch(t, pattern, exec, "axayb")
+}
+
+func TestTwoRanges_BelowRange(t *testing.T) {
+	// each range is checked unsigned, so chars below 'a' aren't in it
+	pattern := `x[a-fက][^a-fက]`
+	exec := generateAndCompile(t, pattern, 0)
+	runNoMatch(t, pattern, exec, "xA!")
+	runNoMatch(t, pattern, exec, "xaf")
+	runMatch(t, pattern, exec, "xa!", " 0: xa!")
+	runMatch(t, pattern, exec, "xကA", ` 0: x\xe1\x80\x80A`)
+}
+
+func TestSetFuncTable(t *testing.T) {
+	pattern := `[a-fက]x[0-9ሴ]+y[^a-fက][a-fက]`
+	code := generateCodeWithOptions(t, pattern, 0, Options{SetFuncTable: true})
+	if n := strings.Count(code, "var setFns_MyPattern = [...]func(ch rune) bool{"); n != 1 {
+		t.Errorf("expected the table once, got %v in:\n%s", n, code)
+	}
+	if n := strings.Count(code, "func(ch rune) bool { return "); n != 3 {
+		t.Errorf("expected a closure per distinct set, got %v in:\n%s", n, code)
+	}
+	if strings.Contains(code, "func isInSet_") {
+		t.Errorf("expected no set funcs with the table")
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{SetFuncTable: true})
+	runMatch(t, pattern, exec, "bx0ሴ9yzက", ` 0: bx0\xe1\x88\xb49yz\xe1\x80\x80`)
+	runNoMatch(t, pattern, exec, "bx0y!g")
+	runNoMatch(t, pattern, exec, "bxyza")
+	runMatch(t, pattern, exec, "Axay!a ax1y!f", " 0: ax1y!f")
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	// a pattern that needs several helpers
+	pattern := `[\w\d]x[^"]*?"abcd[a-z]{2}efghijkl[^\w\d]`
+	want := generateCode(t, pattern, 0)
+	for i := 0; i < 20; i++ {
+		if got := generateCode(t, pattern, 0); got != want {
+			t.Fatal("expected the same code each time")
+		}
+	}
+}
+
+func TestSet_IgnoreCase(t *testing.T) {
+	// the sets carry the parser's case equivalents, K and ſ are in [Kk] and [Ss]
+	// but İ and ı only fold to i with unicode.ToLower, not in regexp2's table
+	inputs := []string{"k", "K", "K", "s", "S", "ſ", "i", "I", "İ", "ı",
+		"xABI", "xabİ", "xKy", "LIſT", "[K-ſ]"}
+	for _, pattern := range []string{`(?i)k`, `(?i)s`, `(?i)[a-z]+`, `(?i)[k-s]`, `(?i)[^k]`,
+		`(?i)x[ab]+i`, `(?i)xabi`, `(?i)li[sk]t`, `(?i)\[[k-m]-[^a-r]\]`} {
+		exec := generateAndCompile(t, pattern, 0)
+		for _, input := range inputs {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+
+	code := generateCode(t, `(?i)xabi`, 0)
+	if strings.Contains(code, "helpers.StartsWithIgnoreCase(") {
+		t.Errorf("expected the ordinal string to skip unicode.ToLower")
+	}
+}
+
+func TestBeginningAnchor_OneAttempt(t *testing.T) {
+	// findFirstChar fails everywhere after 0, and moves to the end so the scan stops
+	for _, pattern := range []string{`\Aab`, `^\d+x`, `\A(?:a|b)+c`} {
+		exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ExplainMatch: true})
+		out := matchString(t, pattern, exec, "xxabab1x")
+		if n := strings.Count(out, "try at "); n != 1 {
+			t.Errorf("expected %v to be tried once, got:\n%s", pattern, out)
+		}
+		runMatch(t, pattern, exec, "xxabab1x", "no match found")
+	}
+
+	// and not in RightToLeft, where \A is matched last
+	pattern := `\Aab`
+	exec := generateAndCompile(t, pattern, syntax.RightToLeft)
+	runMatch(t, pattern, exec, "abab", " 0: ab")
+	runNoMatch(t, pattern, exec, "xab")
+}
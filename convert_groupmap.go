@@ -0,0 +1,30 @@
+package main
+
+// Emits Match, which returns the groups of the first match by name, or by number for the
+// numbered groups, e.g. "0" for the whole match, see Options.GroupMap.
+func (c *converter) emitMatchGroupMap(rm *regexpData) {
+	unmatched := "Groups that didn't match are left out."
+	set := `if len(g.Captures) > 0 {
+			groups[g.Name] = g.String()
+		}`
+	if c.opts.GroupMapEmpty {
+		unmatched = "Groups that didn't match are empty."
+		set = "groups[g.Name] = g.String()"
+	}
+
+	c.writeLineFmt(`// Match finds the first match in s and returns its groups by name, and the numbered
+		// groups by number, e.g. "0" for the whole match. %[2]s
+		// It reports false if there's no match.
+		func (%[1]s_Engine) Match(s string) (map[string]string, bool, error) {
+			m, err := %[1]s_regexp.FindStringMatch(s)
+			if err != nil || m == nil {
+				return nil, false, err
+			}
+			groups := make(map[string]string, %[3]v)
+			for _, g := range m.Groups() {
+				%[4]s
+			}
+			return groups, true, nil
+		}
+		`, rm.GeneratedName, unmatched, len(rm.Tree.Caplist), set)
+}
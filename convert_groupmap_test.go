@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestMatchGroupMap(t *testing.T) {
+	pattern := `(?<year>\d{4})-(\d{2})(?:-(?<day>\d{2}))?`
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{GroupMap: true})
+	runMatch(t, pattern, exec, "on 2024-10-16", "map[0:2024-10-16 1:10 day:16 year:2024] true <nil>")
+	// unmatched groups are left out
+	runMatch(t, pattern, exec, "on 2024-10", "map[0:2024-10 1:10 year:2024] true <nil>")
+	runMatch(t, pattern, exec, "2024/10", "map[] false <nil>")
+
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{GroupMap: true, GroupMapEmpty: true})
+	runMatch(t, pattern, exec, "on 2024-10", "map[0:2024-10 1:10 day: year:2024] true <nil>")
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// what the generated code calls the helpers with Options.InlineHelpers, in place of the
+// package qualifier, e.g. helperStartsWith for helpers.StartsWith
+const inlineHelpersPrefix = "helper"
+
+// the other inlined helpers each of the inlineHelpers calls or refers to, by name without the prefix
+var inlineHelperDeps = map[string][]string{
+	"Equals":                {"RunesEqual"},
+	"EqualsIgnoreCase":      {"Equals"},
+	"IndexOf":               {"RunesEqual"},
+	"IndexOfAnyExcept":      {"IndexOfAny1"},
+	"IndexOfIgnoreCase":     {"StartsWithIgnoreCase"},
+	"LastIndexOf":           {"RunesEqual"},
+	"NewAsciiSearchValues":  {"AsciiSearchValues"},
+	"NewRuneSearchValues":   {"RuneSearchValues"},
+	"NewStringSearchValues": {"StringSearchValues", "Min", "IndexOfAny1", "RuneSearchValues"},
+	"RuneSearchValues":      {"IndexOfAny", "IndexOfAnyExcept", "IndexOfAny1"},
+	"StartsWith":            {"RunesEqual"},
+	"StringSearchValues":    {"RuneSearchValues", "Equals", "EqualsIgnoreCase"},
+}
+
+// Copies of github.com/dlclark/regexp2/helpers for Options.InlineHelpers, by name without the prefix.
+// They only use unicode, which the generated file imports anyway, so where the package uses slices,
+// bytes or unsafe these use loops instead. The types are separate from their constructors so the
+// methods come along with either.
+var inlineHelpers = map[string]string{
+	"Min": `func helperMin(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	}`,
+	"Max": `func helperMax(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	}`,
+	"IsBetween": `func helperIsBetween(val rune, first, last rune) bool {
+		return val >= first && val <= last
+	}`,
+	"IsWordChar": `func helperIsWordChar(r rune) bool {
+		return unicode.In(r,
+			unicode.Categories["L"], unicode.Categories["Mn"],
+			unicode.Categories["Nd"], unicode.Categories["Pc"]) || r == '\u200D' || r == '\u200C'
+	}`,
+	"IsInMask32": `func helperIsInMask32(ch rune, mask uint32) bool {
+		return int32((mask<<uint16(ch))&uint32(ch-32)) < 0
+	}`,
+	"IsInMask64": `func helperIsInMask64(ch rune, mask uint64) bool {
+		return int64((mask<<uint32(ch))&uint64(ch-64)) < 0
+	}`,
+	"RunesEqual": `func helperRunesEqual(a, b []rune) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}`,
+	"StartsWith": `func helperStartsWith(in []rune, find []rune) bool {
+		return len(in) >= len(find) && helperRunesEqual(in[:len(find)], find)
+	}`,
+	"StartsWithIgnoreCase": `func helperStartsWithIgnoreCase(in []rune, find []rune) bool {
+		if len(in) < len(find) {
+			return false
+		}
+		for i := 0; i < len(find); i++ {
+			if in[i] != find[i] && unicode.ToLower(in[i]) != find[i] {
+				return false
+			}
+		}
+		return true
+	}`,
+	"Equals": `func helperEquals(in []rune, start int, length int, find []rune) bool {
+		if len(find) == 0 {
+			return true
+		}
+		return helperRunesEqual(in[start:start+length], find)
+	}`,
+	"EqualsIgnoreCase": `func helperEqualsIgnoreCase(in []rune, start int, length int, find []rune) bool {
+		if helperEquals(in, start, length, find) {
+			return true
+		}
+		for j := 0; j < len(find); j++ {
+			inChar, findChar := in[start+j], find[j]
+			if inChar != findChar && unicode.ToLower(inChar) != unicode.ToLower(findChar) {
+				return false
+			}
+		}
+		return true
+	}`,
+	"IndexOf": `func helperIndexOf(in []rune, find []rune) int {
+		end := len(in) - len(find)
+		first := find[0]
+		for i := 0; i <= end; i++ {
+			if in[i] == first && helperRunesEqual(in[i:i+len(find)], find) {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfIgnoreCase": `func helperIndexOfIgnoreCase(in []rune, find []rune) int {
+		end := len(in) - len(find)
+		for i := 0; i <= end; i++ {
+			if helperStartsWithIgnoreCase(in[i:], find) {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"LastIndexOf": `func helperLastIndexOf(in []rune, find []rune) int {
+		for i := len(in) - len(find); i >= 0; i-- {
+			if helperRunesEqual(in[i:i+len(find)], find) {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexFunc": `func helperIndexFunc(in []rune, f func(ch rune) bool) int {
+		for i := range in {
+			if f(in[i]) {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAny": `func helperIndexOfAny(in []rune, find []rune) int {
+		for i, c := range in {
+			for _, f := range find {
+				if c == f {
+					return i
+				}
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAny1": `func helperIndexOfAny1(in []rune, find rune) int {
+		for i, c := range in {
+			if c == find {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAny2": `func helperIndexOfAny2(in []rune, find1, find2 rune) int {
+		for i, c := range in {
+			if c == find1 || c == find2 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAny3": `func helperIndexOfAny3(in []rune, find1, find2, find3 rune) int {
+		for i, c := range in {
+			if c == find1 || c == find2 || c == find3 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyInRange": `func helperIndexOfAnyInRange(in []rune, first, last rune) int {
+		for i, c := range in {
+			if c >= first && c <= last {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyExcept": `func helperIndexOfAnyExcept(in []rune, bad []rune) int {
+		for i, c := range in {
+			if helperIndexOfAny1(bad, c) < 0 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyExcept1": `func helperIndexOfAnyExcept1(in []rune, bad rune) int {
+		for i, c := range in {
+			if c != bad {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyExcept2": `func helperIndexOfAnyExcept2(in []rune, bad1, bad2 rune) int {
+		for i, c := range in {
+			if c != bad1 && c != bad2 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyExcept3": `func helperIndexOfAnyExcept3(in []rune, bad1, bad2, bad3 rune) int {
+		for i, c := range in {
+			if c != bad1 && c != bad2 && c != bad3 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"IndexOfAnyExceptInRange": `func helperIndexOfAnyExceptInRange(in []rune, first, last rune) int {
+		for i, c := range in {
+			if c < first || c > last {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"LastIndexOfAny1": `func helperLastIndexOfAny1(in []rune, find rune) int {
+		for i := len(in) - 1; i >= 0; i-- {
+			if in[i] == find {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"LastIndexOfAnyExcept1": `func helperLastIndexOfAnyExcept1(in []rune, not rune) int {
+		for i := len(in) - 1; i >= 0; i-- {
+			if in[i] != not {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"LastIndexOfAnyInRange": `func helperLastIndexOfAnyInRange(in []rune, first, last rune) int {
+		for i := len(in) - 1; i >= 0; i-- {
+			if in[i] >= first && in[i] <= last {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"AsciiSearchValues": `type helperAsciiSearchValues struct {
+		// a bit for each ASCII char
+		set [2]uint64
+	}
+
+	func (s helperAsciiSearchValues) contains(c rune) bool {
+		return c <= unicode.MaxASCII && s.set[c/64]&(1<<(c%64)) != 0
+	}
+
+	func (s helperAsciiSearchValues) IndexOfAny(chars []rune) int {
+		for i, c := range chars {
+			if s.contains(c) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	func (s helperAsciiSearchValues) IndexOfAnyExcept(chars []rune) int {
+		for i, c := range chars {
+			if !s.contains(c) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	func (s helperAsciiSearchValues) LastIndexOfAny(chars []rune) int {
+		for i := len(chars) - 1; i >= 0; i-- {
+			if s.contains(chars[i]) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	func (s helperAsciiSearchValues) LastIndexOfAnyExcept(chars []rune) int {
+		for i := len(chars) - 1; i >= 0; i-- {
+			if !s.contains(chars[i]) {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"NewAsciiSearchValues": `func helperNewAsciiSearchValues(vals string) helperAsciiSearchValues {
+		sv := helperAsciiSearchValues{}
+		for i := 0; i < len(vals); i++ {
+			c := vals[i]
+			if c > unicode.MaxASCII {
+				panic("non-ascii value found in ascii search values: " + vals)
+			}
+			sv.set[c/64] |= 1 << (c % 64)
+		}
+		return sv
+	}`,
+	"RuneSearchValues": `type helperRuneSearchValues struct {
+		vals []rune
+	}
+
+	func (s helperRuneSearchValues) IndexOfAny(chars []rune) int {
+		return helperIndexOfAny(chars, s.vals)
+	}
+
+	func (s helperRuneSearchValues) IndexOfAnyExcept(chars []rune) int {
+		return helperIndexOfAnyExcept(chars, s.vals)
+	}
+
+	func (s helperRuneSearchValues) LastIndexOfAny(chars []rune) int {
+		for i := len(chars) - 1; i >= 0; i-- {
+			if helperIndexOfAny1(s.vals, chars[i]) >= 0 {
+				return i
+			}
+		}
+		return -1
+	}
+
+	func (s helperRuneSearchValues) LastIndexOfAnyExcept(chars []rune) int {
+		for i := len(chars) - 1; i >= 0; i-- {
+			if helperIndexOfAny1(s.vals, chars[i]) < 0 {
+				return i
+			}
+		}
+		return -1
+	}`,
+	"NewRuneSearchValues": `func helperNewRuneSearchValues(vals string) helperRuneSearchValues {
+		return helperRuneSearchValues{vals: []rune(vals)}
+	}`,
+	"StringSearchValues": `type helperStringSearchValues struct {
+		vals        [][]rune
+		ignoreCase  bool
+		shortestVal int
+
+		firstChars helperRuneSearchValues
+	}
+
+	func (s helperStringSearchValues) IndexOfAny(in []rune) int {
+		end := len(in) - s.shortestVal
+		for i := 0; i <= end; i++ {
+			j := s.firstChars.IndexOfAny(in[i:])
+			if j < 0 {
+				return -1
+			}
+			j += i
+			for _, val := range s.vals {
+				if helperEquals(in, j, len(in)-j, val) {
+					return j
+				}
+				if s.ignoreCase && helperEqualsIgnoreCase(in, j, len(in)-j, val) {
+					return j
+				}
+			}
+			i = j
+		}
+		return -1
+	}`,
+	"NewStringSearchValues": `func helperNewStringSearchValues(vals [][]rune, ignoreCase bool) helperStringSearchValues {
+		shortest := len(vals[0])
+		var firstLetters []rune
+		for _, val := range vals {
+			shortest = helperMin(shortest, len(val))
+			if helperIndexOfAny1(firstLetters, val[0]) < 0 {
+				firstLetters = append(firstLetters, val[0])
+				if ignoreCase && val[0] != unicode.ToUpper(val[0]) {
+					firstLetters = append(firstLetters, unicode.ToUpper(val[0]))
+				}
+			}
+		}
+		return helperStringSearchValues{
+			vals:        vals,
+			ignoreCase:  ignoreCase,
+			shortestVal: shortest,
+			firstChars:  helperRuneSearchValues{vals: firstLetters},
+		}
+	}`,
+}
+
+// Returns what the generated code calls the helper with the given name by, e.g. helpers.StartsWith,
+// or helperStartsWith with Options.InlineHelpers, and records that the file uses it.
+func (c *converter) helper(name string) string {
+	c.usedHelpers[name] = true
+	return c.helpers + name
+}
+
+// Returns the sources of the inlined copies of the used helpers, and of the helpers they use in
+// turn, sorted by name. Fails for a helper there's no copy of.
+func usedInlineHelpers(used map[string]bool) ([]string, error) {
+	var names []string
+	var add func(name string) error
+	add = func(name string) error {
+		if slices.Contains(names, name) {
+			return nil
+		}
+		if _, ok := inlineHelpers[name]; !ok {
+			return fmt.Errorf("no inlined copy of helpers.%s", name)
+		}
+		names = append(names, name)
+		for _, dep := range inlineHelperDeps[name] {
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// in order, so the same helper is reported missing each time
+	usedNames := make([]string, 0, len(used))
+	for name := range used {
+		usedNames = append(usedNames, name)
+	}
+	slices.Sort(usedNames)
+	for _, name := range usedNames {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.Sort(names)
+	retval := make([]string, len(names))
+	for i, name := range names {
+		retval[i] = inlineHelpers[name]
+	}
+	return retval, nil
+}
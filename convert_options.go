@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseOptions sets the Options given in data, a JSON object keyed by the command line flag for
+// each option, e.g. {"longest": true, "helpers": "example.com/myhelpers"}, so tools can drive the
+// converter with a config file instead of flags. Options that aren't in data keep their value in
+// opts. An unknown key is an error, so a misspelled option isn't silently ignored.
+func ParseOptions(data []byte, opts *Options) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return errors.Wrap(err, "options")
+	}
+	return nil
+}
+
+// ParseOptionsMap is the map version of ParseOptions, for tools that have the options from
+// another format, e.g. {"recover": true, "localprefix": "_rx_"}.
+func ParseOptionsMap(m map[string]any, opts *Options) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "options")
+	}
+	return ParseOptions(data, opts)
+}
+
+// Reports whether name is the JSON name, and command line flag, of one of the Options.
+func isOptionName(name string) bool {
+	t := reflect.TypeOf(Options{})
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func TestLongestFirstAlternation(t *testing.T) {
+	pattern := `(a|ab)`
+
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "abc", " 0: a")
+
+	genOpts := Options{LongestFirstAlternation: true}
+	exec = generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "abc", " 0: ab")
+	runMatch(t, pattern, exec, "abc", " 1: ab")
+	runMatch(t, pattern, exec, "acb", " 0: a")
+
+	// the engine is registered under the pattern as written
+	exec = generateAndCompileMain(t, "_runenginemain.go", pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "", fmt.Sprintf("Pattern: %q", pattern))
+
+	pattern = `x|xyz|xy`
+	exec = generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "axyzb", " 0: xyz")
+	runMatch(t, pattern, exec, "axyb", " 0: xy")
+	runMatch(t, pattern, exec, "axzb", " 0: x")
+}
+
+func TestSplitLiteralAlternation(t *testing.T) {
+	tests := []struct {
+		in       string
+		group    string
+		branches []string
+	}{
+		{`a|ab`, ``, []string{`a`, `ab`}},
+		{`(a|ab)`, `(`, []string{`a`, `ab`}},
+		{`(?:x|xyz|xy)`, `(?:`, []string{`x`, `xyz`, `xy`}},
+		{`a\.|a`, ``, []string{`a\.`, `a`}},
+		{`a|`, ``, []string{`a`, ``}},
+		// not all literals, leave alone
+		{`ab`, ``, nil},
+		{`a|a+`, ``, nil},
+		{`(a)|(ab)`, ``, nil},
+		{`a|\w\w`, ``, nil},
+	}
+	for _, test := range tests {
+		group, branches := splitLiteralAlternation(test.in)
+		if group != test.group || !slices.Equal(branches, test.branches) {
+			t.Errorf("splitLiteralAlternation(%q) = %q, %q, want %q, %q", test.in, group, branches, test.group, test.branches)
+		}
+	}
+}
+
+func TestSkipFormat(t *testing.T) {
+	code := generateCode(t, `ab`, 0)
+	if strings.Contains(code, "\n  \"github.com/dlclark/regexp2\"") {
+		t.Errorf("expected formatted imports, got:\n%s", code)
+	}
+
+	code = generateCodeWithOptions(t, `ab`, 0, Options{SkipFormat: true})
+	if !strings.Contains(code, "\n  \"github.com/dlclark/regexp2\"") {
+		t.Errorf("expected the raw imports, got:\n%s", code)
+	}
+}
+
+func TestFormatSource_Error(t *testing.T) {
+	code := "package main\n\nfunc a() {\n\tif x {\n\t\tgoto\n\t}\n}\n"
+	_, err := formatSource([]byte(code))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "doesn't parse") || !strings.Contains(msg, ">    6| \t}") || !strings.Contains(msg, "     5| \t\tgoto") {
+		t.Errorf("expected the lines around the error, got:\n%s", msg)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rm := &regexpData{Pattern: "a$"}
+	code := "func (e) Execute() error {\n\t// Node: One(Ch = a)\n\tpos++\n\n\t// Node: Eol\n\tif (pos < len(r.Runtext) {\n\t\treturn nil\n\t}\n}\n"
+	err := verify(rm, code)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `generated code for "a$" doesn't parse in Eol: 6:`) || !strings.Contains(msg, ">    6| \tif (pos") {
+		t.Errorf("expected the node and lines around the error, got:\n%s", msg)
+	}
+
+	if err := verify(rm, "func a() {}\n"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	code := generateCode(t, `a"b\d`, syntax.IgnoreCase|syntax.RightToLeft)
+	lines := strings.Split(code, "\n")
+	// the marker go tooling looks for, https://go.dev/s/generatedcode
+	if lines[0] != "// Code generated by regexp2cg; DO NOT EDIT." {
+		t.Errorf("expected the generated code marker first, got %q", lines[0])
+	}
+	if !strings.Contains(code, "// regexp2 version: ") {
+		t.Errorf("expected the regexp2 version in the header")
+	}
+	want := `//   MyFile.go:120:10: "a\"b\\d", regexp2.IgnoreCase|regexp2.RightToLeft`
+	if !slices.Contains(lines, want) {
+		t.Errorf("expected %q in the header, got:\n%s", want, strings.Join(lines[:8], "\n"))
+	}
+}
+
+func TestAnnotateNodes(t *testing.T) {
+	pattern := `(a+)(ab|c)`
+	code := generateCode(t, pattern, 0)
+	if strings.Contains(code, "[depth") {
+		t.Errorf("unexpected node annotations without the option")
+	}
+
+	code = generateCodeWithOptions(t, pattern, 0, Options{AnnotateNodes: true})
+	for _, want := range []string{
+		"// Node: Concatenate [depth 1, path 0]",
+		"// Node: Oneloop(Ch = a)(Min = 1, Max = inf) [depth 3, path 0.0.0]",
+		"// Node: Alternate [depth 4, path 0.1.0.0]",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	// and it still builds and matches
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{AnnotateNodes: true})
+	runMatch(t, pattern, exec, "aac", " 0: aac")
+}
+
+func TestDecisionComments(t *testing.T) {
+	pattern := `(?:a|(b+)\w)*ab[^q]*q`
+	if code := generateCode(t, pattern, 0); strings.Contains(code, "// Why:") {
+		t.Errorf("unexpected decision comments without the option")
+	}
+
+	code := generateCodeWithOptions(t, pattern, 0, Options{DecisionComments: true})
+	for _, want := range []string{
+		"// Why: inside another loop, the positions go on the backtracking stack",
+		"// Why: trying each branch in turn, backtracking into the one that matched and then the next",
+		"// Why: searching with IndexOf for the first char the loop doesn't match",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	for _, test := range []struct {
+		pattern, want string
+	}{
+		{`(?>\w(a+))`, "// Why: no atomic group, the child never backtracks"},
+		{`(a?b?)*c`, "// Why: iterations may be empty, so each checks it moved"},
+		{`(a??)\wb`, "// Why: a lazy optional char, only the starting pos is kept for backtracking"},
+		{`(?>ab|cd)e`, "// Why: every branch starts with different chars, switching on the first"},
+	} {
+		if code := generateCodeWithOptions(t, test.pattern, 0, Options{DecisionComments: true}); !strings.Contains(code, test.want) {
+			t.Errorf("expected %q for %v in:\n%s", test.want, test.pattern, code)
+		}
+	}
+
+	// and it still builds and matches
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{DecisionComments: true})
+	runMatch(t, pattern, exec, "abbxaabzq", " 0: abbxaabzq")
+}
+
+func TestGenerate(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := Generate(out, "gen", `a+b`, syntax.IgnoreCase, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	code := out.String()
+	for _, want := range []string{
+		"\npackage gen\n",
+		"type MyPattern_Engine struct{}",
+		`regexp2.RegisterEngine("a+b", regexp2.IgnoreCase, &MyPattern_Engine{})`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	// errors are returned rather than written
+	out.Reset()
+	if err := Generate(out, "gen", `(a`, 0, Options{}); err == nil || !strings.Contains(err.Error(), "missing closing )") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing written for a bad pattern, got:\n%s", out.String())
+	}
+}
+
+func TestEntryTimeoutCheck(t *testing.T) {
+	pattern := `\w+@\w+`
+	const check = "matchStart := pos\n\n\tif err := r.CheckTimeout(); err != nil {"
+	if code := generateCode(t, pattern, 0); strings.Contains(code, check) {
+		t.Errorf("unexpected timeout check at the top of Execute without the option")
+	}
+	if code := generateCodeWithOptions(t, pattern, 0, Options{EntryTimeoutCheck: true}); !strings.Contains(code, check) {
+		t.Errorf("expected a timeout check after the locals in:\n%s", code)
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{EntryTimeoutCheck: true})
+	runMatch(t, pattern, exec, "mail a@b", " 0: a@b")
+}
+
+func TestBacktrackDispatch(t *testing.T) {
+	// too few backtracking labels for the switch to pay off
+	pattern := `(\w+)\s(\w+)`
+	if code := generateCodeWithOptions(t, pattern, 0, Options{BacktrackDispatch: true}); strings.Contains(code, "BacktrackDispatch") {
+		t.Errorf("unexpected backtracking switch for a pattern with few labels")
+	}
+
+	pattern = `(a|ab)(c|bcd)(d*)(e|ef)+?(g|gh)*(\w+)\1$`
+	if code := generateCode(t, pattern, 0); strings.Contains(code, "BacktrackDispatch") {
+		t.Errorf("unexpected backtracking switch without the option")
+	}
+	code := generateCodeWithOptions(t, pattern, 0, Options{BacktrackDispatch: true})
+	if !strings.Contains(code, "BacktrackDispatch:\n\tswitch backtrack {") {
+		t.Errorf("expected a backtracking switch at the bottom of Execute in:\n%s", code)
+	}
+	if n := strings.Count(code, "goto BacktrackDispatch"); n <= backtrackDispatchThreshold {
+		t.Errorf("expected more than %v jumps to the switch, got %v", backtrackDispatchThreshold, n)
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, Options{BacktrackDispatch: true})
+	for _, input := range []string{"abcdefghgh", "abcdeegab", "abcdxd", "acdefgg", "abcdef"} {
+		runMatchLikeInterpreter(t, pattern, 0, exec, input)
+	}
+}
+
+func TestMaxUnrollSize(t *testing.T) {
+	pattern := `[a-f\d]{20}x`
+	const loop = "for i := 0; i < len(repeaterSlice); i++ {"
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, loop) {
+		t.Errorf("expected the repeater to loop by default in:\n%s", code)
+	}
+	genOpts := Options{MaxUnrollSize: 20}
+	if code := generateCodeWithOptions(t, pattern, 0, genOpts); strings.Contains(code, loop) || !strings.Contains(code, "CharIn(slice[19])") {
+		t.Errorf("expected the repeater to be unrolled in:\n%s", code)
+	}
+
+	exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "-0123456789abcdef0123x", " 0: 0123456789abcdef0123x")
+	runNoMatch(t, pattern, exec, "0123456789abcdeg0123x")
+
+	if _, err := newConverter(&bytes.Buffer{}, "main", Options{MaxUnrollSize: -1}); err == nil {
+		t.Errorf("expected an error for a negative unroll size")
+	}
+}
+
+func TestStackCookies(t *testing.T) {
+	pattern := `(?:a|ab)*?c`
+	const check = "backtracking stack imbalance detected"
+	if code := generateCode(t, pattern, 0); strings.Contains(code, check) {
+		t.Errorf("unexpected stack cookies without the option")
+	}
+	genOpts := Options{StackCookies: true}
+	code := generateCodeWithOptions(t, pattern, 0, genOpts)
+	for _, want := range []string{"r.StackPushN(0x3c020001, 1, alternation_starting_pos)", "cookie != 0x3c020000+stack_popped {", check} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+
+	for _, pattern := range []string{pattern, `(a|ab)(c|bcd)(d*)(e|ef)+?(g|gh)*(\w+)\1$`, `(?:(?!(\w)x)\w)+?y`} {
+		exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+		for _, input := range []string{"abc", "ababac", "abcdefghgh", "abcdxd", "awxby", "axy"} {
+			runMatchLikeInterpreter(t, pattern, 0, exec, input)
+		}
+	}
+
+	// skipping a pop leaves the alternation's cookie where the branch should be
+	const pop = "\talternation_starting_pos = r.StackPop()\n"
+	if !strings.Contains(code, pop) {
+		t.Fatalf("expected %q in:\n%s", pop, code)
+	}
+	out := runBrokenEngine(t, strings.Replace(code, pop, "", 1), pattern, "abc")
+	if want := "ERROR: MyPattern: backtracking stack imbalance detected, expected 0x3c020000, got 0x0"; !strings.Contains(out, want) {
+		t.Errorf("expected %q in the output:\n%s", want, out)
+	}
+}
+
+func TestGenerateAll(t *testing.T) {
+	out := &bytes.Buffer{}
+	specs := []Spec{
+		{Name: "Hex", Pattern: `0x[a-fက]+`},
+		{Name: "Ident", Pattern: `[a-fက]\w*`, Options: syntax.ECMAScript},
+		{Name: "Hex", Pattern: `[a-fက]+h`},
+		{Name: "Again", Pattern: `0x[a-fက]+`},
+	}
+	if err := GenerateAll(out, "gen", specs, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	code := out.String()
+	for want, n := range map[string]int{
+		"\npackage gen\n":        1,
+		"\nimport (\n":           1,
+		"func isInSet_":          1,
+		"_Engine) Execute(":      3,
+		"type Hex_Engine ":       1,
+		"type Hex_2_Engine ":     1,
+		"type Ident_Engine ":     1,
+		"type Again_Engine ":     0,
+		"regexp2.RegisterEngine": 3,
+	} {
+		if got := strings.Count(code, want); got != n {
+			t.Errorf("expected %q %v times, got %v", want, n, got)
+		}
+	}
+
+	out.Reset()
+	if err := GenerateAll(out, "gen", []Spec{specs[0], {Name: "Bad", Pattern: `(a`}}, Options{}); err == nil || !strings.Contains(err.Error(), "Bad: error parsing regexp") {
+		t.Errorf("expected a parse error naming the spec, got %v", err)
+	}
+	if err := GenerateAll(out, "gen", []Spec{{Name: "a-b", Pattern: "x"}}, Options{}); err == nil {
+		t.Errorf("expected an error for a name that isn't an identifier")
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing written on errors, got:\n%s", out.String())
+	}
+}
+
+func TestAsciiOnly(t *testing.T) {
+	tests := []struct {
+		pattern string
+		opts    syntax.RegexOptions
+		err     string
+	}{
+		{`[a-z]+\d{2}[x-z\x7f]`, syntax.RE2, ""},
+		{`[\w.-]+@\s`, syntax.RE2, ""},
+		{`xé+`, 0, `the char '\u00e9' isn't ASCII`},
+		{`x.*y`, 0, `the negated char '\n' matches non-ASCII chars`},
+		{`[^a]`, 0, `the negated char 'a' matches non-ASCII chars`},
+		{`[^ab]`, 0, `the set "[^ab]" matches non-ASCII chars`},
+		{`(?s:.)`, 0, `matches non-ASCII chars`},
+		{`\W`, syntax.RE2, `matches non-ASCII chars`},
+		{`naïve`, 0, `the string "na\u00efve" has non-ASCII chars`},
+		{`\p{L}+`, 0, `the set "[\\p{L}]" matches non-ASCII chars or Unicode categories`},
+		{`\d`, 0, `matches non-ASCII chars or Unicode categories`},
+		{`\s`, syntax.ECMAScript, `matches non-ASCII chars or Unicode categories`},
+		{`(?i)ok`, 0, `the set "[Kk\u212a]" matches non-ASCII chars`},
+	}
+	for _, test := range tests {
+		c, err := newConverter(&bytes.Buffer{}, "main", Options{AsciiOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.addRegexp("MyFile.go:120:10", "MyPattern", test.pattern, test.opts)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %v: %v", test.pattern, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected an error containing %q for %v, got %v", test.err, test.pattern, err)
+		}
+	}
+
+	// sets are checked inline against the lookup table, literals against string constants
+	pattern := `x[\w.\x7f]y@abc`
+	code := generateCodeWithOptions(t, pattern, syntax.RE2, Options{AsciiOnly: true})
+	if !strings.Contains(code, "(uint(slice[1]) >= 128 || asciiBitmap_") {
+		t.Errorf("expected an inline lookup table check")
+	}
+	if !strings.Contains(code, `startsWithAscii(slice[2:], "y@abc")`) {
+		t.Errorf("expected the literal compared against a string constant")
+	}
+	exec := generateAndCompileWithOptions(t, pattern, syntax.RE2, Options{AsciiOnly: true})
+	runMatch(t, pattern, exec, "éxéy@abc x_y@abc", ` 0: x_y@abc`)
+	runMatch(t, pattern, exec, "x\x7fy@abc", ` 0: x\x7fy@abc`)
+	runNoMatch(t, pattern, exec, "x-y@abc")
+}
+
+func TestHelpersPackage(t *testing.T) {
+	// every helper call goes through the renamed import
+	pattern := `\w+abc[a-f]{2}-(\d)\1\b|x[^y]*?z`
+	genOpts := Options{HelpersName: "myhelpers"}
+	code := generateCodeWithOptions(t, pattern, 0, genOpts)
+	if !strings.Contains(code, `myhelpers "github.com/dlclark/regexp2/helpers"`) {
+		t.Errorf("expected the helpers import to be renamed")
+	}
+	if n := strings.Count(code, "helpers.") - strings.Count(code, "myhelpers."); n != 0 {
+		t.Errorf("expected every helper call to use myhelpers, %v don't", n)
+	}
+	exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "--zabcde-11", " 0: zabcde-11")
+	runMatch(t, pattern, exec, "xaaz", " 0: xaaz")
+
+	// the name defaults to the last element of the path
+	code = generateCodeWithOptions(t, pattern, 0, Options{HelpersPackage: "example.com/internal/rxhelpers"})
+	if !strings.Contains(code, `"example.com/internal/rxhelpers"`) || !strings.Contains(code, "rxhelpers.IsWordChar(") {
+		t.Errorf("expected the helpers to be imported from example.com/internal/rxhelpers")
+	}
+
+	if _, err := newConverter(&bytes.Buffer{}, "main", Options{HelpersPackage: "example.com/rx-helpers"}); err == nil {
+		t.Errorf("expected an error for a helpers package name that isn't an identifier")
+	}
+}
+
+func TestInlineHelpers(t *testing.T) {
+	pattern := `\w+abc[a-f]{2}-(\d)\1\b|x[^y]*?z|[aeiouxyz]+q`
+	genOpts := Options{InlineHelpers: true}
+	code := generateCodeWithOptions(t, pattern, 0, genOpts)
+	if strings.Contains(code, "helpers.") || strings.Contains(code, `"github.com/dlclark/regexp2/helpers"`) {
+		t.Errorf("expected no use of the helpers package in:\n%s", code)
+	}
+	for _, want := range []string{"func helperIsWordChar(", "func helperNewAsciiSearchValues(", "type helperAsciiSearchValues struct"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+	// only the helpers the code uses are copied
+	if strings.Contains(code, "func helperIndexOfIgnoreCase(") {
+		t.Errorf("unexpected copy of an unused helper")
+	}
+	exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "--zabcde-11", " 0: zabcde-11")
+	runMatch(t, pattern, exec, "xaaz", " 0: xaaz")
+	runMatch(t, pattern, exec, "bbeioq", " 0: eioq")
+
+	pattern = `(?i)hello|wor?ld`
+	exec = generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "say HeLLo", " 0: HeLLo")
+	runMatch(t, pattern, exec, "the wold", " 0: wold")
+
+	// helper names in the pattern, which the comments echo, aren't taken for calls
+	pattern = `helperFoo|helperMin\d`
+	code = generateCodeWithOptions(t, pattern, 0, genOpts)
+	if strings.Contains(code, "func helperMin(") {
+		t.Errorf("unexpected copy of a helper only named in the pattern in:\n%s", code)
+	}
+	exec = generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "a helperMin1", " 0: helperMin1")
+	runMatch(t, pattern, exec, "helperFoo", " 0: helperFoo")
+
+	if _, err := newConverter(&bytes.Buffer{}, "main", Options{InlineHelpers: true, HelpersName: "myhelpers"}); err == nil {
+		t.Errorf("expected an error for inlining and renaming the helpers")
+	}
+}
+
+func TestInlineHelperDeps(t *testing.T) {
+	// each inlined helper's dependencies are the other helpers its source refers to
+	ref := regexp.MustCompile(`\b` + inlineHelpersPrefix + `([A-Z]\w*)`)
+	for name, src := range inlineHelpers {
+		var want []string
+		for _, m := range ref.FindAllStringSubmatch(src, -1) {
+			if m[1] != name && !slices.Contains(want, m[1]) {
+				want = append(want, m[1])
+			}
+		}
+		got := slices.Clone(inlineHelperDeps[name])
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("expected the dependencies of %s to be %q, got %q", name, want, got)
+		}
+	}
+
+	if _, err := usedInlineHelpers(map[string]bool{"StartsWith": true, "Foo": true}); err == nil || err.Error() != "no inlined copy of helpers.Foo" {
+		t.Errorf("expected an error for a helper without a copy, got %v", err)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	pattern := `a(b+)c`
+	genOpts := Options{RecoverPanics: true}
+	code := generateCodeWithOptions(t, pattern, 0, genOpts)
+	for _, want := range []string{"FindFirstChar(r *regexp2.Runner) (candidate bool) {", "Execute(r *regexp2.Runner) (err error) {", "if p := recover(); p != nil {"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+	exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+	runMatch(t, pattern, exec, "xabbc", " 1: bb")
+	runNoMatch(t, pattern, exec, "xabbd")
+	// the explain engine's Execute recovers too, sharing the fmt import
+	generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ExplainMatch: true, RecoverPanics: true})
+
+	// an index out of range in either method is returned from the match
+	for _, tt := range []struct {
+		after, want string
+	}{
+		{"\tpos := r.Runtextpos\n", "ERROR: MyPattern: recovered panic in FindFirstChar at 0: runtime error: index out of range [5] with length 5"},
+		{"\truntext := r.Runtext\n", "ERROR: MyPattern: recovered panic in Execute at 1: runtime error: index out of range [5] with length 5"},
+	} {
+		if !strings.Contains(code, tt.after) {
+			t.Fatalf("expected %q in:\n%s", tt.after, code)
+		}
+		broken := strings.Replace(code, tt.after, tt.after+"\t_ = r.Runtext[len(r.Runtext)]\n", 1)
+		out := runBrokenEngine(t, broken, pattern, "xabbc")
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("expected %q in the output:\n%s", tt.want, out)
+		}
+	}
+
+	// a panic in FindFirstChar that the timeout check before Execute strands doesn't fail the
+	// runner's next match
+	broken := strings.Replace(code, "\tpos := r.Runtextpos\n", `	pos := r.Runtextpos
+	if r.Runtext[0] == 's' {
+		for r.CheckTimeout() == nil {
+		}
+		panic("stranded")
+	}
+`, 1)
+	out := runBrokenEngineMain(t, broken, fmt.Sprintf(`package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+func main() {
+	re := regexp2.MustCompile(%#v, regexp2.None)
+	re.MatchTimeout = time.Millisecond
+	for _, input := range []string{"sabbc", "xabbc"} {
+		m, err := re.FindStringMatch(input)
+		fmt.Println(input, m != nil, err)
+	}
+}
+`, pattern), "")
+	for _, want := range []string{"sabbc false match timeout", "xabbc true <nil>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the output:\n%s", want, out)
+		}
+	}
+}
+
+// builds the generated code with the test main for the pattern and returns its output for the input
+func runBrokenEngine(t *testing.T, code, pattern, input string) string {
+	mainContent, _ := os.ReadFile("_runtestmain.go")
+	mainContent = bytes.Replace(mainContent, []byte("__PATTERN__"), []byte(fmt.Sprintf("%#v", pattern)), 1)
+	mainContent = bytes.Replace(mainContent, []byte("__OPTIONS__"), []byte("regexp2.None"), 1)
+	return runBrokenEngineMain(t, code, string(mainContent), input)
+}
+
+// builds the generated code with mainContent and returns its output for the input
+func runBrokenEngineMain(t *testing.T, code, mainContent, input string) string {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "gen.go"), []byte(code), 0o644)
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte(mainContent), 0o644)
+
+	goPath, _ := exec.LookPath("go")
+	bin := filepath.Join(dir, "re")
+	if out, err := exec.Command(goPath, "build", "-o", bin, filepath.Join(dir, "gen.go"), filepath.Join(dir, "main.go")).CombinedOutput(); err != nil {
+		t.Fatalf("build error: %v\n%s", err, out)
+	}
+	out, _ := exec.Command(bin, input).CombinedOutput()
+	return string(out)
+}
+
+func TestLocalPrefix(t *testing.T) {
+	pattern := `(a+?)(b|bc)\1`
+	genOpts := Options{LocalPrefix: "_rx_"}
+	code := generateCodeWithOptions(t, pattern, 0, genOpts)
+	for _, want := range []string{"_rx_pos := r.Runtextpos", "var _rx_slice = runtext[_rx_pos:]", "r.Capture(0, _rx_matchStart, _rx_pos)", "_rx_capture_starting_pos1 = _rx_pos", "\n_rx_AlternationBranch:\n"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "\tpos := ") || strings.Contains(code, "\tslice = ") {
+		t.Errorf("expected every local to be prefixed in:\n%s", code)
+	}
+
+	// the names are prefixed as they're emitted, so the raw output has them too
+	for _, genOpts := range []Options{genOpts, {LocalPrefix: "rx", RecoverPanics: true, ExplainMatch: true}, {LocalPrefix: "_rx_", SkipFormat: true}} {
+		for _, pattern := range []string{pattern, `(?:(\w)|-)+?x\1`, `[a-c]*?abc\d{2,}`} {
+			exec := generateAndCompileWithOptions(t, pattern, 0, genOpts)
+			for _, input := range []string{"aabcaa", "aaba", "-ab-x-", "ab-x-bx", "cabcabc12", "abc1"} {
+				runMatchLikeInterpreter(t, pattern, 0, exec, input)
+			}
+		}
+	}
+
+	if _, err := newConverter(&bytes.Buffer{}, "main", Options{LocalPrefix: "1x"}); err == nil {
+		t.Errorf("expected an error for a prefix that isn't an identifier")
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts := Options{HelpersName: "h", RecoverPanics: true}
+	if err := ParseOptions([]byte(`{"longest": true, "helpers": "example.com/myhelpers", "recover": false}`), &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := Options{LongestFirstAlternation: true, HelpersPackage: "example.com/myhelpers", HelpersName: "h"}
+	if opts != want {
+		t.Errorf("expected %+v, got %+v", want, opts)
+	}
+
+	if err := ParseOptionsMap(map[string]any{"localprefix": "_rx_", "setfunctable": true, "unroll": 8}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want.LocalPrefix, want.SetFuncTable, want.MaxUnrollSize = "_rx_", true, 8
+	if opts != want {
+		t.Errorf("expected %+v, got %+v", want, opts)
+	}
+
+	for _, data := range []string{`{"longets": true}`, `{"longest": "yes"}`, `[]`} {
+		if err := ParseOptions([]byte(data), &opts); err == nil {
+			t.Errorf("expected an error for %v", data)
+		}
+	}
+
+	// each option is named after the flag that sets it, which getOptions relies on
+	typ := reflect.TypeOf(Options{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Tag.Get("json")
+		if name == "" || flag.Lookup(name) == nil {
+			t.Errorf("expected a flag named after Options.%s, got %q", typ.Field(i).Name, name)
+		}
+		if !isOptionName(name) {
+			t.Errorf("expected %q to be an option name", name)
+		}
+	}
+	if isOptionName("o") {
+		t.Errorf("expected -o not to be an option name")
+	}
+}
@@ -226,11 +226,25 @@ func compileRawPattern(t *testing.T, pattern string) string {
 }
 
 func generateAndCompile(t *testing.T, pattern string, opts syntax.RegexOptions) string {
+	return generateAndCompileWithOptions(t, pattern, opts, Options{})
+}
+
+func generateAndCompileWithOptions(t *testing.T, pattern string, opts syntax.RegexOptions, genOpts Options) string {
+	return generateAndCompileMain(t, "_runtestmain.go", pattern, opts, genOpts)
+}
+
+// returns the path to an executable built from the code generated for the pattern and mainTemplate:
+// _runtestmain.go outputs the groups of the first match, _runallmain.go and _runscanmain.go every
+// match, _runstreammain.go what MatchRunes and MatchReader read, _runbenchmain.go times matching
+// (see runBench), _runenginemain.go what the engine says about itself, and _runmethodmain.go what
+// the method genOpts adds returns, e.g. FindStruct for NamedGroupStruct
+func generateAndCompileMain(t testing.TB, mainTemplate string, pattern string, opts syntax.RegexOptions, genOpts Options) string {
 	genPattern, err := os.CreateTemp("", "*.go")
 	if err != nil {
 		panic("could not create tmp file: " + err.Error())
 	}
-	c, err := newConverter(genPattern, "main")
+	genOpts.DebugAssertions = true
+	c, err := newConverter(genPattern, "main", genOpts)
 	if err != nil {
 		t.Error(errors.Wrap(err, "code generation error"))
 	}
@@ -251,7 +265,7 @@ func generateAndCompile(t *testing.T, pattern string, opts syntax.RegexOptions)
 
 	// customize the main file for this pattern
 	mainFile, _ := os.CreateTemp("", "*.go")
-	origMainFile, _ := filepath.Abs("_runtestmain.go")
+	origMainFile, _ := filepath.Abs(mainTemplate)
 	mainContent, _ := os.ReadFile(origMainFile)
 	mainContent = bytes.Replace(mainContent, []byte("__PATTERN__"), []byte(fmt.Sprintf("%#v", pattern)), 1)
 	mainContent = bytes.Replace(mainContent, []byte("__OPTIONS__"), []byte(fmt.Sprintf("%#v", opts)), 1)
@@ -270,6 +284,22 @@ func generateAndCompile(t *testing.T, pattern string, opts syntax.RegexOptions)
 	return outFile.Name()
 }
 
+// runs the benchmark executable for b.N matches of the input and reports the time per match
+func runBench(b *testing.B, reExec string, input string) {
+	if len(reExec) == 0 {
+		return
+	}
+	out, err := exec.Command(reExec, input, strconv.Itoa(b.N)).CombinedOutput()
+	if err != nil {
+		b.Fatalf("error running benchmark: %v %s", err, out)
+	}
+	ns, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		b.Fatalf("unexpected benchmark output: %s", out)
+	}
+	b.ReportMetric(float64(ns)/float64(b.N), "match-ns/op")
+}
+
 func matchString(t *testing.T, pattern string, reExec string, toMatch string) string {
 	if len(reExec) == 0 {
 		return ""
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/dlclark/regexp2"
 	"github.com/dlclark/regexp2/syntax"
 )
 
@@ -20,3 +23,140 @@ func TestRightToLeft_StartAt(t *testing.T) {
 
 	runMatch(t, pattern, exec, "0123", " 0: 3")
 }
+
+func TestRightToLeft_CaptureBounds(t *testing.T) {
+	// pos moves left while matching, the runner orders the capture's bounds
+	pattern := `(\d+)`
+	exec := generateAndCompileMain(t, "_runallmain.go", pattern, syntax.RightToLeft, Options{})
+	runMatch(t, pattern, exec, "abc123", "3: 123")
+	// the search for the next match runs off the start of the input
+	runMatch(t, pattern, exec, "12abc345", "5: 345")
+	runMatch(t, pattern, exec, "12abc345", "0: 12")
+	runMatch(t, pattern, exec, "5", "0: 5")
+
+	pattern = `([a-z]+)(\d+)`
+	exec = generateAndCompile(t, pattern, syntax.RightToLeft)
+
+	// compare to the interpreter, nothing's registered in this process
+	m, err := regexp2.MustCompile(pattern, regexp2.RightToLeft).FindStringMatch("x abc123")
+	if err != nil || m == nil {
+		t.Fatalf("interpreter didn't match: %v", err)
+	}
+	for i, g := range m.Groups() {
+		if g.Index < 0 || g.Length <= 0 {
+			t.Fatalf("unexpected interpreter bounds for group %v: %v, %v", i, g.Index, g.Length)
+		}
+		runMatch(t, pattern, exec, "x abc123", fmt.Sprintf("%2v: %s", i, g.String()))
+	}
+}
+
+func BenchmarkRightToLeft_SetLoop(b *testing.B) {
+	// the loop reads runtext[pos-iteration-1] for every char of the long runs of letters
+	input := strings.Repeat("x"+strings.Repeat("abcdefghij", 50)+" ", 40)
+	exec := generateAndCompileMain(b, "_runbenchmain.go", `[a-z]+\d`, syntax.RightToLeft, Options{})
+	b.ResetTimer()
+	runBench(b, exec, input)
+}
+
+func TestRightToLeft_WholePatternChars(t *testing.T) {
+	// FindFirstChar finds the whole match, which ends at pos and starts to the left of it
+	for pattern, want := range map[string][]string{
+		`b`:     {"3: b", "1: b"},
+		`[^ab]`: {"4: c", "2: c", "0: x"},
+		`[b-c]`: {"4: c", "3: b", "2: c", "1: b"},
+		`\d`:    {"5: 1"},
+		`bcb`:   {"1: bcb"},
+	} {
+		if code := generateCode(t, pattern, syntax.RightToLeft); !strings.Contains(code, "r.Capture(0, end, start)") {
+			t.Errorf("expected the capture to start at the end for %v in:\n%s", pattern, code)
+		}
+		exec := generateAndCompileMain(t, "_runallmain.go", pattern, syntax.RightToLeft, Options{})
+		for _, w := range want {
+			runMatch(t, pattern, exec, "xbcbc1", w)
+		}
+		exec = generateAndCompile(t, pattern, syntax.RightToLeft)
+		for _, input := range []string{"xbcbc1", "b", "a", ""} {
+			runMatchLikeInterpreter(t, pattern, syntax.RightToLeft, exec, input)
+		}
+	}
+}
+
+func TestRightToLeft_JoinedLengthCheck(t *testing.T) {
+	// one check that there are 7 chars before pos instead of one for each node
+	pattern := `(?<=ab\dc{3}x)y`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, "if pos < 7 {") || strings.Contains(code, "newIdx") || strings.Contains(code, "lastIdx") {
+		t.Errorf("expected a single length check for the lookbehind")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "ab1cccxy", " 0: y")
+	runMatch(t, pattern, exec, "zab1cccxy", " 0: y")
+	runNoMatch(t, pattern, exec, "b1cccxy")
+	runNoMatch(t, pattern, exec, "y")
+
+	pattern = `ab(\d)cx`
+	exec = generateAndCompile(t, pattern, syntax.RightToLeft)
+	runMatch(t, pattern, exec, "ab1cxab2cxq", " 1: 2")
+	runNoMatch(t, pattern, exec, "b1cx")
+}
+
+func TestRightToLeft_LeadingString(t *testing.T) {
+	pattern := `abc`
+	exec := generateAndCompileMain(t, "_runallmain.go", pattern, syntax.RightToLeft, Options{})
+	runMatch(t, pattern, exec, "xabcyabcz", "5: abc")
+	runMatch(t, pattern, exec, "xabcyabcz", "1: abc")
+}
+
+func TestRightToLeft_AtomicZeroOrOne(t *testing.T) {
+	// the a? in the lookbehind is atomic and read right to left, it's only checked when
+	// there's a char before pos and it consumes at most one
+	pattern := `(?<=a?)b`
+	if code := generateCode(t, pattern, 0); !strings.Contains(code, "if pos > 0 && runtext[pos-1] == 'a' {") {
+		t.Errorf("expected the optional char to check there's a char before pos")
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "b", " 0: b")
+	runMatch(t, pattern, exec, "ab", " 0: b")
+	runMatch(t, pattern, exec, "xb", " 0: b")
+
+	// a second a before the b isn't consumed, so the x has to be right before the a
+	pattern = `(?<=xa?)b`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "xab", " 0: b")
+	runMatch(t, pattern, exec, "xb", " 0: b")
+	runNoMatch(t, pattern, exec, "xaab")
+	runNoMatch(t, pattern, exec, "ab")
+
+	// at the start of the input there's nothing to read before the a?
+	pattern = `(?<=^a?)b`
+	exec = generateAndCompile(t, pattern, 0)
+	runMatch(t, pattern, exec, "b", " 0: b")
+	runMatch(t, pattern, exec, "ab", " 0: b")
+	runNoMatch(t, pattern, exec, "aab")
+}
+
+func TestRightToLeft_Multi(t *testing.T) {
+	// the literal in the lookbehind is matched right to left, ending at the x
+	pattern := `(?<=abc)x`
+	code := generateCode(t, pattern, 0)
+	if !strings.Contains(code, `if !endsWith(runtext[:pos], []rune("abc")) {`) || !strings.Contains(code, "func endsWith(") {
+		t.Errorf("expected the literal to be matched with the endsWith helper in:\n%s", code)
+	}
+	exec := generateAndCompile(t, pattern, 0)
+	for _, input := range []string{"abcx", "zabcx", "xabcx", "bcx", "cx", "x", "abdx", "zbcx", "abcabx"} {
+		runMatchLikeInterpreter(t, pattern, 0, exec, input)
+	}
+
+	// after a set the concatenation's length check covers the literal
+	pattern = `(?<=abc\d)x`
+	exec = generateAndCompile(t, pattern, 0)
+	for _, input := range []string{"abc1x", "bc1x", "abcx", "1x"} {
+		runMatchLikeInterpreter(t, pattern, 0, exec, input)
+	}
+
+	pattern = `ab\d+cd`
+	exec = generateAndCompile(t, pattern, syntax.RightToLeft)
+	for _, input := range []string{"ab12cd", "ab12cdab3cd", "b1cd", "cd"} {
+		runMatchLikeInterpreter(t, pattern, syntax.RightToLeft, exec, input)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// Reports if the engine gets a Regexp of its own for its methods to match with, see emitEngineRegexp
+func (c *converter) needsEngineRegexp(rm *regexpData) bool {
+	return c.opts.ScanMethod || c.needsFindStruct(rm) || c.opts.ByteOffsets || c.opts.GroupMap
+}
+
+// Emits the Regexp that Scan and the methods returning groups match with, compiled in init once the engine is registered.
+func (c *converter) emitEngineRegexp(rm *regexpData) {
+	c.writeLineFmt(`// the Regexp for the engine's methods, set in init once the engine is registered
+		var %s_regexp *regexp2.Regexp
+		`, rm.GeneratedName)
+}
+
+// Emits Scan, which finds the first match from a starting position. The loop that tries each
+// position FindFirstChar finds, checks the timeout and bumps along is regexp2's own: the match
+// and stacks that Execute fills in are unexported in Runner, so only regexp2 can set one up.
+func (c *converter) emitScan(rm *regexpData) {
+	c.writeLineFmt(`// Scan finds the first match in input with this engine's FindFirstChar and Execute, starting
+		// the search at startAt, or at the start of input (the end for RightToLeft) if startAt is negative.
+		// It returns nil if there's no match.
+		func (%[1]s_Engine) Scan(input []rune, startAt int) (*regexp2.Match, error) {
+			return %[1]s_regexp.FindRunesMatchStartingAt(input, startAt)
+		}
+		`, rm.GeneratedName)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		pattern, input, expected string
+	}{
+		{`\d+`, "a12b345", "1: 12\n4: 345\n"},
+		{`\d+`, "abc", ""},
+		{`a*`, "bab", "0: \n1: a\n2: \n3: \n"},
+		{`^\w+`, "ab cd", "0: ab\n"},
+		{`(?<=a)b`, "abbab", "1: b\n4: b\n"},
+	}
+	for _, test := range tests {
+		exec := generateAndCompileMain(t, "_runscanmain.go", test.pattern, 0, Options{ScanMethod: true})
+		if got := matchString(t, test.pattern, exec, test.input); got != test.expected {
+			t.Errorf("pattern %v input %q: expected %q, got %q", test.pattern, test.input, test.expected, got)
+		}
+	}
+}
+
+func TestScan_WithFindStruct(t *testing.T) {
+	// both methods match with the same Regexp
+	pattern := `(?<Year>\d{4})-(?<Month>\d{2})`
+	code := generateCodeWithOptions(t, pattern, 0, Options{ScanMethod: true, NamedGroupStruct: true})
+	if n := strings.Count(code, "var MyPattern_regexp *regexp2.Regexp"); n != 1 {
+		t.Errorf("expected the Regexp to be declared once, found %v", n)
+	}
+	if !strings.Contains(code, "func (MyPattern_Engine) Scan(") || !strings.Contains(code, "func (MyPattern_Engine) FindStruct(") {
+		t.Errorf("expected both Scan and FindStruct")
+	}
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{ScanMethod: true, NamedGroupStruct: true})
+	runMatch(t, pattern, exec, "on 2024-10-16", "{Year:2024 Month:10} true")
+}
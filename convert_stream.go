@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+// Reports if the pattern can be matched against runes pulled one at a time: it has to
+// be a straight line of single chars, strings and loops that never give anything back.
+// Anchors are only allowed at the start, where they always hold.
+func canStreamMatch(node *syntax.RegexNode) bool {
+	return canStreamMatchNode(node, true)
+}
+
+func canStreamMatchNode(node *syntax.RegexNode, atStart bool) bool {
+	if node.Options&syntax.RightToLeft != 0 {
+		return false
+	}
+
+	switch node.T {
+	case syntax.NtCapture, syntax.NtGroup, syntax.NtAtomic:
+		return canStreamMatchNode(node.Children[0], atStart)
+
+	case syntax.NtConcatenate:
+		for i, child := range node.Children {
+			if !canStreamMatchNode(child, atStart && i == 0) {
+				return false
+			}
+		}
+		return true
+
+	case syntax.NtOne, syntax.NtNotone, syntax.NtSet, syntax.NtMulti,
+		syntax.NtEmpty, syntax.NtEnd, syntax.NtUpdateBumpalong,
+		syntax.NtOneloopatomic, syntax.NtNotoneloopatomic, syntax.NtSetloopatomic:
+		return true
+
+	case syntax.NtOneloop, syntax.NtNotoneloop, syntax.NtSetloop,
+		syntax.NtOnelazy, syntax.NtNotonelazy, syntax.NtSetlazy:
+		// anything else needs backtracking
+		return node.M == node.N
+
+	case syntax.NtBeginning, syntax.NtStart, syntax.NtBol:
+		return atStart
+	}
+
+	return false
+}
+
+// Emits MatchRunes, which matches the pattern at the start of the runes pulled from next.
+// The current rune is held in ch until a node consumes it, which is all the lookahead
+// an atomic loop needs to find its end.
+func (c *converter) emitMatchRunes(rm *regexpData) {
+	c.writeLineFmt(`// MatchRunes reports whether the start of the runes pulled from next matches the pattern.
+		// It only pulls as many runes as it needs to decide.
+		func (%s_Engine) MatchRunes(next func() (rune, bool)) bool {
+		var ch rune
+		var have bool
+		// loads the next rune into ch if the last one was consumed
+		read := func() bool {
+			if !have {
+				ch, have = next()
+			}
+			return have
+		}
+		`, rm.GeneratedName)
+
+	c.emitMatchRunesNode(rm, rm.Tree.Root)
+
+	c.writeLine(`// just to prevent an unused var error in certain regex's
+		var _ = read
+		return true
+		}
+		`)
+}
+
+func (c *converter) emitMatchRunesNode(rm *regexpData, node *syntax.RegexNode) {
+	switch node.T {
+	case syntax.NtCapture, syntax.NtGroup, syntax.NtAtomic:
+		c.emitMatchRunesNode(rm, node.Children[0])
+
+	case syntax.NtConcatenate:
+		for _, child := range node.Children {
+			c.emitMatchRunesNode(rm, child)
+		}
+
+	case syntax.NtOne, syntax.NtNotone, syntax.NtSet:
+		c.writeLineFmt("// %s", describeNode(rm, node))
+		c.writeLineFmt("if !read() || %s {\nreturn false\n}\nhave = false\n", c.emitMatchRunesCharExpr(rm, node, true))
+
+	case syntax.NtMulti:
+		c.writeLineFmt("// %s", describeNode(rm, node))
+		c.writeLineFmt(`for _, want := range %s {
+			if !read() || ch != want {
+				return false
+			}
+			have = false
+		}
+		`, getRuneSliceLiteral(node.Str))
+
+	case syntax.NtOneloop, syntax.NtNotoneloop, syntax.NtSetloop,
+		syntax.NtOnelazy, syntax.NtNotonelazy, syntax.NtSetlazy,
+		syntax.NtOneloopatomic, syntax.NtNotoneloopatomic, syntax.NtSetloopatomic:
+		c.writeLineFmt("// %s", describeNode(rm, node))
+		if node.M > 0 {
+			c.writeLineFmt("for i := 0; i < %v; i++ {\nif !read() || %s {\nreturn false\n}\nhave = false\n}", node.M, c.emitMatchRunesCharExpr(rm, node, true))
+		}
+		if node.N == math.MaxInt32 {
+			c.writeLineFmt("for read() && %s {\nhave = false\n}", c.emitMatchRunesCharExpr(rm, node, false))
+		} else if node.N > node.M {
+			c.writeLineFmt("for i := %v; i < %v && read() && %s; i++ {\nhave = false\n}", node.M, node.N, c.emitMatchRunesCharExpr(rm, node, false))
+		}
+		c.writeLine("")
+
+	case syntax.NtEnd:
+		c.writeLineFmt("// %s\nif read() {\nreturn false\n}\n", describeNode(rm, node))
+	}
+}
+
+// Emits MatchReader, which runs MatchRunes over the runes read from an io.RuneReader, e.g. a
+// bufio.Reader over a file or connection, so the input is never held in memory.  Like
+// MatchRunes it reads one rune past the match at most.
+func (c *converter) emitMatchReader(rm *regexpData) {
+	c.writeLineFmt(`// MatchReader reports whether the start of the runes read from r matches the pattern.
+		// It only reads as many runes as it needs to decide.  io.EOF ends the input, any other
+		// read error stops the match and is returned.
+		func (e %s_Engine) MatchReader(r io.RuneReader) (bool, error) {
+		var err error
+		matched := e.MatchRunes(func() (rune, bool) {
+			if err != nil {
+				return 0, false
+			}
+			ch, _, readErr := r.ReadRune()
+			if readErr != nil {
+				if readErr != io.EOF {
+					err = readErr
+				}
+				return 0, false
+			}
+			return ch, true
+		})
+		if err != nil {
+			return false, err
+		}
+		return matched, nil
+		}
+		`, rm.GeneratedName)
+}
+
+// the expression for ch matching the single char node, or not matching it if negate is set
+func (c *converter) emitMatchRunesCharExpr(rm *regexpData, node *syntax.RegexNode, negate bool) string {
+	if node.IsSetFamily() {
+		return c.emitMatchCharacterClass(rm, node.Set, negate, "ch")
+	}
+	if node.IsOneFamily() == negate {
+		return fmt.Sprintf("ch != %q", node.Ch)
+	}
+	return fmt.Sprintf("ch == %q", node.Ch)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreamMatch_Digits(t *testing.T) {
+	pattern := `\d{4}`
+	exec := generateAndCompileMain(t, "_runstreammain.go", pattern, 0, Options{StreamMatch: true})
+	runMatch(t, pattern, exec, "2024", "Match: true, Read: 4")
+	// stops pulling once it has the match
+	runMatch(t, pattern, exec, "20241016", "Match: true, Read: 4")
+	// and at the first rune that doesn't match
+	runMatch(t, pattern, exec, "20x41016", "Match: false, Read: 3")
+	runMatch(t, pattern, exec, "202", "Match: false, Read: 3")
+}
+
+func TestStreamMatch_Loops(t *testing.T) {
+	pattern := `^id-[a-z]+:\d{1,3}\z`
+	exec := generateAndCompileMain(t, "_runstreammain.go", pattern, 0, Options{StreamMatch: true})
+	runMatch(t, pattern, exec, "id-abc:12", "Match: true, Read: 9")
+	runMatch(t, pattern, exec, "id-abc:1234", "Match: false, Read: 11")
+	runMatch(t, pattern, exec, "id-:12", "Match: false, Read: 4")
+	runMatch(t, pattern, exec, "ix-abc:12", "Match: false, Read: 2")
+
+	pattern = `(?i)ab[^c]*`
+	exec = generateAndCompileMain(t, "_runstreammain.go", pattern, 0, Options{StreamMatch: true})
+	runMatch(t, pattern, exec, "ABxyc", "Match: true, Read: 5")
+}
+
+func TestStreamMatch_Reader(t *testing.T) {
+	pattern := `^id-[a-z]+:\d{1,3}`
+	exec := generateAndCompileMain(t, "_runstreammain.go", pattern, 0, Options{StreamMatch: true})
+	// one rune of lookahead past the match, the same as MatchRunes
+	runMatch(t, pattern, exec, "id-abc:12", "Reader: true, Read: 9, Err: <nil>")
+	runMatch(t, pattern, exec, "id-abc:123456", "Reader: true, Read: 10, Err: <nil>")
+	runMatch(t, pattern, exec, "ix-abc:12", "Reader: false, Read: 2, Err: <nil>")
+
+	// a read error is only seen if the match needs to read that far
+	runMatch(t, pattern, exec, "id-abc:12", "Failing reader: false, Err: read failed")
+	runMatch(t, pattern, exec, "id-abc:123x", "Failing reader: true, Err: <nil>")
+	runMatch(t, pattern, exec, "id-", "Failing reader: false, Err: read failed")
+}
+
+func TestStreamMatch_Unsupported(t *testing.T) {
+	if code := generateCodeWithOptions(t, `a\d+`, 0, Options{StreamMatch: true}); !strings.Contains(code, "MatchRunes") || !strings.Contains(code, "MatchReader(r io.RuneReader)") {
+		t.Errorf("expected MatchRunes and MatchReader for a\\d+")
+	}
+
+	// these need backtracking or lookbehind, so there's no MatchRunes
+	for _, pattern := range []string{`\w+\d`, `ab|cd`, `a^`, `a$`, `(?<=a)b`, `(a)\1`} {
+		if code := generateCodeWithOptions(t, pattern, 0, Options{StreamMatch: true}); strings.Contains(code, "MatchRunes") {
+			t.Errorf("unexpected MatchRunes for %v", pattern)
+		}
+	}
+}
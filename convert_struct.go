@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Returns the names of the pattern's named groups, in group number order
+func namedGroups(rm *regexpData) []string {
+	var names []string
+	for _, name := range rm.Tree.Caplist {
+		if _, err := strconv.Atoi(name); err != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns an exported Go field name for each group name. Names are capitalized, prefixed
+// with G if they can't be, and numbered if that makes two of them the same.
+func structFieldNames(names []string) []string {
+	fields := make([]string, len(names))
+	used := make(map[string]bool)
+	for i, name := range names {
+		runes := []rune(name)
+		runes[0] = unicode.ToUpper(runes[0])
+		field := string(runes)
+		if !unicode.IsUpper(runes[0]) {
+			field = "G" + field
+		}
+		// the numbered name can be another group's name too, e.g. A2 for a after A and A2
+		base := field
+		for n := 2; used[field]; n++ {
+			field = fmt.Sprint(base, n)
+		}
+		used[field] = true
+		fields[i] = field
+	}
+	return fields
+}
+
+// Emits a struct with a field per named group and a FindStruct method that fills it in
+// from the first match.
+func (c *converter) emitFindStruct(rm *regexpData) {
+	names := namedGroups(rm)
+	fields := structFieldNames(names)
+
+	c.writeLineFmt("// %s_Result holds the named groups of a match of %[1]s_Engine", rm.GeneratedName)
+	c.writeLineFmt("type %s_Result struct {", rm.GeneratedName)
+	for _, f := range fields {
+		c.writeLineFmt("%s string", f)
+	}
+	c.writeLine("}\n")
+
+	c.writeLineFmt(`// FindStruct finds the first match in s and returns its named groups, unset groups are empty.
+		// It reports false if there's no match.
+		func (%[1]s_Engine) FindStruct(s string) (%[1]s_Result, bool) {
+			m, err := %[1]s_regexp.FindStringMatch(s)
+			if err != nil || m == nil {
+				return %[1]s_Result{}, false
+			}
+			return %[1]s_Result{`, rm.GeneratedName)
+	for i, f := range fields {
+		c.writeLineFmt("%s: m.GroupByName(%s).String(),", f, getGoLiteral(names[i]))
+	}
+	c.writeLine("}, true\n}\n")
+}
+
+// Reports if FindStruct is emitted for the pattern
+func (c *converter) needsFindStruct(rm *regexpData) bool {
+	return c.opts.NamedGroupStruct && len(namedGroups(rm)) > 0
+}
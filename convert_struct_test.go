@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFindStruct(t *testing.T) {
+	pattern := `(?<Year>\d{4})-(?<Month>\d{2})`
+	exec := generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{NamedGroupStruct: true})
+	runMatch(t, pattern, exec, "on 2024-10-16", "{Year:2024 Month:10} true")
+	runMatch(t, pattern, exec, "2024/10", "{Year: Month:} false")
+
+	// unset groups are empty, numbered groups aren't in the struct
+	pattern = `(?<year>\d{4})(-(?<month>\d{2}))?`
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{NamedGroupStruct: true})
+	runMatch(t, pattern, exec, "2024", "{Year:2024 Month:} true")
+	runMatch(t, pattern, exec, "2024-10", "{Year:2024 Month:10} true")
+
+	// a is numbered past the A2 group's field
+	pattern = `(?<A>x)(?<A2>y)(?<a>z)`
+	exec = generateAndCompileMain(t, "_runmethodmain.go", pattern, 0, Options{NamedGroupStruct: true})
+	runMatch(t, pattern, exec, "xyz", "{A:x A2:y A3:z} true")
+}
+
+func TestFindStruct_NoNames(t *testing.T) {
+	if code := generateCodeWithOptions(t, `(\d{4})-(\d{2})`, 0, Options{NamedGroupStruct: true}); strings.Contains(code, "FindStruct") {
+		t.Errorf("unexpected FindStruct for a pattern without named groups")
+	}
+}
+
+func TestStructFieldNames(t *testing.T) {
+	got := structFieldNames([]string{"year", "Year", "_x", "日付", "ünit"})
+	want := []string{"Year", "Year2", "G_x", "G日付", "Ünit"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	got = structFieldNames([]string{"A", "A2", "a", "b", "B", "B2"})
+	want = []string{"A", "A2", "A3", "B", "B2", "B22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
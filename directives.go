@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+// marks a pattern to generate in a source file, e.g.
+//
+//	//regexp2cg:generate Date = `(\d{4})-(\d{2})`, regexp2.ECMAScript
+const directivePrefix = "//regexp2cg:generate"
+
+// a pattern from a //regexp2cg:generate comment
+type directive struct {
+	name    string
+	pattern string
+	opts    syntax.RegexOptions
+	pos     token.Pos
+}
+
+// Returns the //regexp2cg:generate directives in the file's comments, in source order.
+// Each is a Go identifier, "=", the pattern as a Go string literal and, optionally, a comma
+// and the options written the same as in a regexp2.MustCompile call.
+func parseDirectives(fset *token.FileSet, file *ast.File) ([]directive, error) {
+	var retval []directive
+	names := make(map[string]token.Pos)
+
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			rest, ok := strings.CutPrefix(comment.Text, directivePrefix)
+			if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+				continue
+			}
+			d, err := parseDirective(rest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: invalid %s directive", fset.Position(comment.Pos()), directivePrefix)
+			}
+			if prev, ok := names[d.name]; ok {
+				return nil, fmt.Errorf("%s: %s is already generated at %s", fset.Position(comment.Pos()), d.name, fset.Position(prev))
+			}
+			names[d.name] = comment.Pos()
+			d.pos = comment.Pos()
+			retval = append(retval, d)
+		}
+	}
+
+	return retval, nil
+}
+
+// parses what follows the directive prefix, e.g. ` Name = "pattern", regexp2.IgnoreCase`
+func parseDirective(text string) (directive, error) {
+	name, spec, ok := strings.Cut(text, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !token.IsIdentifier(name) {
+		return directive{}, errors.New(`expected Name = "pattern"`)
+	}
+
+	// the rest reads as the arguments to MustCompile, so parse it as a call and
+	// reuse what finds the patterns in code
+	expr, err := parser.ParseExpr("regexp2.MustCompile(" + spec + ")")
+	if err != nil {
+		return directive{}, errors.Wrap(err, "unable to parse the pattern and options")
+	}
+	if call, ok := expr.(*ast.CallExpr); ok && len(call.Args) == 1 {
+		call.Args = append(call.Args, &ast.BasicLit{Kind: token.INT, Value: "0"})
+	}
+	ok, pattern, opts, _ := isStaticCompileCall(expr, "regexp2")
+	if !ok {
+		return directive{}, errors.New("expected a string literal pattern and constant options")
+	}
+
+	return directive{name: name, pattern: pattern, opts: syntax.RegexOptions(opts)}, nil
+}
+
+// Generates the engines for the //regexp2cg:generate directives in a Go source file. The
+// file is written next to the source with a _gen.go suffix unless -o is given, and declares
+// a *regexp2.Regexp for each directive's name that uses the engine.
+func convertDirectives(path string) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		log.Fatalf("unable to parse go file: %v", err)
+	}
+
+	directives, err := parseDirectives(fset, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(directives) == 0 {
+		log.Fatalf("no %s directives found in %s", directivePrefix, path)
+	}
+
+	if out == nil || len(*out) == 0 {
+		*out = strings.TrimSuffix(path, ".go") + "_gen.go"
+	}
+	stream, outFile := getOutStream()
+	if stream == nil {
+		log.Fatalf("unable to open output")
+	}
+	if f, ok := stream.(*os.File); ok {
+		defer f.Close()
+	}
+
+	c, err := newConverter(stream, file.Name.Name, getOptions())
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "code generation error"))
+	}
+	for _, d := range directives {
+		log.Printf("%s: adding %s pattern %#v options %v", fset.Position(d.pos), d.name, d.pattern, d.opts)
+		if err := c.addRegexp(getLocation(fset, d.pos, outFile), d.name, d.pattern, d.opts); err != nil {
+			log.Fatal(errors.Wrap(err, "code generation error"))
+		}
+		c.addRegexpVar(d.name, d.pattern, d.opts)
+	}
+	if err := c.addFooter(); err != nil {
+		log.Fatal(errors.Wrap(err, "code generation error"))
+	}
+	writeDiffTest(c, outFile)
+	writeBenchTest(c, outFile)
+}
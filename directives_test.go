@@ -0,0 +1,96 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2/syntax"
+)
+
+func parseTestDirectives(t *testing.T, src string) ([]directive, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "pats.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseDirectives(fset, file)
+}
+
+func TestParseDirectives(t *testing.T) {
+	ds, err := parseTestDirectives(t, "package pats\n\n"+
+		"//go:generate regexp2cg -directives $GOFILE\n\n"+
+		"//regexp2cg:generate Date = `(\\d{4})-(\\d{2})`\n"+
+		"//regexp2cg:generate Word = \"[a-z]+\", regexp2.IgnoreCase|regexp2.Multiline\n"+
+		"func f() {\n"+
+		"\t//regexp2cg:generate Num=\"\\\\d+\",256\n"+
+		"}\n"+
+		"//regexp2cg:generated Nope = \"x\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []directive{
+		{name: "Date", pattern: `(\d{4})-(\d{2})`},
+		{name: "Word", pattern: "[a-z]+", opts: syntax.IgnoreCase | syntax.Multiline},
+		{name: "Num", pattern: `\d+`, opts: syntax.ECMAScript},
+	}
+	if len(ds) != len(want) {
+		t.Fatalf("expected %v directives, got %+v", len(want), ds)
+	}
+	for i, d := range ds {
+		d.pos = 0
+		if d != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], d)
+		}
+	}
+}
+
+func TestParseDirectives_Errors(t *testing.T) {
+	for src, wantErr := range map[string]string{
+		`//regexp2cg:generate "x"`:                                       `pats.go:3:1: invalid //regexp2cg:generate directive: expected Name = "pattern"`,
+		`//regexp2cg:generate 1a = "x"`:                                  `expected Name = "pattern"`,
+		`//regexp2cg:generate A = "x`:                                    "unable to parse the pattern and options",
+		`//regexp2cg:generate A = x`:                                     "expected a string literal pattern and constant options",
+		`//regexp2cg:generate A = "x", regexp2.Unknown`:                  "expected a string literal pattern and constant options",
+		"//regexp2cg:generate A = \"x\"\n//regexp2cg:generate A = \"y\"": "pats.go:4:1: A is already generated at pats.go:3:1",
+	} {
+		_, err := parseTestDirectives(t, "package pats\n\n"+src+"\n")
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("expected an error containing %q for %q, got %v", wantErr, src, err)
+		}
+	}
+}
+
+func TestRegexpVars(t *testing.T) {
+	out := &strings.Builder{}
+	c, err := newConverter(out, "pats", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Word", "Same"} {
+		if err := c.addRegexp("pats.go:3:1", name, "[a-z]+", syntax.IgnoreCase); err != nil {
+			t.Fatal(err)
+		}
+		c.addRegexpVar(name, "[a-z]+", syntax.IgnoreCase)
+	}
+	if err := c.addFooter(); err != nil {
+		t.Fatal(err)
+	}
+	code := out.String()
+	for _, want := range []string{
+		"\tWord *regexp2.Regexp\n",
+		"\tSame *regexp2.Regexp\n",
+		// compiled after the engine is registered
+		"regexp2.RegisterEngine(\"[a-z]+\", regexp2.IgnoreCase, &Word_Engine{})\n" +
+			"\tWord = regexp2.MustCompile(\"[a-z]+\", regexp2.IgnoreCase)\n" +
+			"\tSame = regexp2.MustCompile(\"[a-z]+\", regexp2.IgnoreCase)\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "Same_Engine") {
+		t.Errorf("expected the same pattern to share an engine")
+	}
+}
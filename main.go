@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -24,12 +25,48 @@ var expr = flag.String("expr", "", "the regexp to convert to Go code to output f
 var opt = flag.Int("opt", 0, "bitwise options to use when compiling the regexp")
 var pkg = flag.String("package", "regexp2codegen", "package to use when converting a single regexp")
 
+// or generate the patterns given by directives in a source file
+var directivesFile = flag.String("directives", "", "go source file to read //regexp2cg:generate directives from, e.g. $GOFILE in a //go:generate comment")
+
 // if not single regex then scan the path and convert all regex's we find, optionally including test files
 var path = flag.String("path", ".", "file path to scan and generate regexp's for")
 var tests = flag.Bool("test", false, "true if go tests should be scanned as well")
 
 // universal options
 var out = flag.String("o", "", "output file to write generated regexp code into, if the file exists overwrites it. defaults to stdout")
+var interiorLiteral = flag.Bool("interiorliteral", false, "check that a literal required in the middle of the pattern occurs in the input before searching for a match")
+var streamMatch = flag.Bool("stream", false, "experimental: also generate a MatchRunes method that matches runes pulled from a callback, for simple patterns that never backtrack")
+var namedGroupStruct = flag.Bool("struct", false, "for patterns with named groups, also generate a struct of the groups and a FindStruct method that returns it")
+var byteOffsets = flag.Bool("byteoffsets", false, "also generate a FindStringSubmatchIndex method that returns the groups of the first match as byte offsets, like regexp.Regexp's")
+var groupMap = flag.Bool("groupmap", false, "also generate a Match method that returns the groups of the first match in a map by name or number")
+var groupMapEmpty = flag.Bool("groupmapempty", false, "with -groupmap, include the groups that didn't match as empty strings instead of leaving them out")
+var explainMatch = flag.Bool("explain", false, "also generate an ExplainMatch method that returns a trace of matching an input, for debugging patterns")
+var scanMethod = flag.Bool("scan", false, "also generate a Scan method that finds the first match from a starting position with the engine")
+var leadingSetTable = flag.Bool("leadingsettable", false, "search for leading ASCII sets with a lookup table shared with the rest of the generated code")
+var annotateNodes = flag.Bool("annotate", false, "add each node's depth and path in the tree dump to the comments in the generated Execute")
+var decisionComments = flag.Bool("decisions", false, "add comments to the generated Execute on why each node's code was emitted the way it was")
+var chunkedPrefixScan = flag.Bool("chunkedprefix", false, "search for long ASCII literal prefixes a block of positions at a time")
+var horspoolPrefixScan = flag.Bool("horspool", false, "search for long ASCII literal prefixes with a Boyer-Moore-Horspool skip table")
+var binarySearchSets = flag.Bool("binarysearchsets", false, "check sets of many non-ASCII ranges with a binary search over the range boundaries")
+var setFuncTable = flag.Bool("setfunctable", false, "check each pattern's complex sets by calling closures in a table per pattern instead of inlining the checks")
+var asciiOnly = flag.Bool("asciionly", false, "fail for patterns that can match non-ASCII chars, e.g. literals of 0x80 and up, . and [^a], or Unicode categories")
+var entryTimeout = flag.Bool("entrytimeout", false, "check the match timeout once at the start of each match attempt, for patterns run over very large inputs")
+var recoverPanics = flag.Bool("recover", false, "recover panics in the generated engines and return them as errors from the match instead of crashing")
+var backtrackSwitch = flag.Bool("backtrackswitch", false, "jump to the backtracking code through one switch at the bottom of Execute, for patterns that backtrack to many places")
+var unroll = flag.Int("unroll", defaultMaxUnrollSize, "the most chars of a fixed-length repeater like [a-f]{8} to check one by one instead of in a loop")
+var noFormat = flag.Bool("noformat", false, "write the generated code without running it through gofmt, for debugging")
+var debugAssertions = flag.Bool("debugassertions", false, "panic if the converter's bookkeeping goes wrong while emitting, for debugging the generator")
+var stackCookies = flag.Bool("stackcookies", false, "check the backtracking stack is popped in step with how it was pushed and return an error from the match if not, for debugging the generator")
+var diffTest = flag.Bool("difftest", false, "also write a _test.go file next to the output file that checks the generated engines against the regexp2 interpreter")
+var benchTest = flag.Bool("bench", false, "also write a _bench_test.go file next to the output file that benchmarks the generated engines against the regexp2 interpreter")
+var benchInput = flag.String("benchinput", "", "file with the input for the -bench benchmarks to run over, defaults to each pattern's chars repeated")
+var dot = flag.Bool("dot", false, "with -expr, write the pattern's parse tree as a Graphviz DOT graph instead of generating code")
+var helpersPackage = flag.String("helpers", defaultHelpersPackage, "import path of the helpers package the generated code calls, for a vendored or renamed copy")
+var helpersName = flag.String("helpersname", "", "name to refer to the helpers package by in the generated code, defaults to the last element of -helpers")
+var copyHelpers = flag.Bool("inlinehelpers", false, "copy the helpers the generated code uses into it instead of importing the helpers package")
+var configFile = flag.String("config", "", "JSON file of code generation options keyed by flag name, e.g. {\"longest\": true}, flags given on the command line override it")
+var localPrefix = flag.String("localprefix", "", "start the names of the locals that track the match in the generated FindFirstChar and Execute with this prefix, e.g. _rx_")
+var longest = flag.Bool("longest", false, "try the branches of top-level literal alternations longest first, approximating POSIX leftmost-longest")
 
 func main() {
 	flag.Parse()
@@ -39,10 +76,22 @@ func main() {
 		if opt != nil {
 			options = syntax.RegexOptions(*opt)
 		}
+		if *dot {
+			stream, _ := getOutStream()
+			if err := DumpTree(stream, *expr, options); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 		convertSingle(*expr, options, *pkg)
 		return
 	}
 
+	if len(*directivesFile) > 0 {
+		convertDirectives(*directivesFile)
+		return
+	}
+
 	convPath, _ := os.Getwd()
 	if path != nil && len(*path) > 0 {
 		convPath, _ = filepath.Abs(*path)
@@ -66,21 +115,74 @@ func getOutStream() (io.Writer, string) {
 	return file, outPath
 }
 
+// gets the code generation options from the -config file, if any, and the command line flags
+func getOptions() Options {
+	opts := Options{
+		LongestFirstAlternation: *longest,
+		InteriorLiteralSearch:   *interiorLiteral,
+		StreamMatch:             *streamMatch,
+		NamedGroupStruct:        *namedGroupStruct,
+		ByteOffsets:             *byteOffsets,
+		GroupMap:                *groupMap,
+		GroupMapEmpty:           *groupMapEmpty,
+		ExplainMatch:            *explainMatch,
+		ScanMethod:              *scanMethod,
+		LeadingSetTable:         *leadingSetTable,
+		AnnotateNodes:           *annotateNodes,
+		DecisionComments:        *decisionComments,
+		ChunkedPrefixScan:       *chunkedPrefixScan,
+		HorspoolPrefixScan:      *horspoolPrefixScan,
+		BinarySearchSets:        *binarySearchSets,
+		SetFuncTable:            *setFuncTable,
+		AsciiOnly:               *asciiOnly,
+		EntryTimeoutCheck:       *entryTimeout,
+		RecoverPanics:           *recoverPanics,
+		BacktrackDispatch:       *backtrackSwitch,
+		MaxUnrollSize:           *unroll,
+		SkipFormat:              *noFormat,
+		DebugAssertions:         *debugAssertions,
+		StackCookies:            *stackCookies,
+		HelpersPackage:          *helpersPackage,
+		HelpersName:             *helpersName,
+		InlineHelpers:           *copyHelpers,
+		LocalPrefix:             *localPrefix,
+	}
+	if len(*configFile) == 0 {
+		return opts
+	}
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		log.Fatalf("error reading config file: %v", err)
+	}
+	opts = Options{}
+	if err := ParseOptions(data, &opts); err != nil {
+		log.Fatal(errors.Wrap(err, "config file"))
+	}
+	// the flags given on the command line override the file
+	set := make(map[string]any)
+	flag.Visit(func(f *flag.Flag) {
+		if isOptionName(f.Name) {
+			set[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	if err := ParseOptionsMap(set, &opts); err != nil {
+		log.Fatal(errors.Wrap(err, "flags"))
+	}
+	return opts
+}
+
 func convertSingle(expr string, opts syntax.RegexOptions, pkg string) {
-	stream, _ := getOutStream()
+	stream, outFile := getOutStream()
 	if stream == nil {
 		log.Fatalf("unable to open output")
 	}
-	c, err := newConverter(stream, pkg)
+	c, err := generate(stream, pkg, expr, opts, getOptions())
 	if err != nil {
 		log.Fatal(errors.Wrap(err, "code generation error"))
 	}
-	if err := c.addRegexp("command line", "MyPattern", expr, opts); err != nil {
-		log.Fatal(errors.Wrap(err, "code generation error"))
-	}
-	if err := c.addFooter(); err != nil {
-		log.Fatal(errors.Wrap(err, "code generation error"))
-	}
+	writeDiffTest(c, outFile)
+	writeBenchTest(c, outFile)
 }
 
 func convertPath(path string, includeTest bool) {
@@ -126,7 +228,6 @@ func convertPath(path string, includeTest bool) {
 					for i, val := range varDec.Values {
 						ok, pat, opt, pos := isStaticCompileCall(val, alias)
 						if ok {
-							fset.Position(pos).String()
 							log.Printf("%s: adding pattern %#v options %v", fset.Position(pos), pat, opt)
 							// first find inits a converter
 							if c == nil {
@@ -134,7 +235,7 @@ func convertPath(path string, includeTest bool) {
 								if stream == nil {
 									log.Fatalf("unable to open output")
 								}
-								c, err = newConverter(stream, p)
+								c, err = newConverter(stream, p, getOptions())
 								if err != nil {
 									log.Fatal(errors.Wrap(err, "code generation error"))
 								}
@@ -156,7 +257,7 @@ func convertPath(path string, includeTest bool) {
 								if stream == nil {
 									log.Fatalf("unable to open output")
 								}
-								c, err = newConverter(stream, p)
+								c, err = newConverter(stream, p, getOptions())
 								if err != nil {
 									log.Fatal(errors.Wrap(err, "code generation error"))
 								}
@@ -177,6 +278,52 @@ func convertPath(path string, includeTest bool) {
 		if err := c.addFooter(); err != nil {
 			log.Fatal(errors.Wrap(err, "code generation error"))
 		}
+		writeDiffTest(c, outFile)
+		writeBenchTest(c, outFile)
+	}
+}
+
+// writes the differential test for the converted patterns next to the output file, if asked for
+func writeDiffTest(c *converter, outFile string) {
+	if !*diffTest {
+		return
+	}
+	if outFile == "" {
+		log.Fatal("-difftest needs an output file, set with -o")
+	}
+	file, err := os.Create(strings.TrimSuffix(outFile, ".go") + "_test.go")
+	if err != nil {
+		log.Fatalf("error creating test file: %v", err)
+	}
+	defer file.Close()
+	if err := c.writeDifferentialTest(file); err != nil {
+		log.Fatal(errors.Wrap(err, "code generation error"))
+	}
+}
+
+// writes the benchmarks of the converted patterns next to the output file, if asked for
+func writeBenchTest(c *converter, outFile string) {
+	if !*benchTest {
+		return
+	}
+	if outFile == "" {
+		log.Fatal("-bench needs an output file, set with -o")
+	}
+	input := ""
+	if len(*benchInput) > 0 {
+		b, err := os.ReadFile(*benchInput)
+		if err != nil {
+			log.Fatalf("error reading benchmark input: %v", err)
+		}
+		input = string(b)
+	}
+	file, err := os.Create(strings.TrimSuffix(outFile, ".go") + "_bench_test.go")
+	if err != nil {
+		log.Fatalf("error creating benchmark file: %v", err)
+	}
+	defer file.Close()
+	if err := c.writeBenchmarks(file, input); err != nil {
+		log.Fatal(errors.Wrap(err, "code generation error"))
 	}
 }
 
@@ -155,6 +155,22 @@ func (a *analysisResults) addMayBacktrack(node *syntax.RegexNode) {
 	a.mayBacktrack[node] = struct{}{}
 }
 
+// Gives a node built while emitting the same results as the node it stands in for.
+func (a *analysisResults) addLike(node, like *syntax.RegexNode) {
+	if a.IsAtomicByAncestor(like) {
+		a.isAtomicByAncestor[node] = struct{}{}
+	}
+	if _, ok := a.containsCapture[like]; ok {
+		a.containsCapture[node] = struct{}{}
+	}
+	if _, ok := a.mayBacktrack[like]; ok {
+		a.addMayBacktrack(node)
+	}
+	if _, ok := a.inLoops[like]; ok {
+		a.inLoops[node] = struct{}{}
+	}
+}
+
 func (a *analysisResults) IsInLoop(node *syntax.RegexNode) bool {
 	if !a.complete {
 		return true